@@ -0,0 +1,62 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cli runs the kubectl and helm clis, killing them and everything
+// they started when they run longer than a timeout.
+package cli
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// TimeoutError is returned when a command is killed for running longer than
+// the timeout
+type TimeoutError struct {
+	Program string // the cli that was run, kubectl or helm
+	Command string // the command it was given, like apply
+	Timeout time.Duration
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("%s %s timed out after %s", e.Program, e.Command, e.Timeout)
+}
+
+// Run will run cmd, which is the command of program, killing it and everything
+// it started when it runs longer than timeout so that a hung connection to the
+// API server can't stall event processing. A timeout of 0 never kills it.
+func Run(cmd *exec.Cmd, program, command string, timeout time.Duration) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		killProcessGroup(cmd)
+		<-done
+		return TimeoutError{Program: program, Command: command, Timeout: timeout}
+	}
+}
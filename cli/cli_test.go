@@ -0,0 +1,43 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli_test
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/cli"
+)
+
+func TestRun(t *testing.T) {
+	assert.Nil(t, cli.Run(exec.Command("true"), "true", "", time.Minute))
+	assert.NotNil(t, cli.Run(exec.Command("false"), "false", "", time.Minute))
+	assert.NotNil(t, cli.Run(exec.Command("/not/there"), "missing", "", 0))
+}
+
+func TestRunTimeout(t *testing.T) {
+	// the child of the shell is killed with it, or Run would wait for it
+	// to close the output
+	cmd := exec.Command("sh", "-c", "sleep 60 & wait")
+	cmd.Stdout = &bytes.Buffer{}
+	start := time.Now()
+	err := cli.Run(cmd, "sh", "wait", 50*time.Millisecond)
+	assert.True(t, time.Since(start) < 30*time.Second, "the command wasn't killed")
+	assert.Equal(t, cli.TimeoutError{Program: "sh", Command: "wait", Timeout: 50 * time.Millisecond}, err)
+	assert.EqualError(t, err, "sh wait timed out after 50ms")
+}
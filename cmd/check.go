@@ -15,7 +15,9 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -23,13 +25,21 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/spf13/cobra"
+	"github.com/wpengine/lostromos/manifest"
 	"github.com/wpengine/lostromos/tmpl"
+	"github.com/wpengine/lostromos/validation"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 var (
 	crFile  string
 	tmplDir string
+	strict  bool
+	perFile bool
+	values  string
+	schema  string
+
+	manifestSchemas string
 )
 
 var checkCmd = &cobra.Command{
@@ -48,6 +58,11 @@ func init() {
 	LostromosCmd.AddCommand(checkCmd)
 	checkCmd.Flags().StringVar(&crFile, "cr", "", "absolute path to a yaml file with your CR saved in it")
 	checkCmd.Flags().StringVar(&tmplDir, "templates", "", "absolute path to the directory with your template files")
+	checkCmd.Flags().BoolVar(&strict, "strict", false, "fail if the templates reference a field that is missing from the CR")
+	checkCmd.Flags().StringVar(&schema, "schema", "", "(optional) path to a CRD or openAPIV3Schema file to validate the CR against")
+	checkCmd.Flags().StringVar(&values, "values", "", "(optional) path to a yaml file with default values merged beneath the spec of the CR")
+	checkCmd.Flags().StringVar(&manifestSchemas, "manifest-schemas", "", "(optional) path to a directory of json schemas for kubernetes kinds to validate the rendered objects against")
+	checkCmd.Flags().BoolVar(&perFile, "per-file", false, "render every template file not starting with _ as its own document")
 }
 
 func check(out io.Writer) error {
@@ -83,6 +98,57 @@ func check(out io.Writer) error {
 	if err != nil {
 		return err
 	}
+	t, err := tmpl.Load(filepath.Join(tmplDir, "*.tmpl"), tmpl.Options{Strict: strict, PerFile: perFile})
+	if err != nil {
+		return err
+	}
+	if schema != "" {
+		s, err := validation.LoadFile(schema)
+		if err != nil {
+			return err
+		}
+		if err := s.Validate(r.Object); err != nil {
+			return fmt.Errorf("ERROR: your CR is not valid: %s", err)
+		}
+	}
+
 	cr := &tmpl.CustomResource{Resource: &r}
-	return tmpl.Parse(cr, filepath.Join(tmplDir, "*.tmpl"), out)
+	if values != "" {
+		defaults, err := tmpl.ReadValues(values)
+		if err != nil {
+			return err
+		}
+		spec, _ := r.Object["spec"].(map[string]interface{})
+		cr.Values = tmpl.MergeValues(defaults, spec)
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(cr, &buf); err != nil {
+		return err
+	}
+	if err = checkManifests(buf.Bytes()); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(out)
+	return err
+}
+
+// checkManifests will run the same validation on the rendered templates that
+// is done before they are applied.
+func checkManifests(rendered []byte) error {
+	var schemas *manifest.Schemas
+	if manifestSchemas != "" {
+		s, err := manifest.NewSchemas(manifestSchemas)
+		if err != nil {
+			return err
+		}
+		schemas = s
+	}
+	objs, err := manifest.Parse(rendered)
+	if err == nil {
+		err = manifest.Validate(objs, schemas)
+	}
+	if err != nil {
+		return fmt.Errorf("ERROR: your templates rendered invalid manifests: %s", err)
+	}
+	return nil
 }
@@ -40,6 +40,10 @@ var validtemplate = "---\n\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name:
 
 func TestCheckCommand(t *testing.T) {
 	for _, tt := range checktests {
+		perFile = false
+		values = ""
+		schema = ""
+		manifestSchemas = ""
 		tmplDir = tt.tmplDir
 		crFile = tt.crFile
 		var b bytes.Buffer
@@ -58,3 +62,76 @@ func TestCheckCommand(t *testing.T) {
 		}
 	}
 }
+
+var perFileTemplate = "---\n# Source: configmap.yaml.tmpl\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: nemo-configmap\n  labels:\n    app: nemo\n    component: nginx\ndata:\n  by: Disney\n---\n# Source: deployment.yaml.tmpl\napiVersion: apps/v1beta1\nkind: Deployment\nmetadata:\n  name: nemo-nginx\nspec:\n  replicas: 1\n  template:\n    metadata:\n      labels:\n        app: nemo\n        component: nginx\n    spec:\n      containers:\n      - name: nginx\n        image: nginx:alpine\n        ports:\n        - containerPort: 80\n"
+
+func TestCheckCommandPerFile(t *testing.T) {
+	perFile = true
+	defer func() { perFile = false }()
+	tmplDir = "../test/data/per-file-templates"
+	crFile = "../test/data/cr_nemo.yml"
+	var b bytes.Buffer
+
+	err := check(&b)
+
+	assert.Nil(t, err)
+	assert.Equal(t, perFileTemplate, b.String())
+}
+
+func TestCheckCommandValues(t *testing.T) {
+	perFile = true
+	values = "../test/data/values.yaml"
+	defer func() { perFile, values = false, "" }()
+	tmplDir = "../test/data/per-file-templates"
+	crFile = "../test/data/cr_nemo.yml"
+	var b bytes.Buffer
+
+	err := check(&b)
+
+	assert.Nil(t, err)
+	// By is in the CR spec so only replicas comes from the values
+	assert.Contains(t, b.String(), "  by: Disney\n")
+	assert.Contains(t, b.String(), "  replicas: 2\n")
+
+	values = "../test/data/missing.yaml"
+	b.Reset()
+	assert.NotNil(t, check(&b))
+}
+
+func TestCheckCommandSchema(t *testing.T) {
+	schema = "../test/data/crd_schema.yml"
+	defer func() { schema = "" }()
+	tmplDir = "../test/data/templates/"
+	crFile = "../test/data/cr_nemo.yml"
+	var b bytes.Buffer
+
+	assert.Nil(t, check(&b))
+	assert.Equal(t, validtemplate, b.String())
+
+	crFile = "../test/data/cr_invalid.yml"
+	b.Reset()
+	err := check(&b)
+	assert.EqualError(t, err, "ERROR: your CR is not valid: spec.By: is required; spec.replicas: must be of type integer, not string")
+	assert.Empty(t, b.String())
+}
+
+func TestCheckCommandManifests(t *testing.T) {
+	manifestSchemas = "../test/data/schemas"
+	defer func() { manifestSchemas = "" }()
+	tmplDir = "../test/data/templates/"
+	crFile = "../test/data/cr_nemo.yml"
+	var b bytes.Buffer
+
+	assert.Nil(t, check(&b))
+	assert.Equal(t, validtemplate, b.String())
+
+	perFile = true
+	defer func() { perFile = false }()
+	tmplDir = "../test/data/invalid-templates/"
+	b.Reset()
+	err := check(&b)
+	assert.EqualError(t, err, "ERROR: your templates rendered invalid manifests: "+
+		"document 1 (configmap.yaml.tmpl): metadata.name is required; "+
+		"document 2 (deployment.yaml.tmpl): Deployment nemo-nginx is not valid: spec.replicas: must be of type integer, not string")
+	assert.Empty(t, b.String())
+}
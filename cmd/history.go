@@ -0,0 +1,159 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	historyKubeConfig     string
+	historyNamespace      string
+	historyKind           string
+	historyStoreNamespace string
+	historyShowSecrets    bool
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history KIND[.GROUP] NAME [REVISION]",
+	Short: `List the revisions applied for a custom resource, or show the manifest of one.`,
+	Args:  cobra.RangeArgs(2, 3),
+	Run: func(command *cobra.Command, args []string) {
+		if err := history(os.Stdout, args); err != nil {
+			logger.Errorw("failed", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	LostromosCmd.AddCommand(historyCmd)
+	historyCmd.Flags().StringVar(&historyKubeConfig, "kube-config", filepath.Join(homeDir(), ".kube", "config"), "absolute path to the kubeconfig file")
+	historyCmd.Flags().StringVarP(&historyNamespace, "namespace", "n", "", "the namespace of the custom resource, empty for cluster scoped custom resources")
+	historyCmd.Flags().StringVar(&historyKind, "history-kind", "Secret", "the kind of object the history is kept in, Secret or ConfigMap")
+	historyCmd.Flags().StringVar(&historyStoreNamespace, "history-namespace", "default", "the namespace of the history of cluster scoped custom resources")
+	historyCmd.Flags().BoolVar(&historyShowSecrets, "show-secrets", false, "show the data of Secrets in the manifest of a revision instead of <redacted>")
+}
+
+func history(out io.Writer, args []string) error {
+	cfg, err := clientcmd.BuildConfigFromFlags("", historyKubeConfig)
+	if err != nil {
+		return err
+	}
+	store, err := inventory.NewHistoryStore(cfg, historyKind, historyStoreNamespace, 0)
+	if err != nil {
+		return err
+	}
+	inv, err := inventory.NewSecretStore(cfg, historyStoreNamespace)
+	if err != nil {
+		return err
+	}
+	cr := historyResource(args[0], args[1], historyNamespace)
+	// the revisions are selected by the uid of the custom resource, which
+	// its inventory is labelled with
+	uid, err := inv.UID(cr)
+	if err != nil {
+		return err
+	}
+	cr.SetUID(uid)
+	if len(args) == 2 {
+		return listRevisions(out, store, cr)
+	}
+	number, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("ERROR: invalid revision %s", args[2])
+	}
+	return showRevision(out, store, cr, number, historyShowSecrets)
+}
+
+// historyResource will return a custom resource with the kind, group, name
+// and namespace that its inventory and history are named by. The kind can be
+// followed by the group, like Character.stable.nicolerenee.io.
+func historyResource(kind, name, namespace string) *unstructured.Unstructured {
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	group := ""
+	if i := strings.Index(kind, "."); i >= 0 {
+		kind, group = kind[:i], kind[i+1:]
+	}
+	// the version isn't part of the names
+	cr.SetAPIVersion("v1")
+	if group != "" {
+		cr.SetAPIVersion(group + "/v1")
+	}
+	cr.SetKind(kind)
+	cr.SetName(name)
+	cr.SetNamespace(namespace)
+	return cr
+}
+
+// listRevisions will print a table of the revisions of the custom resource
+func listRevisions(out io.Writer, h inventory.History, cr *unstructured.Unstructured) error {
+	revs, err := h.List(cr)
+	if err != nil {
+		return err
+	}
+	if len(revs) == 0 {
+		return fmt.Errorf("ERROR: no history found for %s %s", cr.GetKind(), cr.GetName())
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tAPPLIED\tGENERATION\tTEMPLATES")
+	for _, rev := range revs {
+		checksum := rev.Checksum
+		if len(checksum) > 12 {
+			checksum = checksum[:12]
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", rev.Number, rev.Time.Format(time.RFC3339), rev.Generation, checksum)
+	}
+	return w.Flush()
+}
+
+// showRevision will print the manifest that was applied in a revision of the
+// custom resource, after comments with the rest of the revision. The data of
+// Secrets is redacted unless showSecrets is set.
+func showRevision(out io.Writer, h inventory.History, cr *unstructured.Unstructured, number int, showSecrets bool) error {
+	rev, err := h.Get(cr, number)
+	if err != nil {
+		return err
+	}
+	if rev == nil {
+		return fmt.Errorf("ERROR: revision %d of %s %s not found", number, cr.GetKind(), cr.GetName())
+	}
+	content := []byte(rev.Manifest)
+	if !showSecrets {
+		objs, err := manifest.Parse(content)
+		if err != nil {
+			return fmt.Errorf("ERROR: invalid manifest in revision %d: %s", number, err)
+		}
+		if content, err = manifest.Encode(manifest.Redactor{}.Redact(objs)); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(out, "# Revision: %d\n# Applied: %s\n# Generation: %d\n# Templates: %s\n%s",
+		rev.Number, rev.Time.Format(time.RFC3339), rev.Generation, rev.Checksum, content)
+	return err
+}
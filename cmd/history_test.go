@@ -0,0 +1,106 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/inventory"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeHistory is an inventory.History with the revisions of one custom
+// resource
+type fakeHistory []inventory.Revision
+
+func (f fakeHistory) Add(cr *unstructured.Unstructured, rev *inventory.Revision) error {
+	return nil
+}
+
+func (f fakeHistory) List(cr *unstructured.Unstructured) ([]inventory.Revision, error) {
+	return f, nil
+}
+
+func (f fakeHistory) Get(cr *unstructured.Unstructured, number int) (*inventory.Revision, error) {
+	for _, rev := range f {
+		if rev.Number == number {
+			return &rev, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f fakeHistory) Delete(cr *unstructured.Unstructured) error {
+	return nil
+}
+
+const revisionManifest = `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: nemo
+data:
+  pin: MTI=
+`
+
+var (
+	historyCR = &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind":     "Character",
+		"metadata": map[string]interface{}{"name": "nemo", "namespace": "ocean"},
+	}}
+	testHistory = fakeHistory{
+		{Number: 1, Manifest: "kind: ConfigMap\n", Generation: 1, Checksum: "0123456789abcdef", Time: time.Date(2017, 12, 1, 10, 0, 0, 0, time.UTC)},
+		{Number: 2, Manifest: revisionManifest, Generation: 3, Checksum: "fedcba9876543210", Time: time.Date(2017, 12, 2, 10, 0, 0, 0, time.UTC)},
+	}
+)
+
+func TestListRevisions(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, listRevisions(&b, testHistory, historyCR))
+	assert.Equal(t, "REVISION  APPLIED               GENERATION  TEMPLATES\n"+
+		"1         2017-12-01T10:00:00Z  1           0123456789ab\n"+
+		"2         2017-12-02T10:00:00Z  3           fedcba987654\n", b.String())
+
+	err := listRevisions(&b, fakeHistory{}, historyCR)
+	assert.EqualError(t, err, "ERROR: no history found for Character nemo")
+}
+
+func TestShowRevision(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, showRevision(&b, testHistory, historyCR, 2, false))
+	assert.Equal(t, "# Revision: 2\n# Applied: 2017-12-02T10:00:00Z\n# Generation: 3\n# Templates: fedcba9876543210\n"+
+		"---\napiVersion: v1\ndata:\n  pin: <redacted>\nkind: Secret\nmetadata:\n  name: nemo\n", b.String())
+
+	b.Reset()
+	assert.Nil(t, showRevision(&b, testHistory, historyCR, 2, true))
+	assert.Equal(t, "# Revision: 2\n# Applied: 2017-12-02T10:00:00Z\n# Generation: 3\n# Templates: fedcba9876543210\n"+revisionManifest, b.String())
+
+	err := showRevision(&b, testHistory, historyCR, 3, false)
+	assert.EqualError(t, err, "ERROR: revision 3 of Character nemo not found")
+}
+
+func TestHistoryResource(t *testing.T) {
+	cr := historyResource("Character.stable.nicolerenee.io", "nemo", "ocean")
+	assert.Equal(t, "Character", cr.GetKind())
+	assert.Equal(t, "stable.nicolerenee.io", cr.GroupVersionKind().Group)
+	assert.Equal(t, "lostromos-character.stable.nicolerenee.io-nemo", inventory.Name(cr))
+	assert.Equal(t, "ocean", cr.GetNamespace())
+
+	cr = historyResource("ConfigMap", "nemo", "")
+	assert.Equal(t, "lostromos-configmap-nemo", inventory.Name(cr))
+}
@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"path/filepath"
 
 	"net/http"
@@ -26,9 +27,13 @@ import (
 	"github.com/spf13/viper"
 	"github.com/wpengine/lostromos/crwatcher"
 	"github.com/wpengine/lostromos/helmctlr"
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
 	"github.com/wpengine/lostromos/printctlr"
 	"github.com/wpengine/lostromos/status"
+	"github.com/wpengine/lostromos/target"
 	"github.com/wpengine/lostromos/tmplctlr"
+	"github.com/wpengine/lostromos/validation"
 	"github.com/wpengine/lostromos/version"
 	"go.uber.org/zap"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -55,36 +60,134 @@ func init() {
 	startCmd.Flags().String("crd-version", "v1", "the version of the CRD you want monitored")
 	startCmd.Flags().String("crd-namespace", metav1.NamespaceNone, "(optional) the namespace of the CRD you want monitored, only needed for namespaced CRDs (ex: default)")
 	startCmd.Flags().String("crd-filter", "", "(optional) Annotation key to specify that the custom resource has opted in to watching by Lostromos")
+	startCmd.Flags().Bool("crd-validate", false, "(optional) validate custom resources against the openAPIV3Schema of the CRD before deploying them")
+	startCmd.Flags().String("crd-schema-file", "", "(optional) path to a CRD or openAPIV3Schema file to validate custom resources against instead of the schema from the cluster")
 	startCmd.Flags().String("helm-chart", "", "Path for helm chart")
 	startCmd.Flags().String("helm-ns", "default", "Namespace for resources deployed by helm")
 	startCmd.Flags().String("helm-prefix", "lostromos", "Prefix for release names in helm")
 	startCmd.Flags().String("helm-tiller", "tiller-deploy:44134", "Address for helm tiller")
 	startCmd.Flags().Bool("helm-wait", false, "Use the helm --wait flag for creating and updating releases")
 	startCmd.Flags().Int64("helm-wait-timeout", 120, "The time in seconds to wait for kubernetes resources to be created when doing a helm install or upgrade")
+	startCmd.Flags().Int64("helm-timeout", 300, "The time in seconds a helm 3 command can run, on top of the wait timeout, before it is killed, 0 never kills it")
+	startCmd.Flags().Int("helm-version", 2, "The major version of helm, 2 to install releases through tiller or 3 to install them with the helm 3 cli and keep them in Secrets")
+	startCmd.Flags().String("helm-tiller-namespace", "kube-system", "Namespace of helm tiller in target clusters")
 	startCmd.Flags().String("kube-config", filepath.Join(homeDir(), ".kube", "config"), "absolute path to the kubeconfig file. Only required if running outside-of-cluster.")
+	startCmd.Flags().String("target-kube-config", "", "(optional) path to the kubeconfig of the cluster to deploy into, instead of the cluster custom resources are watched in")
+	startCmd.Flags().String("target-secret-field", "", "(optional) dotted path of a custom resource field with the name of a Secret with the kubeconfig of the cluster to deploy it into (ex: spec.cluster)")
+	startCmd.Flags().String("target-secret-namespace", "default", "the namespace of the kubeconfig Secrets for cluster scoped custom resources")
 	startCmd.Flags().Bool("nop", false, "nop")
+	startCmd.Flags().Bool("dry-run", false, "only log and serve what the template controller would change, without changing anything")
+	startCmd.Flags().String("diff-endpoint", "/diff", "The URI for the endpoint with the changes found in dry run mode")
 	startCmd.Flags().String("server-address", ":8080", "The address and port for endpoints such as /metrics and /status")
 	startCmd.Flags().String("metrics-endpoint", "/metrics", "The URI for the metrics endpoint")
 	startCmd.Flags().String("status-endpoint", "/status", "The URI for the status endpoint")
 	startCmd.Flags().String("templates", "", "absolute path to the directory with your template files")
+	startCmd.Flags().Bool("template-strict", false, "fail rendering instead of applying when the templates reference a field that is missing from the CR")
+	startCmd.Flags().String("template-sets", "", "(optional) absolute path to a directory with a subdirectory of templates for each template set, used instead of --templates")
+	startCmd.Flags().String("template-set-annotation", "lostromos/template-set", "the annotation on a custom resource with the name of its template set")
+	startCmd.Flags().String("template-set-field", "", "(optional) dotted path of a custom resource field with the name of its template set, used when the annotation is missing (ex: spec.size)")
+	startCmd.Flags().String("template-set-default", "", "(optional) the template set for custom resources that don't pick one")
+	startCmd.Flags().String("template-values", "", "(optional) path to a yaml file with default values merged beneath the spec of each custom resource")
+	startCmd.Flags().String("template-namespace-values", "", "(optional) path to a directory with a <namespace>.yaml file of default values for each namespace")
+	startCmd.Flags().String("template-manifest-schemas", "", "(optional) path to a directory of json schemas for kubernetes kinds to validate the rendered objects against")
+	startCmd.Flags().Bool("template-owner-labels", true, "add labels with the custom resource name, namespace and uid to the rendered objects")
+	startCmd.Flags().Bool("template-owner-annotations", true, "add annotations with the custom resource and template to the rendered objects")
+	startCmd.Flags().Bool("template-owner-references", true, "add an ownerReference to the custom resource to rendered objects in the same namespace and cluster")
+	startCmd.Flags().StringSlice("template-redact-fields", nil, "(optional) dotted paths of fields masked in logs and dry run output for every kind, the data of Secrets is always masked (ex: spec.password)")
+	startCmd.Flags().String("template-client", "kubectl", "how rendered objects are sent to kubernetes, kubectl or api for server-side apply without kubectl")
+	startCmd.Flags().Bool("template-api-force", true, "with the api client, take fields owned by other field managers and log the conflict instead of failing the object")
+	startCmd.Flags().String("template-namespace", "", "(optional) the namespace for rendered objects that don't set one, defaults to the kubeconfig namespace with kubectl and default with the api client")
+	startCmd.Flags().String("template-kube-context", "", "(optional) the kubeconfig context to send rendered objects to, defaults to the current context")
+	startCmd.Flags().String("template-impersonate-user", "", "(optional) the user to impersonate when sending rendered objects to kubernetes")
+	startCmd.Flags().String("template-impersonate-service-account", "", "(optional) the name of a service account in the namespace of each custom resource to impersonate when sending its rendered objects to kubernetes")
+	startCmd.Flags().Bool("template-wait", false, "wait for Deployments, StatefulSets, DaemonSets, Jobs, PersistentVolumeClaims and LoadBalancer Services to be ready before marking a custom resource successful")
+	startCmd.Flags().Int64("template-wait-timeout", 120, "The time in seconds to wait for applied objects to be ready")
+	startCmd.Flags().Int64("template-kubectl-timeout", 300, "The time in seconds a kubectl command can run before it is killed, 0 never kills it")
+	startCmd.Flags().Int64("template-kubectl-slow", 30, "The time in seconds a kubectl command can run before it is logged as slow, 0 never logs it")
+	startCmd.Flags().Bool("template-inventory", false, "keep the manifest applied for each custom resource in an inventory Secret, and delete exactly those objects with the custom resource")
+	startCmd.Flags().String("template-inventory-namespace", "default", "the namespace of the inventory Secrets for cluster scoped custom resources")
+	startCmd.Flags().Bool("template-prune", false, "delete objects applied for a custom resource that are no longer rendered, uses the inventory")
+	startCmd.Flags().Bool("template-prune-dry-run", false, "only log the objects that would be pruned")
+	startCmd.Flags().Bool("template-rollback", false, "apply the last successfully applied manifest again when an apply or wait fails, uses the inventory")
+	startCmd.Flags().Bool("template-history", false, "keep each manifest applied for a custom resource as a numbered revision, uses the inventory")
+	startCmd.Flags().String("template-history-kind", "Secret", "the kind of object revisions are kept in, Secret or ConfigMap")
+	startCmd.Flags().Int("template-history-limit", 10, "the number of revisions kept for each custom resource, 0 keeps them all")
+	startCmd.Flags().Bool("template-drift", false, "watch the objects applied for custom resources and render a custom resource again when its objects are changed or deleted by something else, uses the ownership labels")
+	startCmd.Flags().Bool("template-drift-alert-only", false, "only log and count objects that drift instead of rendering the custom resource again")
+	startCmd.Flags().Bool("template-per-file", false, "render every template file not starting with _ as its own document instead of only the first one")
+	startCmd.Flags().Bool("template-watch", false, "reload the templates when they change in the templates directory or ConfigMaps")
+	startCmd.Flags().Bool("template-resync", false, "render all custom resources again after the templates are reloaded")
+	startCmd.Flags().String("template-source-kind", "ConfigMap", "the kind of object to load templates from, ConfigMap or Secret")
+	startCmd.Flags().String("template-source-namespace", "default", "the namespace of the ConfigMaps or Secrets to load templates from")
+	startCmd.Flags().StringSlice("template-source-names", nil, "(optional) names of the ConfigMaps or Secrets to load templates from instead of the templates directory")
+	startCmd.Flags().String("template-source-selector", "", "(optional) label selector for the ConfigMaps or Secrets to load templates from instead of the templates directory")
 
 	viperBindFlag("crd.name", startCmd.Flags().Lookup("crd-name"))
 	viperBindFlag("crd.group", startCmd.Flags().Lookup("crd-group"))
 	viperBindFlag("crd.version", startCmd.Flags().Lookup("crd-version"))
 	viperBindFlag("crd.namespace", startCmd.Flags().Lookup("crd-namespace"))
 	viperBindFlag("crd.filter", startCmd.Flags().Lookup("crd-filter"))
+	viperBindFlag("crd.validate", startCmd.Flags().Lookup("crd-validate"))
+	viperBindFlag("crd.schemaFile", startCmd.Flags().Lookup("crd-schema-file"))
 	viperBindFlag("helm.chart", startCmd.Flags().Lookup("helm-chart"))
 	viperBindFlag("helm.namespace", startCmd.Flags().Lookup("helm-ns"))
 	viperBindFlag("helm.releasePrefix", startCmd.Flags().Lookup("helm-prefix"))
 	viperBindFlag("helm.tiller", startCmd.Flags().Lookup("helm-tiller"))
 	viperBindFlag("helm.wait", startCmd.Flags().Lookup("helm-wait"))
 	viperBindFlag("helm.waitTimeout", startCmd.Flags().Lookup("helm-wait-timeout"))
+	viperBindFlag("helm.timeout", startCmd.Flags().Lookup("helm-timeout"))
+	viperBindFlag("helm.version", startCmd.Flags().Lookup("helm-version"))
+	viperBindFlag("helm.tillerNamespace", startCmd.Flags().Lookup("helm-tiller-namespace"))
 	viperBindFlag("k8s.config", startCmd.Flags().Lookup("kube-config"))
+	viperBindFlag("target.kubeConfig", startCmd.Flags().Lookup("target-kube-config"))
+	viperBindFlag("target.secretField", startCmd.Flags().Lookup("target-secret-field"))
+	viperBindFlag("target.secretNamespace", startCmd.Flags().Lookup("target-secret-namespace"))
 	viperBindFlag("nop", startCmd.Flags().Lookup("nop"))
+	viperBindFlag("dryRun", startCmd.Flags().Lookup("dry-run"))
+	viperBindFlag("server.diffEndpoint", startCmd.Flags().Lookup("diff-endpoint"))
 	viperBindFlag("server.address", startCmd.Flags().Lookup("server-address"))
 	viperBindFlag("server.metricsEndpoint", startCmd.Flags().Lookup("metrics-endpoint"))
 	viperBindFlag("server.statusEndpoint", startCmd.Flags().Lookup("status-endpoint"))
 	viperBindFlag("templates", startCmd.Flags().Lookup("templates"))
+	viperBindFlag("template.strict", startCmd.Flags().Lookup("template-strict"))
+	viperBindFlag("template.sets.dir", startCmd.Flags().Lookup("template-sets"))
+	viperBindFlag("template.sets.annotation", startCmd.Flags().Lookup("template-set-annotation"))
+	viperBindFlag("template.sets.field", startCmd.Flags().Lookup("template-set-field"))
+	viperBindFlag("template.sets.default", startCmd.Flags().Lookup("template-set-default"))
+	viperBindFlag("template.values", startCmd.Flags().Lookup("template-values"))
+	viperBindFlag("template.namespaceValues", startCmd.Flags().Lookup("template-namespace-values"))
+	viperBindFlag("template.manifestSchemas", startCmd.Flags().Lookup("template-manifest-schemas"))
+	viperBindFlag("template.ownership.labels", startCmd.Flags().Lookup("template-owner-labels"))
+	viperBindFlag("template.ownership.annotations", startCmd.Flags().Lookup("template-owner-annotations"))
+	viperBindFlag("template.ownership.ownerReferences", startCmd.Flags().Lookup("template-owner-references"))
+	viperBindFlag("template.redactFields", startCmd.Flags().Lookup("template-redact-fields"))
+	viperBindFlag("template.client", startCmd.Flags().Lookup("template-client"))
+	viperBindFlag("template.api.force", startCmd.Flags().Lookup("template-api-force"))
+	viperBindFlag("template.namespace", startCmd.Flags().Lookup("template-namespace"))
+	viperBindFlag("template.kubeContext", startCmd.Flags().Lookup("template-kube-context"))
+	viperBindFlag("template.impersonate.user", startCmd.Flags().Lookup("template-impersonate-user"))
+	viperBindFlag("template.impersonate.serviceAccount", startCmd.Flags().Lookup("template-impersonate-service-account"))
+	viperBindFlag("template.wait", startCmd.Flags().Lookup("template-wait"))
+	viperBindFlag("template.waitTimeout", startCmd.Flags().Lookup("template-wait-timeout"))
+	viperBindFlag("template.kubectl.timeout", startCmd.Flags().Lookup("template-kubectl-timeout"))
+	viperBindFlag("template.kubectl.slowThreshold", startCmd.Flags().Lookup("template-kubectl-slow"))
+	viperBindFlag("template.inventory.enabled", startCmd.Flags().Lookup("template-inventory"))
+	viperBindFlag("template.inventory.namespace", startCmd.Flags().Lookup("template-inventory-namespace"))
+	viperBindFlag("template.prune.enabled", startCmd.Flags().Lookup("template-prune"))
+	viperBindFlag("template.prune.dryRun", startCmd.Flags().Lookup("template-prune-dry-run"))
+	viperBindFlag("template.rollback", startCmd.Flags().Lookup("template-rollback"))
+	viperBindFlag("template.history.enabled", startCmd.Flags().Lookup("template-history"))
+	viperBindFlag("template.history.kind", startCmd.Flags().Lookup("template-history-kind"))
+	viperBindFlag("template.history.limit", startCmd.Flags().Lookup("template-history-limit"))
+	viperBindFlag("template.drift.enabled", startCmd.Flags().Lookup("template-drift"))
+	viperBindFlag("template.drift.alertOnly", startCmd.Flags().Lookup("template-drift-alert-only"))
+	viperBindFlag("template.perFile", startCmd.Flags().Lookup("template-per-file"))
+	viperBindFlag("template.watch", startCmd.Flags().Lookup("template-watch"))
+	viperBindFlag("template.resyncOnReload", startCmd.Flags().Lookup("template-resync"))
+	viperBindFlag("template.source.kind", startCmd.Flags().Lookup("template-source-kind"))
+	viperBindFlag("template.source.namespace", startCmd.Flags().Lookup("template-source-namespace"))
+	viperBindFlag("template.source.names", startCmd.Flags().Lookup("template-source-names"))
+	viperBindFlag("template.source.selector", startCmd.Flags().Lookup("template-source-selector"))
 }
 
 func homeDir() string {
@@ -110,7 +213,7 @@ func getKubeClient() (*restclient.Config, error) {
 	return clientcmd.BuildConfigFromFlags("", viper.GetString("k8s.config"))
 }
 
-func buildCRWatcher(cfg *restclient.Config) (*crwatcher.CRWatcher, error) {
+func buildCRWatcher(cfg *restclient.Config, ctlr crwatcher.ResourceController) (*crwatcher.CRWatcher, error) {
 	cwCfg := &crwatcher.Config{
 		PluralName: viper.GetString("crd.name"),
 		Group:      viper.GetString("crd.group"),
@@ -118,18 +221,24 @@ func buildCRWatcher(cfg *restclient.Config) (*crwatcher.CRWatcher, error) {
 		Namespace:  viper.GetString("crd.namespace"),
 		Filter:     viper.GetString("crd.filter"),
 	}
-	ctlr := getController()
 	l := &crLogger{logger: logger}
 	return crwatcher.NewCRWatcher(cwCfg, cfg, ctlr, l)
 }
 
-func getController() crwatcher.ResourceController {
+func getController(cfg *restclient.Config) (crwatcher.ResourceController, error) {
 	if viper.GetBool("nop") {
 		logger = logger.With("controller", "print")
 		logger.Info("nop specified, using the print controller")
-		return &printctlr.Controller{}
+		return &printctlr.Controller{}, nil
+	}
+	targets, err := buildTargets(cfg)
+	if err != nil {
+		return nil, err
 	}
 	if viper.GetString("helm.chart") != "" {
+		if viper.GetBool("dryRun") {
+			return nil, errors.New("dry run is only supported by the template controller")
+		}
 
 		chrt := viper.GetString("helm.chart")
 		hns := viper.GetString("helm.namespace")
@@ -137,6 +246,7 @@ func getController() crwatcher.ResourceController {
 		ht := viper.GetString("helm.tiller")
 		hw := viper.GetBool("helm.wait")
 		hwto := viper.GetInt64("helm.waitTimeout")
+		hv := viper.GetInt("helm.version")
 		logger = logger.With("controller", "helm")
 		logger.Infow("using helm controller for deployment",
 			"helmChart", chrt,
@@ -145,12 +255,253 @@ func getController() crwatcher.ResourceController {
 			"helmTiller", ht,
 			"helmWait", hw,
 			"helmWaitTimeout", hwto,
+			"helmTimeout", viper.GetInt64("helm.timeout"),
+			"helmVersion", hv,
 		)
-		return helmctlr.NewController(chrt, hns, hrn, ht, hw, hwto, logger)
+		var hc *helmctlr.Controller
+		switch hv {
+		case 2:
+			hc = helmctlr.NewController(chrt, hns, hrn, ht, hw, hwto, logger)
+		case 3:
+			hc = helmctlr.NewHelm3Controller(chrt, hns, hrn, viper.GetString("k8s.config"), hw, hwto, viper.GetInt64("helm.timeout"), logger)
+		default:
+			return nil, fmt.Errorf("unsupported helm version %d, must be 2 or 3", hv)
+		}
+		hc.Targets = targets
+		hc.TillerNamespace = viper.GetString("helm.tillerNamespace")
+		return hc, nil
+	}
+	tcfg := &tmplctlr.Config{
+		TemplateDir:        viper.GetString("templates"),
+		KubeConfig:         viper.GetString("k8s.config"),
+		KubeContext:        viper.GetString("template.kubeContext"),
+		Namespace:          viper.GetString("template.namespace"),
+		ImpersonateUser:    viper.GetString("template.impersonate.user"),
+		ImpersonateSA:      viper.GetString("template.impersonate.serviceAccount"),
+		Strict:             viper.GetBool("template.strict"),
+		PerFile:            viper.GetBool("template.perFile"),
+		SetsDir:            viper.GetString("template.sets.dir"),
+		SetAnnotation:      viper.GetString("template.sets.annotation"),
+		SetField:           viper.GetString("template.sets.field"),
+		DefaultSet:         viper.GetString("template.sets.default"),
+		ValuesFile:         viper.GetString("template.values"),
+		NamespaceValuesDir: viper.GetString("template.namespaceValues"),
+		ManifestSchemasDir: viper.GetString("template.manifestSchemas"),
+		Ownership: manifest.Ownership{
+			Labels:          viper.GetBool("template.ownership.labels"),
+			Annotations:     viper.GetBool("template.ownership.annotations"),
+			OwnerReferences: viper.GetBool("template.ownership.ownerReferences"),
+		},
+		Redactor:       manifest.Redactor{Fields: viper.GetStringSlice("template.redactFields")},
+		Prune:          viper.GetBool("template.prune.enabled"),
+		PruneDryRun:    viper.GetBool("template.prune.dryRun"),
+		DryRun:         viper.GetBool("dryRun"),
+		Wait:           viper.GetBool("template.wait"),
+		WaitTimeout:    viper.GetInt64("template.waitTimeout"),
+		KubectlTimeout: viper.GetInt64("template.kubectl.timeout"),
+		KubectlSlow:    viper.GetInt64("template.kubectl.slowThreshold"),
+		Rollback:       viper.GetBool("template.rollback"),
+		Drift:          viper.GetBool("template.drift.enabled"),
+		DriftAlertOnly: viper.GetBool("template.drift.alertOnly"),
+		Targets:        targets,
+	}
+	history := viper.GetBool("template.history.enabled")
+	// pruning, rollback and history need the inventory to know what was
+	// applied before
+	if tcfg.Prune || tcfg.Rollback || history || viper.GetBool("template.inventory.enabled") {
+		store, err := inventory.NewSecretStore(cfg, viper.GetString("template.inventory.namespace"))
+		if err != nil {
+			return nil, err
+		}
+		tcfg.Inventory = store
+	}
+	if history {
+		store, err := inventory.NewHistoryStore(
+			cfg,
+			viper.GetString("template.history.kind"),
+			viper.GetString("template.inventory.namespace"),
+			viper.GetInt("template.history.limit"),
+		)
+		if err != nil {
+			return nil, err
+		}
+		tcfg.History = store
+	}
+	names := viper.GetStringSlice("template.source.names")
+	selector := viper.GetString("template.source.selector")
+	if len(names) > 0 || selector != "" {
+		src, err := tmplctlr.NewConfigMapSource(
+			cfg,
+			viper.GetString("template.source.kind"),
+			viper.GetString("template.source.namespace"),
+			names,
+			selector,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tcfg.Source = src
 	}
 	logger = logger.With("controller", "template")
-	logger.Infow("using template controller for deployment", "templateDir", viper.GetString("templates"))
-	return tmplctlr.NewController(viper.GetString("templates"), viper.GetString("k8s.config"), logger)
+	logger.Infow("using template controller for deployment",
+		"templateDir", tcfg.TemplateDir,
+		"templateSetsDir", tcfg.SetsDir,
+		"templateSourceNames", names,
+		"templateSourceSelector", selector,
+		"templateStrict", tcfg.Strict,
+		"templatePerFile", tcfg.PerFile,
+		"dryRun", tcfg.DryRun,
+		"templateClient", viper.GetString("template.client"),
+		"templateAPIForce", viper.GetBool("template.api.force"),
+		"templateKubeContext", tcfg.KubeContext,
+		"templateImpersonateUser", tcfg.ImpersonateUser,
+		"templateImpersonateServiceAccount", tcfg.ImpersonateSA,
+		"templateRedactFields", tcfg.Redactor.Fields,
+		"templateWait", tcfg.Wait,
+		"templateWaitTimeout", tcfg.WaitTimeout,
+		"templateKubectlTimeout", tcfg.KubectlTimeout,
+		"templateKubectlSlowThreshold", tcfg.KubectlSlow,
+		"templateInventory", tcfg.Inventory != nil,
+		"templateRollback", tcfg.Rollback,
+		"templateHistory", history,
+		"templateDrift", tcfg.Drift,
+		"templateDriftAlertOnly", tcfg.DriftAlertOnly,
+		"targetKubeConfig", viper.GetString("target.kubeConfig"),
+		"targetSecretField", viper.GetString("target.secretField"),
+		"templatePrune", tcfg.Prune,
+		"templatePruneDryRun", tcfg.PruneDryRun,
+		"templateWatch", viper.GetBool("template.watch"),
+		"templateResyncOnReload", viper.GetBool("template.resyncOnReload"),
+	)
+	ctlr, err := tmplctlr.NewController(tcfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	switch client := viper.GetString("template.client"); client {
+	case "", "kubectl":
+	case "api":
+		acfg, err := templateKubeConfig(cfg, tcfg.KubeContext)
+		if err != nil {
+			return nil, err
+		}
+		ns := tcfg.Namespace
+		if ns == "" {
+			ns = "default"
+		}
+		newAPIClient := func(kubeCfg *restclient.Config) (tmplctlr.KubeClient, error) {
+			ac, err := tmplctlr.NewAPIClient(kubeCfg, ns)
+			if err != nil {
+				return nil, err
+			}
+			ac.Force = viper.GetBool("template.api.force")
+			ac.Logger = logger
+			return ac, nil
+		}
+		if ctlr.Client, err = newAPIClient(acfg); err != nil {
+			return nil, err
+		}
+		ctlr.ClusterClient = func(cl target.Cluster) (tmplctlr.KubeClient, error) {
+			kubeCfg, err := cl.Config()
+			if err != nil {
+				return nil, err
+			}
+			return newAPIClient(kubeCfg)
+		}
+	default:
+		return nil, fmt.Errorf("unknown template client %s, use kubectl or api", client)
+	}
+	if tcfg.Drift {
+		wcfg := cfg
+		if targets != nil && len(targets.Default.KubeConfig) > 0 {
+			// the objects are watched where they are deployed
+			if wcfg, err = targets.Default.Config(); err != nil {
+				return nil, err
+			}
+		}
+		if ctlr.Watcher, err = tmplctlr.NewKindWatcher(wcfg); err != nil {
+			return nil, err
+		}
+	}
+	return ctlr, nil
+}
+
+// buildTargets will return the resolver for the cluster each custom resource
+// is deployed into, or nil when they all go to the cluster they are in.
+func buildTargets(cfg *restclient.Config) (*target.Resolver, error) {
+	file := viper.GetString("target.kubeConfig")
+	field := viper.GetString("target.secretField")
+	if file == "" && field == "" {
+		return nil, nil
+	}
+	targets, err := target.NewResolver(cfg, field, viper.GetString("target.secretNamespace"))
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		if targets.Default, err = target.LoadCluster(file); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// templateKubeConfig will return the config for the cluster the template api
+// client sends objects to, which is cfg unless another context is picked.
+func templateKubeConfig(cfg *restclient.Config, context string) (*restclient.Config, error) {
+	if context == "" {
+		return cfg, nil
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: viper.GetString("k8s.config")},
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	).ClientConfig()
+}
+
+// validateResources will wrap the controller so that only custom resources
+// that are valid against the CRD schema are deployed, when validation is
+// enabled.
+func validateResources(cfg *restclient.Config, ctlr crwatcher.ResourceController) (crwatcher.ResourceController, error) {
+	var (
+		s   *validation.Schema
+		err error
+	)
+	switch {
+	case viper.GetString("crd.schemaFile") != "":
+		s, err = validation.LoadFile(viper.GetString("crd.schemaFile"))
+	case viper.GetBool("crd.validate"):
+		s, err = validation.Fetch(cfg, viper.GetString("crd.name"), viper.GetString("crd.group"))
+	default:
+		return ctlr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Infow("validating custom resources against the CRD schema", "schemaFile", viper.GetString("crd.schemaFile"))
+	return validation.NewController(s, ctlr, logger), nil
+}
+
+// watchTemplates will start reloading the templates on changes when the
+// template controller is in use and watching is enabled.
+func watchTemplates(ctlr crwatcher.ResourceController, crw *crwatcher.CRWatcher, stopCh <-chan struct{}) error {
+	tc, ok := ctlr.(*tmplctlr.Controller)
+	if !ok || !viper.GetBool("template.watch") {
+		return nil
+	}
+	if viper.GetBool("template.resyncOnReload") {
+		tc.Resync = crw.Resync
+	}
+	return tc.WatchTemplates(stopCh)
+}
+
+// watchDrift will start watching the objects applied by the template
+// controller for drift, reconciling custom resources with the watcher.
+func watchDrift(ctlr crwatcher.ResourceController, crw *crwatcher.CRWatcher, stopCh <-chan struct{}) error {
+	tc, ok := ctlr.(*tmplctlr.Controller)
+	if !ok || !tc.Config.Drift {
+		return nil
+	}
+	tc.Reconcile = crw.Reconcile
+	return tc.WatchDrift(stopCh)
 }
 
 type crLogger struct {
@@ -185,14 +536,31 @@ func startServer() error {
 	if err != nil {
 		return err
 	}
-	crw, err := buildCRWatcher(cfg)
+	ctlr, err := getController(cfg)
+	if err != nil {
+		return err
+	}
+	handler, err := validateResources(cfg, ctlr)
 	if err != nil {
 		return err
 	}
+	crw, err := buildCRWatcher(cfg, handler)
+	if err != nil {
+		return err
+	}
+	if err = watchTemplates(ctlr, crw, wait.NeverStop); err != nil {
+		return err
+	}
+	if err = watchDrift(ctlr, crw, wait.NeverStop); err != nil {
+		return err
+	}
 
 	// Set up Prometheus and Status endpoints.
 	http.Handle(viper.GetString("server.metricsEndpoint"), promhttp.Handler())
 	http.HandleFunc(viper.GetString("server.statusEndpoint"), status.Handler)
+	if tc, ok := ctlr.(*tmplctlr.Controller); ok && tc.Config.DryRun {
+		http.HandleFunc(viper.GetString("server.diffEndpoint"), tc.DiffHandler)
+	}
 	go func() {
 		err := http.ListenAndServe(viper.GetString("server.address"), nil)
 		if err != nil {
@@ -18,10 +18,15 @@ import (
 	"fmt"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/wpengine/lostromos/helmctlr"
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/printctlr"
+	"github.com/wpengine/lostromos/target"
 	"github.com/wpengine/lostromos/tmplctlr"
+	"github.com/wpengine/lostromos/validation"
 
 	"github.com/stretchr/testify/assert"
 	restclient "k8s.io/client-go/rest"
@@ -63,7 +68,7 @@ func TestBuildCRWatcherReturnsProperlyConfiguredWatcher(t *testing.T) {
 	viper.Set("crd.filter", crdFilter)
 
 	kubeCfg := &restclient.Config{}
-	crw, err := buildCRWatcher(kubeCfg)
+	crw, err := buildCRWatcher(kubeCfg, &printctlr.Controller{})
 	assert.NotNil(t, crw)
 	assert.Nil(t, err)
 	assert.Equal(t, crdGroup, crw.Config.Group)
@@ -83,8 +88,10 @@ func TestGetControllerReturnsHelmController(t *testing.T) {
 	viper.Set("helm.releasePrefix", prefix)
 	viper.Set("helm.tiller", tiller)
 
-	ctlr := getController().(*helmctlr.Controller)
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*helmctlr.Controller)
 
+	assert.Nil(t, err)
 	assert.NotNil(t, ctlr)
 	assert.Equal(t, ctlr.ChartDir, chart)
 	assert.Equal(t, ctlr.Namespace, ns)
@@ -92,15 +99,265 @@ func TestGetControllerReturnsHelmController(t *testing.T) {
 }
 
 func TestGetControllerReturnsTemplateController(t *testing.T) {
-	templates := "/path/templates"
+	templates := "../test/data/templates"
 	kubecfg := "/path/kubeconf"
 	viper.Set("templates", templates)
 	viper.Set("k8s.config", kubecfg)
 	viper.Set("helm.chart", "")
+	viper.Set("template.strict", true)
+	viper.Set("template.perFile", true)
+	defer viper.Set("template.perFile", false)
 
-	ctlr := getController().(*tmplctlr.Controller)
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*tmplctlr.Controller)
 
+	assert.Nil(t, err)
 	assert.NotNil(t, ctlr)
+	assert.Equal(t, templates, ctlr.Config.TemplateDir)
+	assert.Equal(t, kubecfg, ctlr.Config.KubeConfig)
+	assert.True(t, ctlr.Config.Strict)
+	assert.True(t, ctlr.Config.PerFile)
+	// ownership is on by default
+	assert.True(t, ctlr.Config.Ownership.Labels)
+	assert.True(t, ctlr.Config.Ownership.Annotations)
+	assert.True(t, ctlr.Config.Ownership.OwnerReferences)
+}
+
+func TestGetControllerReturnsTemplateControllerWithSets(t *testing.T) {
+	viper.Set("helm.chart", "")
+	viper.Set("template.sets.dir", "../test/data/template-sets")
+	viper.Set("template.sets.field", "spec.size")
+	viper.Set("template.sets.default", "small")
+	defer viper.Set("template.sets.dir", "")
+
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*tmplctlr.Controller)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "../test/data/template-sets", ctlr.Config.SetsDir)
+	assert.Equal(t, "spec.size", ctlr.Config.SetField)
+	assert.Equal(t, "small", ctlr.Config.DefaultSet)
+}
+
+func TestGetControllerReturnsTemplateControllerWithAPIClient(t *testing.T) {
+	viper.Set("templates", "../test/data/templates")
+	viper.Set("helm.chart", "")
+	viper.Set("template.client", "api")
+	viper.Set("template.namespace", "ocean")
+	defer viper.Set("template.client", "kubectl")
+
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*tmplctlr.Controller)
+
+	assert.Nil(t, err)
+	client := ctlr.Client.(*tmplctlr.APIClient)
+	assert.Equal(t, "ocean", client.Namespace)
+}
+
+func TestGetControllerReturnsTemplateControllerWithImpersonation(t *testing.T) {
+	viper.Set("templates", "../test/data/templates")
+	viper.Set("helm.chart", "")
+	viper.Set("template.kubeContext", "staging")
+	viper.Set("template.namespace", "ocean")
+	viper.Set("template.impersonate.serviceAccount", "deployer")
+	defer func() {
+		viper.Set("template.kubeContext", "")
+		viper.Set("template.namespace", "")
+		viper.Set("template.impersonate.serviceAccount", "")
+	}()
+
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*tmplctlr.Controller)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "deployer", ctlr.Config.ImpersonateSA)
+	client := ctlr.Client.(*tmplctlr.Kubectl)
+	assert.Equal(t, "staging", client.Context)
+	assert.Equal(t, "ocean", client.Namespace)
+}
+
+func TestGetControllerReturnsTemplateControllerWithTargets(t *testing.T) {
+	viper.Set("templates", "../test/data/templates")
+	viper.Set("helm.chart", "")
+	viper.Set("target.kubeConfig", path.Join("..", "test", "data", "kubeconfig"))
+	viper.Set("target.secretField", "spec.cluster")
+	viper.Set("target.secretNamespace", "clusters")
+	defer func() {
+		viper.Set("target.kubeConfig", "")
+		viper.Set("target.secretField", "")
+	}()
+
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*tmplctlr.Controller)
+
+	assert.Nil(t, err)
+	targets := ctlr.Config.Targets
+	assert.Equal(t, "spec.cluster", targets.Field)
+	assert.Equal(t, "clusters", targets.Namespace)
+	assert.Equal(t, target.DefaultName, targets.Default.Name)
+	assert.NotEmpty(t, targets.Default.KubeConfig)
+}
+
+func TestGetControllerReturnsHelmControllerWithTargets(t *testing.T) {
+	viper.Set("helm.chart", "/path/chart")
+	viper.Set("helm.tillerNamespace", "helm")
+	viper.Set("target.secretField", "spec.cluster")
+	defer func() {
+		viper.Set("helm.chart", "")
+		viper.Set("target.secretField", "")
+	}()
+
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*helmctlr.Controller)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "spec.cluster", ctlr.Targets.Field)
+	assert.Equal(t, "helm", ctlr.TillerNamespace)
+}
+
+func TestGetControllerReturnsHelm3Controller(t *testing.T) {
+	viper.Set("helm.chart", "/path/chart")
+	viper.Set("helm.version", 3)
+	viper.Set("k8s.config", "/path/kubeconfig")
+	defer func() {
+		viper.Set("helm.chart", "")
+		viper.Set("helm.version", 2)
+		viper.Set("k8s.config", "")
+	}()
+
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*helmctlr.Controller)
+
+	assert.Nil(t, err)
+	assert.Nil(t, ctlr.Helm)
+	assert.Equal(t, helmctlr.Helm3{KubeConfig: "/path/kubeconfig", Timeout: 300 * time.Second}, ctlr.Helm3)
+}
+
+func TestGetControllerFailsWithUnknownHelmVersion(t *testing.T) {
+	viper.Set("helm.chart", "/path/chart")
+	viper.Set("helm.version", 4)
+	defer func() {
+		viper.Set("helm.chart", "")
+		viper.Set("helm.version", 2)
+	}()
+
+	c, err := getController(&restclient.Config{})
+
+	assert.Nil(t, c)
+	assert.EqualError(t, err, "unsupported helm version 4, must be 2 or 3")
+}
+
+func TestGetControllerFailsWithMissingTargetKubeConfig(t *testing.T) {
+	viper.Set("templates", "../test/data/templates")
+	viper.Set("helm.chart", "")
+	viper.Set("target.kubeConfig", "/not/there")
+	defer viper.Set("target.kubeConfig", "")
+
+	c, err := getController(&restclient.Config{})
+
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
+}
+
+func TestGetControllerReturnsTemplateControllerWithHistory(t *testing.T) {
+	viper.Set("templates", "../test/data/templates")
+	viper.Set("helm.chart", "")
+	viper.Set("template.history.enabled", true)
+	viper.Set("template.history.kind", "Secret")
+	viper.Set("template.history.limit", 5)
+	defer viper.Set("template.history.enabled", false)
+
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*tmplctlr.Controller)
+
+	assert.Nil(t, err)
+	assert.NotNil(t, ctlr.Config.Inventory)
+	history := ctlr.Config.History.(*inventory.HistoryStore)
+	assert.Equal(t, "Secret", history.Kind)
+	assert.Equal(t, 5, history.Limit)
+}
+
+func TestGetControllerReturnsTemplateControllerWithDrift(t *testing.T) {
+	viper.Set("templates", "../test/data/templates")
+	viper.Set("helm.chart", "")
+	viper.Set("template.ownership.labels", true)
+	viper.Set("template.drift.enabled", true)
+	defer viper.Set("template.drift.enabled", false)
+
+	c, err := getController(&restclient.Config{})
+	ctlr := c.(*tmplctlr.Controller)
+
+	assert.Nil(t, err)
+	assert.True(t, ctlr.Config.Drift)
+	assert.NotNil(t, ctlr.Watcher)
+}
+
+func TestGetControllerFailsWithUnknownClient(t *testing.T) {
+	viper.Set("templates", "../test/data/templates")
+	viper.Set("helm.chart", "")
+	viper.Set("template.client", "helm")
+	defer viper.Set("template.client", "kubectl")
+
+	c, err := getController(&restclient.Config{})
+
+	assert.Nil(t, c)
+	assert.EqualError(t, err, "unknown template client helm, use kubectl or api")
+}
+
+func TestGetControllerFailsWithHelmDryRun(t *testing.T) {
+	viper.Set("helm.chart", "/path/chart")
+	viper.Set("dryRun", true)
+	defer viper.Set("helm.chart", "")
+	defer viper.Set("dryRun", false)
+
+	c, err := getController(&restclient.Config{})
+
+	assert.Nil(t, c)
+	assert.EqualError(t, err, "dry run is only supported by the template controller")
+}
+
+func TestGetControllerFailsWithInvalidTemplateDir(t *testing.T) {
+	viper.Set("templates", "/path/templates")
+	viper.Set("helm.chart", "")
+
+	c, err := getController(&restclient.Config{})
+
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
+}
+
+func TestGetControllerFailsWithInvalidTemplateSource(t *testing.T) {
+	viper.Set("helm.chart", "")
+	viper.Set("template.source.kind", "Deployment")
+	viper.Set("template.source.names", []string{"templates"})
+	defer viper.Set("template.source.names", nil)
+
+	c, err := getController(&restclient.Config{})
+
+	assert.Nil(t, c)
+	assert.EqualError(t, err, "templates can't be loaded from Deployment, only ConfigMap and Secret are supported")
+}
+
+func TestValidateResources(t *testing.T) {
+	next := &printctlr.Controller{}
+	viper.Set("crd.validate", false)
+	viper.Set("crd.schemaFile", "")
+	c, err := validateResources(&restclient.Config{}, next)
+	assert.Nil(t, err)
+	assert.Equal(t, next, c)
+
+	viper.Set("crd.schemaFile", "../test/data/crd_schema.yml")
+	defer viper.Set("crd.schemaFile", "")
+	c, err = validateResources(&restclient.Config{}, next)
+	assert.Nil(t, err)
+	if vc, ok := c.(*validation.Controller); assert.True(t, ok) {
+		assert.Equal(t, next, vc.Next)
+	}
+
+	viper.Set("crd.schemaFile", "../test/data/missing.yml")
+	c, err = validateResources(&restclient.Config{}, next)
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
 }
 
 func TestValidateOptions(t *testing.T) {
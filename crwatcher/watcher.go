@@ -27,6 +27,7 @@ import (
 	"k8s.io/client-go/dynamic"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 // Config provides config for a CRD Watcher
@@ -44,6 +45,7 @@ type CRWatcher struct {
 	Config     *Config
 	resource   dynamic.ResourceInterface
 	handler    cache.ResourceEventHandlerFuncs
+	queue      workqueue.Interface // events for handler, so that they are handled one at a time
 	store      cache.Store
 	controller cache.Controller
 	logger     ErrorLogger
@@ -79,6 +81,7 @@ type ErrorLogger interface {
 func NewCRWatcher(cfg *Config, kubeCfg *restclient.Config, rc ResourceController, l ErrorLogger) (*CRWatcher, error) {
 	cw := &CRWatcher{
 		Config: cfg,
+		queue:  workqueue.New(),
 		logger: l,
 	}
 
@@ -120,7 +123,15 @@ func (cw *CRWatcher) setupHandler(con ResourceController) {
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
-			r := obj.(*unstructured.Unstructured)
+			// a missed delete is only noticed on the next list, with the
+			// last state that was seen
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			r, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
 			if cw.passesFiltering(r) {
 				con.ResourceDeleted(r)
 			}
@@ -173,10 +184,49 @@ func (cw *CRWatcher) setupController() {
 		lw,
 		&unstructured.Unstructured{},
 		cw.Config.Resync,
-		cw.handler,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				cw.queue.Add(&event{newObj: obj})
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				cw.queue.Add(&event{oldObj: oldObj, newObj: newObj})
+			},
+			DeleteFunc: func(obj interface{}) {
+				cw.queue.Add(&event{oldObj: obj, deleted: true})
+			},
+		},
 	)
 }
 
+// event is a change to a custom resource waiting in the queue for the
+// handler. Events are pointers so that two of the same change are both
+// handled.
+type event struct {
+	oldObj  interface{} // unset for adds
+	newObj  interface{} // unset for deletes
+	deleted bool
+}
+
+// handleNext will wait for the next event in the queue and pass it to the
+// handler. It returns false once the queue is shut down.
+func (cw *CRWatcher) handleNext() bool {
+	item, shutdown := cw.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer cw.queue.Done(item)
+	e := item.(*event)
+	switch {
+	case e.deleted:
+		cw.handler.OnDelete(e.oldObj)
+	case e.oldObj == nil:
+		cw.handler.OnAdd(e.newObj)
+	default:
+		cw.handler.OnUpdate(e.oldObj, e.newObj)
+	}
+	return true
+}
+
 // passesFiltering checks to see if we are using an opt in filter (if not, then return true), and if so returns whether we
 // have an annotation matching the given filter.
 func (cw *CRWatcher) passesFiltering(r *unstructured.Unstructured) bool {
@@ -193,12 +243,47 @@ func (cw *CRWatcher) passesFiltering(r *unstructured.Unstructured) bool {
 	return ok
 }
 
+// Resync will queue an update to the controller for every custom resource
+// that has been seen, the same as when the resync period passes. This is
+// useful for rendering everything again when the controller configuration
+// changes. The updates are queued behind the watch events, so they are never
+// handled at the same time as another event.
+func (cw *CRWatcher) Resync() {
+	for _, obj := range cw.store.List() {
+		cw.queue.Add(&event{oldObj: obj, newObj: obj})
+	}
+}
+
+// Reconcile will queue an update to the controller for the custom resource
+// with the namespace and name, the same as a resync of only that custom
+// resource. It returns false when the custom resource hasn't been seen.
+func (cw *CRWatcher) Reconcile(namespace, name string) bool {
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+	obj, ok, err := cw.store.GetByKey(key)
+	if err != nil || !ok {
+		return false
+	}
+	cw.queue.Add(&event{oldObj: obj, newObj: obj})
+	return true
+}
+
 // Watch will be called to begin watching the configured custom resource. All
 // events will be passed back to the ResourceController
 func (cw *CRWatcher) Watch(stopCh <-chan struct{}) error {
 	if cw.controller == nil {
 		return errors.New("the CRWatcher has not been initialized")
 	}
+	go func() {
+		for cw.handleNext() {
+		}
+	}()
+	go func() {
+		<-stopCh
+		cw.queue.ShutDown()
+	}()
 	cw.controller.Run(stopCh)
 	return nil
 }
@@ -25,6 +25,8 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/wait"
 	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 type logResult struct {
@@ -161,6 +163,28 @@ func TestSetupHandlerDeleteFunc(t *testing.T) {
 	cw.handler.OnDelete(r)
 }
 
+func TestSetupHandlerDeleteFuncTombstone(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockRC := NewMockResourceController(mockCtrl)
+	cw := &CRWatcher{
+		Config: &Config{},
+	}
+	r := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name": "Thing1",
+			},
+		},
+	}
+	cw.setupHandler(mockRC)
+
+	mockRC.EXPECT().ResourceDeleted(r)
+
+	cw.handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "Thing1", Obj: r})
+}
+
 // Test to ensure that if we are given filter criteria we only call ResourceDeleted for a resource with the specified
 // filter.
 func TestSetupHandlerDeleteFuncUsesFilter(t *testing.T) {
@@ -293,3 +317,115 @@ func TestWatchReturnsErrorIfNotSetup(t *testing.T) {
 
 	assert.NotNil(t, err)
 }
+
+func TestResyncUpdatesAllResources(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockRC := NewMockResourceController(mockCtrl)
+	cw := &CRWatcher{
+		Config: &Config{},
+		store:  cache.NewStore(cache.MetaNamespaceKeyFunc),
+		queue:  workqueue.New(),
+	}
+	r1 := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name": "Thing1",
+			},
+		},
+	}
+	r2 := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name": "Thing2",
+			},
+		},
+	}
+	assert.Nil(t, cw.store.Add(r1))
+	assert.Nil(t, cw.store.Add(r2))
+	cw.setupHandler(mockRC)
+
+	mockRC.EXPECT().ResourceUpdated(r1, r1)
+	mockRC.EXPECT().ResourceUpdated(r2, r2)
+
+	cw.Resync()
+	// nothing is handled until the events are taken off the queue
+	assert.Equal(t, 2, cw.queue.Len())
+	assert.True(t, cw.handleNext())
+	assert.True(t, cw.handleNext())
+}
+
+func TestReconcileUpdatesOneResource(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockRC := NewMockResourceController(mockCtrl)
+	cw := &CRWatcher{
+		Config: &Config{},
+		store:  cache.NewStore(cache.MetaNamespaceKeyFunc),
+		queue:  workqueue.New(),
+	}
+	r1 := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":      "Thing1",
+				"namespace": "ocean",
+			},
+		},
+	}
+	r2 := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name": "Thing2",
+			},
+		},
+	}
+	assert.Nil(t, cw.store.Add(r1))
+	assert.Nil(t, cw.store.Add(r2))
+	cw.setupHandler(mockRC)
+
+	mockRC.EXPECT().ResourceUpdated(r1, r1)
+	mockRC.EXPECT().ResourceUpdated(r2, r2)
+
+	assert.True(t, cw.Reconcile("ocean", "Thing1"))
+	assert.True(t, cw.Reconcile("", "Thing2"))
+	assert.False(t, cw.Reconcile("", "Thing1"))
+	assert.Equal(t, 2, cw.queue.Len())
+	assert.True(t, cw.handleNext())
+	assert.True(t, cw.handleNext())
+}
+
+func TestHandleNextKeepsOrder(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockRC := NewMockResourceController(mockCtrl)
+	cw := &CRWatcher{
+		Config: &Config{},
+		queue:  workqueue.New(),
+	}
+	r := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name": "Thing1",
+			},
+		},
+	}
+	cw.setupHandler(mockRC)
+
+	gomock.InOrder(
+		mockRC.EXPECT().ResourceAdded(r),
+		mockRC.EXPECT().ResourceUpdated(r, r),
+		mockRC.EXPECT().ResourceUpdated(r, r),
+		mockRC.EXPECT().ResourceDeleted(r),
+	)
+
+	cw.queue.Add(&event{newObj: r})
+	cw.queue.Add(&event{oldObj: r, newObj: r})
+	cw.queue.Add(&event{oldObj: r, newObj: r})
+	cw.queue.Add(&event{oldObj: r, deleted: true})
+	cw.queue.ShutDown()
+	for cw.handleNext() {
+	}
+}
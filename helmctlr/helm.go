@@ -20,6 +20,7 @@ import (
 
 	"github.com/ghodss/yaml"
 	"github.com/wpengine/lostromos/metrics"
+	"github.com/wpengine/lostromos/target"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/helm/pkg/helm"
@@ -28,16 +29,28 @@ import (
 
 var defaultNS = "default"
 
+// defaultTillerNS is where tiller is in the target clusters unless
+// TillerNamespace is set
+var defaultTillerNS = "kube-system"
+
+// clusterRetryAfter is how long a target cluster that can't be reached fails
+// for before it is tried again
+var clusterRetryAfter = 30 * time.Second
+
 // Controller is a crwatcher.ResourceController that works with Helm to deploy
 // helm charts into K8s providing a CustomResource as value data to the charts
 type Controller struct {
-	ChartDir    string         // path to dir where the Helm chart is located
-	Helm        helm.Interface // Helm for talking with helm
-	Namespace   string         // Default namespace to deploy into. If empty it will default to "default"
-	ReleaseName string         // Prefix for the helm release name. Will look like ReleaseName-CR_Name
-	Wait        bool           // Whether or not to wait for resources during Update and Install before marking a release successful
-	WaitTimeout int64          // time in seconds to wait for kubernetes resources to be created before marking a release successful
-	logger      *zap.SugaredLogger
+	ChartDir        string           // path to dir where the Helm chart is located
+	Helm            helm.Interface   // Helm for talking with helm
+	Helm3           Helm3Client      // optional, used instead of Helm to install releases with helm 3, without tiller
+	Namespace       string           // Default namespace to deploy into. If empty it will default to "default"
+	ReleaseName     string           // Prefix for the helm release name. Will look like ReleaseName-CR_Name
+	Wait            bool             // Whether or not to wait for resources during Update and Install before marking a release successful
+	WaitTimeout     int64            // time in seconds to wait for kubernetes resources to be created before marking a release successful
+	Targets         *target.Resolver // optional, picks the cluster each CR is deployed into by the tiller in that cluster
+	TillerNamespace string           // namespace of tiller in the target clusters
+	clusters        *target.Cache    // helm clients for the target clusters
+	logger          *zap.SugaredLogger
 }
 
 // NewController will return a configured Helm Controller
@@ -58,6 +71,15 @@ func NewController(chartDir, ns, rn, host string, wait bool, waitto int64, logge
 		WaitTimeout: waitto,
 		logger:      logger,
 	}
+	c.TillerNamespace = defaultTillerNS
+	// a closure rather than c.tillerFor, so that TillerNamespace can still be
+	// changed after the controller is created
+	c.clusters = &target.Cache{
+		New: func(cl target.Cluster) (interface{}, error) {
+			return c.tillerFor(cl)
+		},
+		RetryAfter: clusterRetryAfter,
+	}
 	return c
 }
 
@@ -66,7 +88,9 @@ func NewController(chartDir, ns, rn, host string, wait bool, waitto int64, logge
 func (c Controller) ResourceAdded(r *unstructured.Unstructured) {
 	metrics.TotalEvents.Inc()
 	c.logger.Infow("resource added", "resource", r.GetName())
-	if err := c.installOrUpdate(r); err != nil {
+	err := c.installOrUpdate(r)
+	c.trackCluster(r, err)
+	if err != nil {
 		metrics.CreateFailures.Inc()
 		c.logger.Errorw("failed to create resource", "error", err, "resource", r.GetName())
 		return
@@ -83,6 +107,7 @@ func (c Controller) ResourceDeleted(r *unstructured.Unstructured) {
 	metrics.TotalEvents.Inc()
 	c.logger.Infow("resource deleted", "resource", r.GetName())
 	err := c.delete(r)
+	c.trackCluster(r, err)
 	if err != nil {
 		metrics.DeleteFailures.Inc()
 		c.logger.Errorw("failed to delete resource", "error", err, "resource", r.GetName())
@@ -98,7 +123,9 @@ func (c Controller) ResourceDeleted(r *unstructured.Unstructured) {
 func (c Controller) ResourceUpdated(oldR, newR *unstructured.Unstructured) {
 	metrics.TotalEvents.Inc()
 	c.logger.Infow("resource updated", "resource", newR.GetName())
-	if err := c.installOrUpdate(newR); err != nil {
+	err := c.installOrUpdate(newR)
+	c.trackCluster(newR, err)
+	if err != nil {
 		metrics.UpdateFailures.Inc()
 		c.logger.Errorw("failed to update resource", "error", err, "resource", newR.GetName())
 		return
@@ -108,8 +135,20 @@ func (c Controller) ResourceUpdated(oldR, newR *unstructured.Unstructured) {
 }
 
 func (c Controller) delete(r *unstructured.Unstructured) error {
+	if c.Helm3 != nil {
+		h, err := c.helm3(r)
+		if err != nil {
+			return err
+		}
+		_, err = h.Uninstall(c.releaseName(r), c.Namespace)
+		return err
+	}
+	h, err := c.helm(r)
+	if err != nil {
+		return err
+	}
 	rlsName := c.releaseName(r)
-	_, err := c.Helm.DeleteRelease(rlsName, helm.DeletePurge(true))
+	_, err = h.DeleteRelease(rlsName, helm.DeletePurge(true))
 	return err
 }
 
@@ -118,9 +157,21 @@ func (c Controller) installOrUpdate(r *unstructured.Unstructured) error {
 	if err != nil {
 		return err
 	}
+	if c.Helm3 != nil {
+		h, err := c.helm3(r)
+		if err != nil {
+			return err
+		}
+		_, err = h.Upgrade(c.releaseName(r), c.ChartDir, c.Namespace, cr, c.Wait, c.WaitTimeout)
+		return err
+	}
+	h, err := c.helm(r)
+	if err != nil {
+		return err
+	}
 	rlsName := c.releaseName(r)
-	if c.releaseExists(rlsName) {
-		_, err = c.Helm.UpdateRelease(
+	if c.releaseExists(h, rlsName) {
+		_, err = h.UpdateRelease(
 			rlsName,
 			c.ChartDir,
 			helm.UpdateValueOverrides(cr),
@@ -128,7 +179,7 @@ func (c Controller) installOrUpdate(r *unstructured.Unstructured) error {
 			helm.UpgradeTimeout(c.WaitTimeout))
 		return err
 	}
-	_, err = c.Helm.InstallRelease(
+	_, err = h.InstallRelease(
 		c.ChartDir,
 		c.Namespace,
 		helm.ReleaseName(rlsName),
@@ -148,7 +199,7 @@ func (c Controller) marshallCR(r *unstructured.Unstructured) ([]byte, error) {
 	return yaml.Marshal(re)
 }
 
-func (c Controller) releaseExists(rlsName string) bool {
+func (c Controller) releaseExists(h helm.Interface, rlsName string) bool {
 	statuses := []release.Status_Code{
 		release.Status_UNKNOWN,
 		release.Status_DEPLOYED,
@@ -159,7 +210,7 @@ func (c Controller) releaseExists(rlsName string) bool {
 		release.Status_PENDING_UPGRADE,
 		release.Status_PENDING_ROLLBACK,
 	}
-	r, err := c.Helm.ListReleases(
+	r, err := h.ListReleases(
 		helm.ReleaseListNamespace(c.Namespace),
 		helm.ReleaseListFilter(rlsName),
 		helm.ReleaseListStatuses(statuses),
@@ -178,3 +229,57 @@ func (c Controller) releaseExists(rlsName string) bool {
 func (c Controller) releaseName(r *unstructured.Unstructured) string {
 	return fmt.Sprintf("%s-%s", c.ReleaseName, r.GetName())
 }
+
+// helm will return the helm client for the target cluster of the custom
+// resource, which is Helm for the cluster lostromos is configured for
+func (c Controller) helm(r *unstructured.Unstructured) (helm.Interface, error) {
+	if c.Targets == nil {
+		return c.Helm, nil
+	}
+	cl, err := c.Targets.Cluster(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cl.KubeConfig) == 0 {
+		return c.Helm, nil
+	}
+	h, err := c.clusters.Get(cl)
+	if err != nil {
+		return nil, err
+	}
+	return h.(helm.Interface), nil
+}
+
+// helm3 will return the helm 3 client for the target cluster of the custom
+// resource, which is Helm3 for the cluster lostromos is configured for
+func (c Controller) helm3(r *unstructured.Unstructured) (Helm3Client, error) {
+	if c.Targets == nil {
+		return c.Helm3, nil
+	}
+	cl, err := c.Targets.Cluster(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cl.KubeConfig) == 0 {
+		return c.Helm3, nil
+	}
+	h, err := c.clusters.Get(cl)
+	if err != nil {
+		return nil, err
+	}
+	return h.(Helm3Client), nil
+}
+
+// trackCluster will count the event for the target cluster of the custom
+// resource, and check the cluster can still be reached when it failed
+func (c Controller) trackCluster(r *unstructured.Unstructured, err error) {
+	if c.Targets == nil {
+		return
+	}
+	name := c.Targets.Name(r)
+	metrics.ClusterEvents.WithLabelValues(name).Inc()
+	if err != nil {
+		metrics.ClusterFailures.WithLabelValues(name).Inc()
+		c.clusters.Check(name)
+	}
+}
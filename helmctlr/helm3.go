@@ -0,0 +1,155 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmctlr
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/wpengine/lostromos/cli"
+	"github.com/wpengine/lostromos/metrics"
+	"github.com/wpengine/lostromos/target"
+	"go.uber.org/zap"
+)
+
+// Helm3Client is what the controller needs from helm 3, which installs
+// releases straight into the cluster instead of through tiller
+type Helm3Client interface {
+	// Upgrade installs the release, or upgrades it when it already exists
+	Upgrade(name, chart, namespace string, values []byte, wait bool, timeout int64) (string, error)
+	// Uninstall deletes the release and its history
+	Uninstall(name, namespace string) (string, error)
+}
+
+// helmDriver is where helm 3 keeps the state of releases, Secrets in the
+// namespace of the release
+const helmDriver = "secret"
+
+// Helm3 provides a simple wrapper around the helm 3 cli, in the same way
+// Kubectl does for the template controller
+type Helm3 struct {
+	KubeConfig string        //optional, config file for helm instead of the default
+	Timeout    time.Duration //optional, how long helm can run, on top of the wait timeout, before it is killed
+}
+
+var execCommand = exec.Command
+
+// Upgrade will execute helm upgrade --install with the values in a temporary
+// file
+func (h Helm3) Upgrade(name, chart, namespace string, values []byte, wait bool, timeout int64) (string, error) {
+	f, err := ioutil.TempFile("", "lostromos-values")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(values)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	args := []string{"upgrade", name, chart, "--install", "--namespace", namespace, "--values", f.Name()}
+	deadline := h.Timeout
+	if wait {
+		args = append(args, "--wait", "--timeout", fmt.Sprintf("%ds", timeout))
+		if deadline > 0 {
+			deadline += time.Duration(timeout) * time.Second
+		}
+	}
+	return h.exec(deadline, args...)
+}
+
+// Uninstall will execute helm uninstall for the release
+func (h Helm3) Uninstall(name, namespace string) (string, error) {
+	return h.exec(h.Timeout, "uninstall", name, "--namespace", namespace)
+}
+
+// exec will run helm with the config, and release state kept in Secrets,
+// killing it when it runs longer than timeout
+func (h Helm3) exec(timeout time.Duration, args ...string) (string, error) {
+	if h.KubeConfig != "" {
+		args = append(args, "--kubeconfig", h.KubeConfig)
+	}
+	cmd := execCommand("helm", args...)
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, "HELM_DRIVER="+helmDriver)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cli.Run(cmd, "helm", args[0], timeout)
+	if _, ok := err.(cli.TimeoutError); ok {
+		metrics.KubectlTimeouts.WithLabelValues("helm " + args[0]).Inc()
+		return out.String(), err
+	}
+	if err != nil {
+		return out.String(), fmt.Errorf("helm %s failed: %s: %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
+
+// NewHelm3Controller will return a Helm Controller that uses the helm 3 cli
+// instead of tiller. kubeCfg is optional, the kubeconfig helm uses for the
+// cluster lostromos is configured for. timeout is the time in seconds helm can
+// run, on top of waitto when waiting, before it is killed, 0 never kills it.
+func NewHelm3Controller(chartDir, ns, rn, kubeCfg string, wait bool, waitto, timeout int64, logger *zap.SugaredLogger) *Controller {
+	c := NewController(chartDir, ns, rn, "", wait, waitto, logger)
+	c.Helm = nil
+	h := Helm3{KubeConfig: kubeCfg, Timeout: time.Duration(timeout) * time.Second}
+	c.Helm3 = h
+	c.clusters = &target.Cache{
+		New: func(cl target.Cluster) (interface{}, error) {
+			return helm3For(cl, h.Timeout)
+		},
+		RetryAfter: clusterRetryAfter,
+	}
+	return c
+}
+
+// helm3For will return a Helm3 for the target cluster, with its kubeconfig
+// written to a file that is removed when the client is replaced
+func helm3For(cl target.Cluster, timeout time.Duration) (Helm3Client, error) {
+	f, err := ioutil.TempFile("", "lostromos-kubeconfig")
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(cl.KubeConfig)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return clusterHelm3{Helm3{KubeConfig: f.Name(), Timeout: timeout}}, nil
+}
+
+// clusterHelm3 is a Helm3 that owns its kubeconfig file
+type clusterHelm3 struct {
+	Helm3
+}
+
+// Close will remove the kubeconfig file
+func (h clusterHelm3) Close() error {
+	return os.Remove(h.KubeConfig)
+}
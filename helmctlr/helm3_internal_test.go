@@ -0,0 +1,128 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmctlr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/cli"
+	"github.com/wpengine/lostromos/metrics"
+)
+
+func fakeExecCommand(command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestHelperProcess", "--", command}
+	cs = append(cs, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
+	return cmd
+}
+
+// TestHelperProcess prints the arguments, the storage driver and the values
+// file it was given
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args[3:]
+	fmt.Print(args, " ", os.Getenv("HELM_DRIVER"))
+	for i, arg := range args {
+		if arg == "--values" {
+			b, _ := ioutil.ReadFile(args[i+1])
+			fmt.Print(" ", string(b))
+		}
+		if arg == "HANG" {
+			time.Sleep(time.Minute)
+		}
+		if arg == "ERROR" {
+			fmt.Print(" release failed")
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestHelm3Upgrade(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	out, err := Helm3{}.Upgrade("lost-nemo", "/path/chart", "ocean", []byte("size: small"), false, 120)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(out, "[helm upgrade lost-nemo /path/chart --install --namespace ocean --values "), out)
+	assert.True(t, strings.HasSuffix(out, "] secret size: small"), out)
+}
+
+func TestHelm3UpgradeWait(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	out, err := Helm3{KubeConfig: "some_file"}.Upgrade("lost-nemo", "/path/chart", "ocean", nil, true, 120)
+	assert.Nil(t, err)
+	assert.Contains(t, out, " --wait --timeout 120s --kubeconfig some_file] secret")
+}
+
+func TestHelm3Uninstall(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	out, err := Helm3{}.Uninstall("lost-nemo", "ocean")
+	assert.Nil(t, err)
+	assert.Equal(t, "[helm uninstall lost-nemo --namespace ocean] secret", out)
+}
+
+func TestHelm3Error(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	out, err := Helm3{}.Uninstall("ERROR", "ocean")
+	assert.EqualError(t, err, "helm uninstall failed: exit status 1: [helm uninstall ERROR --namespace ocean] secret release failed")
+	assert.Equal(t, "[helm uninstall ERROR --namespace ocean] secret release failed", out)
+}
+
+func helmTimeouts(command string) float64 {
+	m := &dto.Metric{}
+	metrics.KubectlTimeouts.WithLabelValues(command).Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func TestHelm3Timeout(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	before := helmTimeouts("helm uninstall")
+	start := time.Now()
+	_, err := Helm3{Timeout: 50 * time.Millisecond}.Uninstall("HANG", "ocean")
+	assert.True(t, time.Since(start) < 30*time.Second, "helm wasn't killed")
+	assert.Equal(t, cli.TimeoutError{Program: "helm", Command: "uninstall", Timeout: 50 * time.Millisecond}, err)
+	assert.EqualError(t, err, "helm uninstall timed out after 50ms")
+	assert.Equal(t, float64(1), helmTimeouts("helm uninstall")-before)
+}
+
+func TestHelm3UpgradeTimeoutIncludesWait(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	_, err := Helm3{Timeout: 50 * time.Millisecond}.Upgrade("HANG", "/path/chart", "ocean", nil, true, 0)
+	assert.Equal(t, cli.TimeoutError{Program: "helm", Command: "upgrade", Timeout: 50 * time.Millisecond}, err)
+	_, err = Helm3{Timeout: time.Nanosecond}.Upgrade("lost-nemo", "/path/chart", "ocean", nil, true, 60)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmctlr_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/helmctlr"
+)
+
+// fakeHelm3 records the releases it is asked to upgrade and uninstall
+type fakeHelm3 struct {
+	upgraded    []string
+	values      string
+	uninstalled []string
+	err         error
+}
+
+func (f *fakeHelm3) Upgrade(name, chart, namespace string, values []byte, wait bool, timeout int64) (string, error) {
+	f.upgraded = append(f.upgraded, namespace+"/"+name+" "+chart)
+	f.values = string(values)
+	return "", f.err
+}
+
+func (f *fakeHelm3) Uninstall(name, namespace string) (string, error) {
+	f.uninstalled = append(f.uninstalled, namespace+"/"+name)
+	return "", f.err
+}
+
+func newHelm3Controller() (*helmctlr.Controller, *fakeHelm3) {
+	c := helmctlr.NewHelm3Controller("../test/data/chart", "lostromos-test", "lostromostest", "", false, 30, 0, nil)
+	h := &fakeHelm3{}
+	c.Helm3 = h
+	return c, h
+}
+
+func TestNewHelm3Controller(t *testing.T) {
+	c := helmctlr.NewHelm3Controller("chartDir", "", "release", "/path/kubeconfig", true, 120, 300, nil)
+	assert.Nil(t, c.Helm)
+	assert.Equal(t, helmctlr.Helm3{KubeConfig: "/path/kubeconfig", Timeout: 300 * time.Second}, c.Helm3)
+	assert.Equal(t, "default", c.Namespace)
+	assert.True(t, c.Wait)
+}
+
+func TestHelm3ResourceAdded(t *testing.T) {
+	c, h := newHelm3Controller()
+	ct := counterTest{
+		events:   1,
+		create:   1,
+		releases: 1,
+	}
+	tsExpected := timestampTestMap()
+	tsExpected["releases_last_create_timestamp_utc_seconds"] = greaterThan
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, tsExpected)
+
+	assert.Equal(t, []string{"lostromos-test/lostromostest-dory ../test/data/chart"}, h.upgraded)
+	assert.Contains(t, h.values, "name: dory")
+	assert.Contains(t, h.values, "By: Disney")
+}
+
+func TestHelm3ResourceUpdatedErrors(t *testing.T) {
+	c, h := newHelm3Controller()
+	h.err = errors.New("helm upgrade failed")
+	ct := counterTest{
+		events:    1,
+		updateErr: 1,
+	}
+	assertMetrics(t, ct, func() { c.ResourceUpdated(testResource, testResource) }, timestampTestMap())
+}
+
+func TestHelm3ResourceDeleted(t *testing.T) {
+	c, h := newHelm3Controller()
+	ct := counterTest{
+		events:   1,
+		delete:   1,
+		releases: -1,
+	}
+	tsExpected := timestampTestMap()
+	tsExpected["releases_last_delete_timestamp_utc_seconds"] = greaterThan
+	assertMetrics(t, ct, func() { c.ResourceDeleted(testResource) }, tsExpected)
+
+	assert.Equal(t, []string{"lostromos-test/lostromostest-dory"}, h.uninstalled)
+}
@@ -16,6 +16,8 @@ package helmctlr_test
 
 import (
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -24,6 +26,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/wpengine/lostromos/helmctlr"
 	"github.com/wpengine/lostromos/metrics"
+	"github.com/wpengine/lostromos/target"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/helm/pkg/proto/hapi/release"
 	"k8s.io/helm/pkg/proto/hapi/services"
@@ -151,6 +154,89 @@ func TestNewControllerSetsNS(t *testing.T) {
 	assert.Equal(t, "my_ns", c.Namespace, "Namespace should be set to the value provided")
 }
 
+func TestNewControllerSetsTillerNamespace(t *testing.T) {
+	c := helmctlr.NewController("chartDir", "", "release", "127.0.0.3:4321", false, 120, nil)
+	assert.Equal(t, "kube-system", c.TillerNamespace)
+}
+
+func getPromLabeledCounterValue(metric, label, value string) float64 {
+	mf, _ := prometheus.DefaultGatherer.Gather()
+	for _, s := range mf {
+		if s.GetName() != metric {
+			continue
+		}
+		for _, m := range s.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label && l.GetValue() == value {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestResourceDeletedFailsForUnreachableTargetCluster(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	c := helmctlr.NewController("../test/data/chart", "lostromos-test", "lostromostest", "0", false, 30, nil)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	// nothing is sent to the local tiller
+	c.Helm = NewMockInterface(mockCtrl)
+	c.Targets = &target.Resolver{Default: target.Cluster{
+		Name:       target.DefaultName,
+		KubeConfig: []byte("apiVersion: v1\nkind: Config\nclusters:\n- name: reef\n  cluster:\n    server: " + server.URL + "\ncontexts:\n- name: reef\n  context:\n    cluster: reef\ncurrent-context: reef\n"),
+	}}
+
+	before := getPromLabeledCounterValue("releases_cluster_error_total", "cluster", target.DefaultName)
+	ct := counterTest{
+		events:    1,
+		deleteErr: 1,
+	}
+	assertMetrics(t, ct, func() { c.ResourceDeleted(testResource) }, timestampTestMap())
+
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("releases_cluster_error_total", "cluster", target.DefaultName)-before)
+}
+
+func TestResourceDeletedUsesTillerNamespaceSetAfterNewController(t *testing.T) {
+	var podPaths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			podPaths = append(podPaths, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items": []}`))
+	}))
+	defer server.Close()
+	c := helmctlr.NewController("../test/data/chart", "lostromos-test", "lostromostest", "0", false, 30, nil)
+	c.TillerNamespace = "helm"
+	c.Targets = &target.Resolver{Default: target.Cluster{
+		Name:       "reef",
+		KubeConfig: []byte("apiVersion: v1\nkind: Config\nclusters:\n- name: reef\n  cluster:\n    server: " + server.URL + "\ncontexts:\n- name: reef\n  context:\n    cluster: reef\ncurrent-context: reef\n"),
+	}}
+
+	c.ResourceDeleted(testResource)
+
+	assert.Equal(t, []string{"/api/v1/namespaces/helm/pods"}, podPaths)
+}
+
+func TestResourceDeletedUsesLocalTillerWithoutTargetCluster(t *testing.T) {
+	c := helmctlr.NewController("../test/data/chart", "lostromos-test", "lostromostest", "0", false, 30, nil)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockHelm := NewMockInterface(mockCtrl)
+	c.Helm = mockHelm
+	// the custom resource doesn't name a cluster
+	c.Targets = &target.Resolver{Default: target.Cluster{Name: target.DefaultName}, Field: "spec.cluster"}
+	mockHelm.EXPECT().DeleteRelease(testReleaseName, gomock.Any())
+
+	before := getPromLabeledCounterValue("releases_cluster_events_total", "cluster", target.DefaultName)
+	c.ResourceDeleted(testResource)
+
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("releases_cluster_events_total", "cluster", target.DefaultName)-before)
+}
+
 func TestResourceAddedHappyPath(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
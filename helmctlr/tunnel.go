@@ -0,0 +1,132 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helmctlr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/wpengine/lostromos/target"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+	"k8s.io/helm/pkg/helm"
+)
+
+const (
+	// tillerPort is the port tiller listens on in its pod
+	tillerPort = 44134
+	// tillerSelector selects the tiller pods
+	tillerSelector = "app=helm,name=tiller"
+)
+
+// tunnel is a helm client for the tiller in a target cluster, reached
+// through a port forward to the tiller pod like the helm cli does
+type tunnel struct {
+	helm.Interface
+	stopCh chan struct{}
+}
+
+// Close will stop the port forward
+func (t *tunnel) Close() error {
+	close(t.stopCh)
+	return nil
+}
+
+// tillerFor will return a helm client for the tiller in the target cluster
+func (c Controller) tillerFor(cl target.Cluster) (interface{}, error) {
+	kubeCfg, err := cl.Config()
+	if err != nil {
+		return nil, err
+	}
+	cfg := *kubeCfg
+	cfg.ContentConfig = dynamic.ContentConfig()
+	cfg.ContentConfig.GroupVersion = &schema.GroupVersion{Version: "v1"}
+	cfg.APIPath = "/api"
+	rc, err := restclient.RESTClientFor(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := tillerPod(rc, c.TillerNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tiller in cluster %s: %s", cl.Name, err)
+	}
+	u := rc.Post().Namespace(c.TillerNamespace).Resource("pods").Name(pod).SubResource("portforward").URL()
+	transport, upgrader, err := spdy.RoundTripperFor(kubeCfg)
+	if err != nil {
+		return nil, err
+	}
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", u)
+	local, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	stopCh := make(chan struct{})
+	readyCh := make(chan struct{})
+	pf, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", local, tillerPort)}, stopCh, readyCh, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return nil, err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- pf.ForwardPorts()
+	}()
+	select {
+	case err := <-errCh:
+		return nil, fmt.Errorf("failed to forward a port to tiller in cluster %s: %s", cl.Name, err)
+	case <-readyCh:
+	}
+	return &tunnel{
+		Interface: helm.NewClient(helm.Host(fmt.Sprintf("127.0.0.1:%d", local))),
+		stopCh:    stopCh,
+	}, nil
+}
+
+// tillerPod will return the name of a running tiller pod in namespace
+func tillerPod(rc restclient.Interface, namespace string) (string, error) {
+	b, err := rc.Get().Namespace(namespace).Resource("pods").Param("labelSelector", tillerSelector).Do().Raw()
+	if err != nil {
+		return "", err
+	}
+	var list map[string]interface{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return "", err
+	}
+	items, _ := list["items"].([]interface{})
+	for _, item := range items {
+		pod := &unstructured.Unstructured{}
+		pod.Object, _ = item.(map[string]interface{})
+		if status, _ := pod.Object["status"].(map[string]interface{}); status["phase"] == "Running" {
+			return pod.GetName(), nil
+		}
+	}
+	return "", fmt.Errorf("no running pod in %s with labels %s", namespace, tillerSelector)
+}
+
+// freePort will return a local port that isn't being used
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
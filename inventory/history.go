@@ -0,0 +1,44 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Revision is a manifest that was applied successfully for a custom resource
+type Revision struct {
+	Number     int       // the Inventory.Revision the manifest was saved as
+	Manifest   string    // the yaml that was applied
+	Generation int64     // metadata.generation of the custom resource that was rendered
+	Checksum   string    // sha256 of the templates that were rendered
+	Time       time.Time // when the manifest was applied
+}
+
+// History keeps the revisions of each custom resource
+type History interface {
+	// Add saves a new Revision for the custom resource, and removes the
+	// oldest revisions past the history limit
+	Add(cr *unstructured.Unstructured, rev *Revision) error
+	// List returns the revisions of the custom resource, oldest first
+	List(cr *unstructured.Unstructured) ([]Revision, error)
+	// Get returns a Revision of the custom resource, or nil if there isn't
+	// one with that number
+	Get(cr *unstructured.Unstructured, number int) (*Revision, error)
+	// Delete removes every Revision of the custom resource
+	Delete(cr *unstructured.Unstructured) error
+}
@@ -0,0 +1,270 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wpengine/lostromos/manifest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+)
+
+const (
+	// RevisionLabel is set to the revision number on each ConfigMap or Secret
+	// of a HistoryStore
+	RevisionLabel = "lostromos.wpengine.io/revision"
+	// generationKey is the key with the generation of the custom resource
+	generationKey = "generation"
+	// checksumKey is the key with the checksum of the templates
+	checksumKey = "checksum"
+	// timeKey is the key with the time of the apply
+	timeKey = "time"
+	// maxRevisionSuffix is the longest suffix RevisionName adds to a name
+	maxRevisionSuffix = len(".v2147483647")
+)
+
+// HistoryStore keeps each Revision in its own ConfigMap or Secret in the
+// namespace of the custom resource, named by RevisionName.
+type HistoryStore struct {
+	Kind      string                                           // Secret or ConfigMap, defaults to Secret
+	Namespace string                                           // namespace for the history of cluster scoped custom resources
+	Limit     int                                              // revisions kept for each custom resource, 0 keeps them all
+	Client    func(namespace string) dynamic.ResourceInterface // client for the ConfigMaps or Secrets in a namespace
+}
+
+// NewHistoryStore will return a HistoryStore that keeps the revisions in
+// Secrets, or ConfigMaps when kind is ConfigMap. At most limit revisions are
+// kept for each custom resource, and the history of cluster scoped custom
+// resources is kept in namespace.
+func NewHistoryStore(kubeCfg *restclient.Config, kind, namespace string, limit int) (*HistoryStore, error) {
+	resource := "secrets"
+	switch strings.ToLower(kind) {
+	case "", "secret":
+		kind = "Secret"
+	case "configmap":
+		kind = "ConfigMap"
+		resource = "configmaps"
+	default:
+		return nil, fmt.Errorf("history can't be kept in %s, only ConfigMap and Secret are supported", kind)
+	}
+	cfg := *kubeCfg
+	cfg.ContentConfig.GroupVersion = &schema.GroupVersion{Version: "v1"}
+	cfg.APIPath = "/api"
+	dc, err := dynamic.NewClient(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	apiResource := &metav1.APIResource{Name: resource, Namespaced: true}
+	return &HistoryStore{
+		Kind:      kind,
+		Namespace: namespace,
+		Limit:     limit,
+		Client: func(ns string) dynamic.ResourceInterface {
+			return dc.Resource(apiResource, ns)
+		},
+	}, nil
+}
+
+// RevisionName will return the name of the ConfigMap or Secret for a revision
+// of the custom resource, the name of its inventory followed by .v<number>.
+func RevisionName(cr *unstructured.Unstructured, number int) string {
+	return revisionPrefix(cr) + strconv.Itoa(number)
+}
+
+func revisionPrefix(cr *unstructured.Unstructured) string {
+	return shorten(baseName(cr), maxNameLength-maxRevisionSuffix) + ".v"
+}
+
+// Add creates the ConfigMap or Secret for the revision, replacing one with
+// the same number, then deletes the oldest revisions past the Limit.
+func (s HistoryStore) Add(cr *unstructured.Unstructured, rev *Revision) error {
+	client := s.client(cr)
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       s.kind(),
+	}}
+	obj.SetName(RevisionName(cr, rev.Number))
+	obj.SetNamespace(s.namespace(cr))
+	obj.SetLabels(map[string]string{
+		manifest.ManagedByLabel: manifest.ManagedBy,
+		manifest.UIDLabel:       string(cr.GetUID()),
+		RevisionLabel:           strconv.Itoa(rev.Number),
+	})
+	obj.SetAnnotations(map[string]string{manifest.SourceAnnotation: manifest.Source(cr)})
+	obj.Object["data"] = s.encode(map[string]string{
+		manifestKey:   rev.Manifest,
+		generationKey: strconv.FormatInt(rev.Generation, 10),
+		checksumKey:   rev.Checksum,
+		timeKey:       rev.Time.UTC().Format(time.RFC3339),
+	})
+	_, err := client.Create(obj)
+	if apierrors.IsAlreadyExists(err) {
+		// the numbers start again when the inventory is removed by hand
+		var old *unstructured.Unstructured
+		if old, err = client.Get(obj.GetName(), metav1.GetOptions{}); err == nil {
+			obj.SetResourceVersion(old.GetResourceVersion())
+			_, err = client.Update(obj)
+		}
+	}
+	if err != nil || s.Limit <= 0 {
+		return err
+	}
+	revs, err := s.List(cr)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(revs)-s.Limit; i++ {
+		err = client.Delete(RevisionName(cr, revs[i].Number), &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the revisions in the ConfigMaps or Secrets of the custom
+// resource, sorted by number. They are selected by the uid of the custom
+// resource as well as their name, so the history of a custom resource that
+// was deleted and created again isn't mixed in.
+func (s HistoryStore) List(cr *unstructured.Unstructured) ([]Revision, error) {
+	l, err := s.client(cr).List(metav1.ListOptions{
+		LabelSelector: manifest.ManagedByLabel + "=" + manifest.ManagedBy + "," + RevisionLabel + "," + manifest.UIDLabel + "=" + string(cr.GetUID()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	list, ok := l.(*unstructured.UnstructuredList)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T listing %s", l, s.kind())
+	}
+	prefix := revisionPrefix(cr)
+	var revs []Revision
+	for i := range list.Items {
+		obj := &list.Items[i]
+		if obj.GetLabels()[manifest.UIDLabel] != string(cr.GetUID()) || !strings.HasPrefix(obj.GetName(), prefix) {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimPrefix(obj.GetName(), prefix))
+		if err != nil {
+			// the history of a custom resource named like <name>.v1
+			continue
+		}
+		rev, err := s.decode(obj, number)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, *rev)
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Number < revs[j].Number })
+	return revs, nil
+}
+
+// Get returns the revision in the ConfigMap or Secret named by RevisionName
+func (s HistoryStore) Get(cr *unstructured.Unstructured, number int) (*Revision, error) {
+	obj, err := s.client(cr).Get(RevisionName(cr, number), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.decode(obj, number)
+}
+
+// Delete removes the ConfigMaps or Secrets of every revision of the custom
+// resource
+func (s HistoryStore) Delete(cr *unstructured.Unstructured) error {
+	revs, err := s.List(cr)
+	if err != nil {
+		return err
+	}
+	client := s.client(cr)
+	for _, rev := range revs {
+		err := client.Delete(RevisionName(cr, rev.Number), &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode will return the data for a ConfigMap, or base64 encoded for a Secret
+func (s HistoryStore) encode(values map[string]string) map[string]interface{} {
+	data := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if s.kind() == "Secret" {
+			v = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		data[k] = v
+	}
+	return data
+}
+
+func (s HistoryStore) decode(obj *unstructured.Unstructured, number int) (*Revision, error) {
+	data, _ := obj.Object["data"].(map[string]interface{})
+	values := make(map[string]string, len(data))
+	for k, v := range data {
+		str, _ := v.(string)
+		if s.kind() == "Secret" {
+			b, err := base64.StdEncoding.DecodeString(str)
+			if err != nil {
+				return nil, fmt.Errorf("invalid revision in %s %s: %s", s.kind(), obj.GetName(), err)
+			}
+			str = string(b)
+		}
+		values[k] = str
+	}
+	rev := &Revision{Number: number, Manifest: values[manifestKey], Checksum: values[checksumKey]}
+	var err error
+	if v := values[generationKey]; v != "" {
+		if rev.Generation, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid revision in %s %s: %s", s.kind(), obj.GetName(), err)
+		}
+	}
+	if v := values[timeKey]; v != "" {
+		if rev.Time, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid revision in %s %s: %s", s.kind(), obj.GetName(), err)
+		}
+	}
+	return rev, nil
+}
+
+func (s HistoryStore) kind() string {
+	if s.Kind == "" {
+		return "Secret"
+	}
+	return s.Kind
+}
+
+func (s HistoryStore) client(cr *unstructured.Unstructured) dynamic.ResourceInterface {
+	return s.Client(s.namespace(cr))
+}
+
+func (s HistoryStore) namespace(cr *unstructured.Unstructured) string {
+	if ns := cr.GetNamespace(); ns != "" {
+		return ns
+	}
+	return s.Namespace
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+)
+
+func newHistoryStore(kind string, limit int) (*inventory.HistoryStore, map[string]*unstructured.Unstructured) {
+	objs := map[string]*unstructured.Unstructured{}
+	return &inventory.HistoryStore{
+		Kind:      kind,
+		Namespace: "lostromos",
+		Limit:     limit,
+		Client: func(namespace string) dynamic.ResourceInterface {
+			return &fakeObjects{namespace: namespace, objs: objs}
+		},
+	}, objs
+}
+
+func revision(number int) *inventory.Revision {
+	return &inventory.Revision{
+		Number:     number,
+		Manifest:   "kind: Service",
+		Generation: int64(number + 10),
+		Checksum:   "abc123",
+		Time:       time.Date(2017, 12, 1, 10, 0, number, 0, time.UTC),
+	}
+}
+
+func TestNewHistoryStore(t *testing.T) {
+	s, err := inventory.NewHistoryStore(&restclient.Config{}, "secret", "lostromos", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "Secret", s.Kind)
+	assert.Equal(t, "lostromos", s.Namespace)
+	assert.Equal(t, 5, s.Limit)
+	assert.NotNil(t, s.Client("default"))
+
+	s, err = inventory.NewHistoryStore(&restclient.Config{}, "", "lostromos", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "Secret", s.Kind)
+
+	_, err = inventory.NewHistoryStore(&restclient.Config{}, "Pod", "lostromos", 5)
+	assert.EqualError(t, err, "history can't be kept in Pod, only ConfigMap and Secret are supported")
+}
+
+func TestRevisionName(t *testing.T) {
+	assert.Equal(t, "lostromos-character-dory.v3", inventory.RevisionName(customResource("ocean", "dory"), 3))
+	cr := customResource("ocean", "dory")
+	cr.SetAPIVersion("stable.nicolerenee.io/v1")
+	assert.Equal(t, "lostromos-character.stable.nicolerenee.io-dory.v3", inventory.RevisionName(cr, 3))
+	assert.True(t, len(inventory.RevisionName(customResource("ocean", strings.Repeat("a", 253)), 2147483647)) <= 253)
+}
+
+func TestHistoryStore(t *testing.T) {
+	s, objs := newHistoryStore("ConfigMap", 0)
+	cr := customResource("ocean", "dory")
+
+	revs, err := s.List(cr)
+	assert.Nil(t, err)
+	assert.Empty(t, revs)
+	rev, err := s.Get(cr, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, rev)
+
+	assert.Nil(t, s.Add(cr, revision(1)))
+	assert.Nil(t, s.Add(cr, revision(2)))
+	// another custom resource that starts with the same name
+	assert.Nil(t, s.Add(customResource("ocean", "dory.v1"), revision(1)))
+	// a custom resource with the same name that was deleted
+	old := customResource("ocean", "dory")
+	old.SetUID("5678")
+	assert.Nil(t, s.Add(old, revision(3)))
+
+	cm := objs["ocean/lostromos-character-dory.v2"]
+	assert.NotNil(t, cm)
+	assert.Equal(t, "2", cm.GetLabels()[inventory.RevisionLabel])
+	assert.Equal(t, "1234", cm.GetLabels()[manifest.UIDLabel])
+	assert.Equal(t, "kind: Service", cm.Object["data"].(map[string]interface{})["manifest"])
+
+	revs, err = s.List(cr)
+	assert.Nil(t, err)
+	assert.Equal(t, []inventory.Revision{*revision(1), *revision(2)}, revs)
+	rev, err = s.Get(cr, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, revision(2), rev)
+
+	// the numbers started again
+	replaced := revision(1)
+	replaced.Checksum = "def456"
+	assert.Nil(t, s.Add(cr, replaced))
+	rev, err = s.Get(cr, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "def456", rev.Checksum)
+
+	assert.Nil(t, s.Delete(cr))
+	revs, err = s.List(cr)
+	assert.Nil(t, err)
+	assert.Empty(t, revs)
+	assert.Len(t, objs, 2)
+}
+
+func TestHistoryStoreLimit(t *testing.T) {
+	s, _ := newHistoryStore("ConfigMap", 2)
+	cr := customResource("ocean", "dory")
+
+	for i := 1; i <= 4; i++ {
+		assert.Nil(t, s.Add(cr, revision(i)))
+	}
+	revs, err := s.List(cr)
+	assert.Nil(t, err)
+	assert.Equal(t, []inventory.Revision{*revision(3), *revision(4)}, revs)
+}
+
+func TestHistoryStoreSecret(t *testing.T) {
+	s, objs := newHistoryStore("Secret", 0)
+	cr := customResource("", "dory")
+
+	assert.Nil(t, s.Add(cr, revision(1)))
+	secret := objs["lostromos/lostromos-character-dory.v1"]
+	assert.NotNil(t, secret)
+	assert.Equal(t, "Secret", secret.GetKind())
+	assert.Equal(t, "a2luZDogU2VydmljZQ==", secret.Object["data"].(map[string]interface{})["manifest"])
+
+	rev, err := s.Get(cr, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, revision(1), rev)
+}
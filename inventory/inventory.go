@@ -0,0 +1,42 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inventory keeps track of the objects that were applied to
+// Kubernetes for each custom resource.
+package inventory
+
+import (
+	"github.com/wpengine/lostromos/manifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Inventory is what was applied for a custom resource
+type Inventory struct {
+	Objects    []manifest.Ref // every object applied and not yet deleted
+	Manifest   string         // the yaml that was last applied successfully
+	Revision   int            // number of the successful apply the Manifest is from, starting at 1
+	Generation int64          // generation of the custom resource the Manifest was applied for
+}
+
+// Store saves an Inventory for each custom resource
+type Store interface {
+	// Get returns the Inventory for the custom resource, or nil if there
+	// isn't one
+	Get(cr *unstructured.Unstructured) (*Inventory, error)
+	// Save replaces the Inventory for the custom resource
+	Save(cr *unstructured.Unstructured, inv *Inventory) error
+	// Delete removes the Inventory for the custom resource, it isn't an
+	// error if there isn't one
+	Delete(cr *unstructured.Unstructured) error
+}
@@ -0,0 +1,215 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wpengine/lostromos/manifest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+)
+
+const (
+	// objectsKey is the Secret key with the json list of objects
+	objectsKey = "objects"
+	// manifestKey is the Secret key with the last applied manifest
+	manifestKey = "manifest"
+	// revisionKey is the Secret key with the revision of the manifest
+	revisionKey = "revision"
+	// maxNameLength is the longest name a Secret can have
+	maxNameLength = 253
+)
+
+// SecretStore keeps each Inventory in a Secret in the namespace of the custom
+// resource, named by Name. The manifest can have Secrets in it, so it isn't
+// kept in a ConfigMap.
+type SecretStore struct {
+	Namespace string                                           // namespace for the inventories of cluster scoped custom resources
+	Client    func(namespace string) dynamic.ResourceInterface // client for the Secrets in a namespace
+}
+
+// NewSecretStore will return a SecretStore that keeps the inventories of
+// cluster scoped custom resources in namespace.
+func NewSecretStore(kubeCfg *restclient.Config, namespace string) (*SecretStore, error) {
+	cfg := *kubeCfg
+	cfg.ContentConfig.GroupVersion = &schema.GroupVersion{Version: "v1"}
+	cfg.APIPath = "/api"
+	dc, err := dynamic.NewClient(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	apiResource := &metav1.APIResource{Name: "secrets", Namespaced: true}
+	return &SecretStore{
+		Namespace: namespace,
+		Client: func(ns string) dynamic.ResourceInterface {
+			return dc.Resource(apiResource, ns)
+		},
+	}, nil
+}
+
+// Name will return the name of the Secret for the custom resource,
+// lostromos-<kind>.<group>-<name>, or lostromos-<kind>-<name> for kinds in
+// the core group. Names that would be too long end in a hash of the full name
+// instead.
+func Name(cr *unstructured.Unstructured) string {
+	return shorten(baseName(cr), maxNameLength)
+}
+
+// baseName will return the name of the objects for the custom resource before
+// it is shortened. The group is in it so that kinds with the same name in
+// different groups don't share an inventory.
+func baseName(cr *unstructured.Unstructured) string {
+	kind := strings.ToLower(cr.GetKind())
+	if group := cr.GroupVersionKind().Group; group != "" {
+		kind += "." + group
+	}
+	return "lostromos-" + kind + "-" + cr.GetName()
+}
+
+// shorten will return name when it isn't longer than max, or the start of it
+// followed by a hash of the full name so that it is still unique.
+func shorten(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:16]
+	return strings.TrimRight(name[:max-len(hash)-1], "-.") + "-" + hash
+}
+
+// Get returns the Inventory in the Secret for the custom resource
+func (s SecretStore) Get(cr *unstructured.Unstructured) (*Inventory, error) {
+	secret, err := s.client(cr).Get(Name(cr), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{}
+	data := map[string]string{}
+	values, _ := secret.Object["data"].(map[string]interface{})
+	for k, v := range values {
+		str, _ := v.(string)
+		b, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inventory in Secret %s: %s", secret.GetName(), err)
+		}
+		data[k] = string(b)
+	}
+	if objects := data[objectsKey]; objects != "" {
+		if err := json.Unmarshal([]byte(objects), &inv.Objects); err != nil {
+			return nil, fmt.Errorf("invalid inventory in Secret %s: %s", secret.GetName(), err)
+		}
+	}
+	inv.Manifest = data[manifestKey]
+	if rev := data[revisionKey]; rev != "" {
+		if inv.Revision, err = strconv.Atoi(rev); err != nil {
+			return nil, fmt.Errorf("invalid revision in Secret %s: %s", secret.GetName(), err)
+		}
+	}
+	if gen := data[generationKey]; gen != "" {
+		if inv.Generation, err = strconv.ParseInt(gen, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid generation in Secret %s: %s", secret.GetName(), err)
+		}
+	}
+	return inv, nil
+}
+
+// Save creates or updates the Secret for the custom resource
+func (s SecretStore) Save(cr *unstructured.Unstructured, inv *Inventory) error {
+	objects, err := json.Marshal(inv.Objects)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{
+		objectsKey:    base64.StdEncoding.EncodeToString(objects),
+		manifestKey:   base64.StdEncoding.EncodeToString([]byte(inv.Manifest)),
+		revisionKey:   base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(inv.Revision))),
+		generationKey: base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(inv.Generation, 10))),
+	}
+
+	client := s.client(cr)
+	secret, err := client.Get(Name(cr), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"type":       "Opaque",
+			"data":       data,
+		}}
+		secret.SetName(Name(cr))
+		secret.SetNamespace(s.namespace(cr))
+		secret.SetLabels(map[string]string{
+			manifest.ManagedByLabel: manifest.ManagedBy,
+			manifest.UIDLabel:       string(cr.GetUID()),
+		})
+		secret.SetAnnotations(map[string]string{manifest.SourceAnnotation: manifest.Source(cr)})
+		_, err = client.Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	secret.Object["data"] = data
+	_, err = client.Update(secret)
+	return err
+}
+
+// UID returns the uid of the custom resource the Secret for it was saved for,
+// or an empty string if there isn't one. It finds the history of a custom
+// resource that is only known by its kind and name.
+func (s SecretStore) UID(cr *unstructured.Unstructured) (types.UID, error) {
+	secret, err := s.client(cr).Get(Name(cr), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return types.UID(secret.GetLabels()[manifest.UIDLabel]), nil
+}
+
+// Delete removes the Secret for the custom resource
+func (s SecretStore) Delete(cr *unstructured.Unstructured) error {
+	err := s.client(cr).Delete(Name(cr), &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s SecretStore) client(cr *unstructured.Unstructured) dynamic.ResourceInterface {
+	return s.Client(s.namespace(cr))
+}
+
+func (s SecretStore) namespace(cr *unstructured.Unstructured) string {
+	if ns := cr.GetNamespace(); ns != "" {
+		return ns
+	}
+	return s.Namespace
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inventory_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+)
+
+// fakeObjects implements the parts of dynamic.ResourceInterface used by
+// the SecretStore and HistoryStore
+type fakeObjects struct {
+	dynamic.ResourceInterface
+	namespace string
+	objs      map[string]*unstructured.Unstructured
+}
+
+func (f *fakeObjects) notFound(name string) error {
+	return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+}
+
+func (f *fakeObjects) Get(name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+	if obj, ok := f.objs[f.namespace+"/"+name]; ok {
+		return obj.DeepCopy(), nil
+	}
+	return nil, f.notFound(name)
+}
+
+func (f *fakeObjects) Create(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if _, ok := f.objs[f.namespace+"/"+obj.GetName()]; ok {
+		return nil, apierrors.NewAlreadyExists(schema.GroupResource{Resource: "configmaps"}, obj.GetName())
+	}
+	f.objs[f.namespace+"/"+obj.GetName()] = obj
+	return obj, nil
+}
+
+func (f *fakeObjects) Update(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if _, ok := f.objs[f.namespace+"/"+obj.GetName()]; !ok {
+		return nil, f.notFound(obj.GetName())
+	}
+	f.objs[f.namespace+"/"+obj.GetName()] = obj
+	return obj, nil
+}
+
+func (f *fakeObjects) List(opts metav1.ListOptions) (runtime.Object, error) {
+	list := &unstructured.UnstructuredList{}
+	for key, obj := range f.objs {
+		if strings.HasPrefix(key, f.namespace+"/") {
+			list.Items = append(list.Items, *obj.DeepCopy())
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeObjects) Delete(name string, opts *metav1.DeleteOptions) error {
+	if _, ok := f.objs[f.namespace+"/"+name]; !ok {
+		return f.notFound(name)
+	}
+	delete(f.objs, f.namespace+"/"+name)
+	return nil
+}
+
+func newStore() (*inventory.SecretStore, map[string]*unstructured.Unstructured) {
+	objs := map[string]*unstructured.Unstructured{}
+	return &inventory.SecretStore{
+		Namespace: "lostromos",
+		Client: func(namespace string) dynamic.ResourceInterface {
+			return &fakeObjects{namespace: namespace, objs: objs}
+		},
+	}, objs
+}
+
+func customResource(namespace, name string) *unstructured.Unstructured {
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	cr.SetKind("Character")
+	cr.SetNamespace(namespace)
+	cr.SetName(name)
+	cr.SetUID("1234")
+	return cr
+}
+
+func TestNewSecretStore(t *testing.T) {
+	s, err := inventory.NewSecretStore(&restclient.Config{}, "lostromos")
+	assert.Nil(t, err)
+	assert.Equal(t, "lostromos", s.Namespace)
+	assert.NotNil(t, s.Client("default"))
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "lostromos-character-dory", inventory.Name(customResource("ocean", "dory")))
+	// kinds with the same name in different groups
+	cr := customResource("ocean", "dory")
+	cr.SetAPIVersion("stable.nicolerenee.io/v1")
+	assert.Equal(t, "lostromos-character.stable.nicolerenee.io-dory", inventory.Name(cr))
+	cr.SetAPIVersion("reef.example.com/v1beta1")
+	assert.Equal(t, "lostromos-character.reef.example.com-dory", inventory.Name(cr))
+
+	long := inventory.Name(customResource("ocean", strings.Repeat("a", 253)))
+	assert.Len(t, long, 253)
+	assert.NotEqual(t, long, inventory.Name(customResource("ocean", strings.Repeat("a", 252)+"b")))
+}
+
+func TestSecretStore(t *testing.T) {
+	s, objs := newStore()
+	cr := customResource("ocean", "dory")
+
+	inv, err := s.Get(cr)
+	assert.Nil(t, err)
+	assert.Nil(t, inv)
+
+	refs := []manifest.Ref{{APIVersion: "v1", Kind: "Service", Namespace: "ocean", Name: "dory"}}
+	assert.Nil(t, s.Save(cr, &inventory.Inventory{Objects: refs, Manifest: "kind: Service", Revision: 3, Generation: 7}))
+	secret := objs["ocean/lostromos-character-dory"]
+	assert.NotNil(t, secret)
+	assert.Equal(t, "Secret", secret.GetKind())
+	assert.Equal(t, "1234", secret.GetLabels()[manifest.UIDLabel])
+	data := secret.Object["data"].(map[string]interface{})
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(`[{"apiVersion":"v1","kind":"Service","namespace":"ocean","name":"dory"}]`)), data["objects"])
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("kind: Service")), data["manifest"])
+
+	inv, err = s.Get(cr)
+	assert.Nil(t, err)
+	assert.Equal(t, refs, inv.Objects)
+	assert.Equal(t, "kind: Service", inv.Manifest)
+	assert.Equal(t, 3, inv.Revision)
+	assert.Equal(t, int64(7), inv.Generation)
+
+	assert.Nil(t, s.Save(cr, &inventory.Inventory{}))
+	inv, err = s.Get(cr)
+	assert.Nil(t, err)
+	assert.Empty(t, inv.Objects)
+
+	assert.Nil(t, s.Delete(cr))
+	assert.Empty(t, objs)
+	assert.Nil(t, s.Delete(cr))
+}
+
+func TestSecretStoreClusterScoped(t *testing.T) {
+	s, objs := newStore()
+	cr := customResource("", "dory")
+
+	assert.Nil(t, s.Save(cr, &inventory.Inventory{}))
+	assert.NotNil(t, objs["lostromos/lostromos-character-dory"])
+}
+
+func TestSecretStoreUID(t *testing.T) {
+	s, _ := newStore()
+	cr := customResource("ocean", "dory")
+
+	uid, err := s.UID(cr)
+	assert.Nil(t, err)
+	assert.Equal(t, "", string(uid))
+
+	assert.Nil(t, s.Save(cr, &inventory.Inventory{}))
+	uid, err = s.UID(customResource("ocean", "dory"))
+	assert.Nil(t, err)
+	assert.Equal(t, cr.GetUID(), uid)
+}
+
+func TestSecretStoreInvalidInventory(t *testing.T) {
+	s, objs := newStore()
+	cr := customResource("ocean", "dory")
+	objects := base64.StdEncoding.EncodeToString([]byte("{"))
+	objs["ocean/lostromos-character-dory"] = &unstructured.Unstructured{Object: map[string]interface{}{"data": map[string]interface{}{"objects": objects}}}
+
+	inv, err := s.Get(cr)
+	assert.Nil(t, inv)
+	assert.NotNil(t, err)
+
+	objs["ocean/lostromos-character-dory"] = &unstructured.Unstructured{Object: map[string]interface{}{"data": map[string]interface{}{"manifest": "not base64"}}}
+	inv, err = s.Get(cr)
+	assert.Nil(t, inv)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,230 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// maxValueLength is how much of a value is shown in a change
+const maxValueLength = 80
+
+// Changes will compare a rendered object with the live object and return a
+// line for every field set in the rendered object that has a different live
+// value, as "path: live -> rendered". Fields that are only in the live object
+// are ignored since they are defaulted by Kubernetes or set by someone else,
+// and so is the status. Numbers are equal when they have the same value, and
+// the stringData of a Secret is compared as the data it is stored as.
+func Changes(rendered, live map[string]interface{}) []string {
+	return changes(rendered, live, nil)
+}
+
+// changes will compare the objects like Changes, showing the values of fields
+// that sensitive returns true for as Redacted
+func changes(rendered, live map[string]interface{}, sensitive func(path string) bool) []string {
+	var changes []string
+	rendered = foldStringData(rendered)
+	keys := sortedKeys(rendered)
+	for _, key := range keys {
+		if key == "status" {
+			continue
+		}
+		l, ok := live[key]
+		changes = appendChanges(changes, key, rendered[key], l, ok, sensitive)
+	}
+	return changes
+}
+
+func appendChanges(changes []string, path string, rendered, live interface{}, found bool, sensitive func(string) bool) []string {
+	if !found {
+		return append(changes, fmt.Sprintf("%s: <none> -> %s", path, showField(path, rendered, sensitive)))
+	}
+	switch r := rendered.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(r) {
+			v, ok := l[key]
+			changes = appendChanges(changes, path+"."+key, r[key], v, ok, sensitive)
+		}
+		return changes
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(r) {
+			break
+		}
+		for i := range r {
+			changes = appendChanges(changes, path+"["+strconv.Itoa(i)+"]", r[i], l[i], true, sensitive)
+		}
+		return changes
+	}
+	if !equal(rendered, live) {
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", path, showField(path, live, sensitive), showField(path, rendered, sensitive)))
+	}
+	return changes
+}
+
+// AsApplied will return the fields set in the rendered object with the values
+// they have in the live object. The API server normalises some values, such
+// as a cpu of 0.5 that is stored as 500m, so comparing later versions of the
+// live object with this rather than the rendered object doesn't show those as
+// changes. Fields that aren't in the live object are left out, and lists
+// that are a different length are taken from the live object whole.
+func AsApplied(rendered, live map[string]interface{}) map[string]interface{} {
+	return asApplied(foldStringData(rendered), live)
+}
+
+func asApplied(rendered, live map[string]interface{}) map[string]interface{} {
+	applied := make(map[string]interface{}, len(rendered))
+	for key, r := range rendered {
+		if l, ok := live[key]; ok {
+			applied[key] = appliedValue(r, l)
+		}
+	}
+	return applied
+}
+
+func appliedValue(rendered, live interface{}) interface{} {
+	switch r := rendered.(type) {
+	case map[string]interface{}:
+		if l, ok := live.(map[string]interface{}); ok {
+			return asApplied(r, l)
+		}
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(r) {
+			break
+		}
+		applied := make([]interface{}, len(r))
+		for i := range r {
+			applied[i] = appliedValue(r[i], l[i])
+		}
+		return applied
+	}
+	return live
+}
+
+// equal will compare two values, with numbers that were decoded as different
+// types, such as a float64 from the rendered yaml and an int64 from the API
+// server, equal when they have the same value
+func equal(a, b interface{}) bool {
+	x, ok := number(a)
+	y, ok2 := number(b)
+	if ok && ok2 {
+		return x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// number will return a number as a string that is the same for every type
+// the number can be decoded as, and false when v isn't a number
+func number(v interface{}) (string, bool) {
+	var f float64
+	switch n := v.(type) {
+	case int:
+		return strconv.FormatInt(int64(n), 10), true
+	case int32:
+		return strconv.FormatInt(int64(n), 10), true
+	case int64:
+		return strconv.FormatInt(n, 10), true
+	case float32:
+		f = float64(n)
+	case float64:
+		f = n
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return strconv.FormatInt(i, 10), true
+		}
+		var err error
+		if f, err = n.Float64(); err != nil {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	if f == math.Trunc(f) && math.Abs(f) < math.MaxInt64 {
+		return strconv.FormatInt(int64(f), 10), true
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), true
+}
+
+// foldStringData will return a Secret with its stringData merged into data,
+// encoded the way the API server stores it, since stringData is write only
+// and never on the live object. Other objects are returned as they are.
+func foldStringData(obj map[string]interface{}) map[string]interface{} {
+	stringData, ok := obj["stringData"].(map[string]interface{})
+	if kind, _ := obj["kind"].(string); kind != "Secret" || !ok {
+		return obj
+	}
+	data := map[string]interface{}{}
+	if d, ok := obj["data"].(map[string]interface{}); ok {
+		for key, v := range d {
+			data[key] = v
+		}
+	}
+	for key, v := range stringData {
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		data[key] = base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	folded := make(map[string]interface{}, len(obj))
+	for key, v := range obj {
+		if key != "stringData" {
+			folded[key] = v
+		}
+	}
+	folded["data"] = data
+	return folded
+}
+
+// showField will show the value of the field at path, or Redacted when it is
+// sensitive
+func showField(path string, v interface{}, sensitive func(string) bool) string {
+	if sensitive != nil && sensitive(path) {
+		return Redacted
+	}
+	return show(v)
+}
+
+// show will return a short json representation of a value
+func show(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if len(b) > maxValueLength {
+		return string(b[:maxValueLength-3]) + "..."
+	}
+	return string(b)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+)
+
+func decode(t *testing.T, s string) map[string]interface{} {
+	var obj map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(s), &obj))
+	return obj
+}
+
+func TestChanges(t *testing.T) {
+	rendered := decode(t, `{
+		"kind": "Deployment",
+		"metadata": {"name": "nemo", "labels": {"app": "nemo", "size": "large"}},
+		"spec": {"replicas": 3, "template": {"spec": {"containers": [{"name": "nemo", "image": "nginx:2"}]}}}
+	}`)
+	live := decode(t, `{
+		"kind": "Deployment",
+		"metadata": {"name": "nemo", "resourceVersion": "12", "labels": {"app": "nemo"}},
+		"spec": {"replicas": 2, "template": {"spec": {"containers": [{"name": "nemo", "image": "nginx:1", "imagePullPolicy": "Always"}]}}},
+		"status": {"replicas": 2}
+	}`)
+
+	assert.Equal(t, []string{
+		`metadata.labels.size: <none> -> "large"`,
+		`spec.replicas: 2 -> 3`,
+		`spec.template.spec.containers[0].image: "nginx:1" -> "nginx:2"`,
+	}, manifest.Changes(rendered, live))
+}
+
+func TestChangesNone(t *testing.T) {
+	rendered := decode(t, `{"kind": "ConfigMap", "data": {"by": "Pixar"}, "status": {"ignored": true}}`)
+	live := decode(t, `{"kind": "ConfigMap", "data": {"by": "Pixar"}, "metadata": {"uid": "1234"}}`)
+
+	assert.Empty(t, manifest.Changes(rendered, live))
+}
+
+func TestChangesListLength(t *testing.T) {
+	rendered := decode(t, `{"spec": {"ports": [80, 443]}}`)
+	live := decode(t, `{"spec": {"ports": [80]}}`)
+
+	assert.Equal(t, []string{"spec.ports: [80] -> [80,443]"}, manifest.Changes(rendered, live))
+}
+
+func TestChangesNumbers(t *testing.T) {
+	// yaml and json decode numbers as float64, the API server as int64
+	rendered := decode(t, `{"kind": "Deployment", "spec": {"replicas": 3, "ports": [80], "ratio": 0.5}}`)
+	live := map[string]interface{}{"kind": "Deployment", "spec": map[string]interface{}{
+		"replicas": int64(3),
+		"ports":    []interface{}{int64(80)},
+		"ratio":    0.5,
+	}}
+	assert.Empty(t, manifest.Changes(rendered, live))
+
+	live["spec"].(map[string]interface{})["replicas"] = int64(2)
+	assert.Equal(t, []string{"spec.replicas: 2 -> 3"}, manifest.Changes(rendered, live))
+}
+
+func TestChangesSecretStringData(t *testing.T) {
+	rendered := decode(t, `{"kind": "Secret", "data": {"user": "bWFybGlu"}, "stringData": {"password": "hunter22"}}`)
+	live := decode(t, `{"kind": "Secret", "data": {"user": "bWFybGlu", "password": "aHVudGVyMjI="}}`)
+	assert.Empty(t, manifest.Changes(rendered, live))
+	// the rendered object isn't changed
+	assert.Contains(t, rendered, "stringData")
+
+	live["data"].(map[string]interface{})["password"] = "c3dpbQ=="
+	assert.Equal(t, []string{`data.password: "c3dpbQ==" -> "aHVudGVyMjI="`}, manifest.Changes(rendered, live))
+	assert.Equal(t, []string{"data.password: <redacted> -> <redacted>"}, manifest.Redactor{}.Changes(rendered, live))
+}
+
+func TestAsApplied(t *testing.T) {
+	rendered := decode(t, `{"kind": "Deployment", "metadata": {"name": "dory"}, "spec": {"template": {"spec": {"containers": [
+		{"name": "dory", "resources": {"requests": {"cpu": 0.5, "memory": "1Gi"}}}
+	]}}, "paused": false}}`)
+	live := decode(t, `{"kind": "Deployment", "metadata": {"name": "dory", "uid": "1234"}, "spec": {"replicas": 1, "template": {"spec": {"containers": [
+		{"name": "dory", "imagePullPolicy": "Always", "resources": {"requests": {"cpu": "500m", "memory": "1Gi"}}}
+	]}}}, "status": {"replicas": 1}}`)
+
+	applied := manifest.AsApplied(rendered, live)
+	assert.Equal(t, decode(t, `{"kind": "Deployment", "metadata": {"name": "dory"}, "spec": {"template": {"spec": {"containers": [
+		{"name": "dory", "resources": {"requests": {"cpu": "500m", "memory": "1Gi"}}}
+	]}}}}`), applied)
+	// only the rendered fields are compared, so the normalised quantity isn't a change
+	assert.Empty(t, manifest.Changes(applied, live))
+	assert.NotEmpty(t, manifest.Changes(rendered, live))
+}
+
+func TestAsAppliedSecretStringData(t *testing.T) {
+	rendered := decode(t, `{"kind": "Secret", "stringData": {"password": "hunter22"}}`)
+	live := decode(t, `{"kind": "Secret", "data": {"password": "aHVudGVyMjI="}, "type": "Opaque"}`)
+	assert.Equal(t, decode(t, `{"kind": "Secret", "data": {"password": "aHVudGVyMjI="}}`), manifest.AsApplied(rendered, live))
+}
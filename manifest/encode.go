@@ -0,0 +1,39 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"bytes"
+
+	"github.com/ghodss/yaml"
+)
+
+// Encode will write the objects back out as yaml documents. The template each
+// object came from is kept as a comment.
+func Encode(objs []Object) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, obj := range objs {
+		b, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("---\n")
+		if obj.Source != "" {
+			buf.WriteString(sourcePrefix + obj.Source + "\n")
+		}
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package manifest parses and validates the yaml documents rendered by the
+// templates before they are applied to Kubernetes.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// sourcePrefix marks the template a document was rendered from
+const sourcePrefix = "# Source: "
+
+// Object is a single kubernetes object from the rendered templates
+type Object struct {
+	*unstructured.Unstructured
+	Index  int    // position of the document in the rendered output, starting at 1
+	Source string // template the document was rendered from, when known
+}
+
+// Location will describe where the object came from for error messages
+func (o Object) Location() string {
+	if o.Source == "" {
+		return fmt.Sprintf("document %d", o.Index)
+	}
+	return fmt.Sprintf("document %d (%s)", o.Index, o.Source)
+}
+
+// Errors are all of the problems found in the rendered templates
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// document is the raw yaml of a single document
+type document struct {
+	index  int
+	source string
+	yaml   bytes.Buffer
+}
+
+// Parse will split the rendered templates into yaml documents and parse each
+// of them. Documents that are empty or only have comments are skipped. The
+// error is Errors with one error for every document that can't be parsed.
+func Parse(data []byte) ([]Object, error) {
+	var (
+		objs []Object
+		errs Errors
+	)
+	for _, doc := range split(data) {
+		j, err := yaml.YAMLToJSON(doc.yaml.Bytes())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid yaml: %s", doc.location(), err))
+			continue
+		}
+		var obj interface{}
+		if err = json.Unmarshal(j, &obj); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid yaml: %s", doc.location(), err))
+			continue
+		}
+		if obj == nil {
+			continue
+		}
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: must be an object", doc.location()))
+			continue
+		}
+		objs = append(objs, Object{
+			Unstructured: &unstructured.Unstructured{Object: m},
+			Index:        doc.index,
+			Source:       doc.source,
+		})
+	}
+	if len(errs) > 0 {
+		return objs, errs
+	}
+	return objs, nil
+}
+
+// split will break the rendered templates into documents on --- separators.
+// Documents with nothing but whitespace and comments are left out.
+func split(data []byte) []*document {
+	var (
+		docs    []*document
+		current = &document{}
+		content = false
+	)
+	finish := func() {
+		if content {
+			current.index = len(docs) + 1
+			docs = append(docs, current)
+		}
+		current = &document{}
+		content = false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "---" || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "---\t") {
+			finish()
+			// yaml allows content on the same line as the separator
+			line = strings.TrimSpace(line[3:])
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, sourcePrefix) && current.source == "" && !content {
+			current.source = strings.TrimPrefix(trimmed, sourcePrefix)
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			content = true
+		}
+		current.yaml.WriteString(line)
+		current.yaml.WriteString("\n")
+	}
+	finish()
+	return docs
+}
+
+func (d *document) location() string {
+	return Object{Index: d.index, Source: d.source}.Location()
+}
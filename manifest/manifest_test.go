@@ -0,0 +1,134 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+)
+
+const rendered = `---
+# Source: configmap.yaml.tmpl
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dory-configmap
+data:
+  by: Disney
+---
+# only a comment
+---
+
+--- apiVersion: apps/v1beta1
+kind: Deployment
+metadata:
+  name: dory-nginx
+  namespace: ocean
+`
+
+func TestParse(t *testing.T) {
+	objs, err := manifest.Parse([]byte(rendered))
+	assert.Nil(t, err)
+	if assert.Len(t, objs, 2) {
+		assert.Equal(t, "ConfigMap", objs[0].GetKind())
+		assert.Equal(t, "dory-configmap", objs[0].GetName())
+		assert.Equal(t, "document 1 (configmap.yaml.tmpl)", objs[0].Location())
+		assert.Equal(t, "Deployment", objs[1].GetKind())
+		assert.Equal(t, "ocean", objs[1].GetNamespace())
+		assert.Equal(t, "document 2", objs[1].Location())
+	}
+
+	objs, err = manifest.Parse([]byte(""))
+	assert.Nil(t, err)
+	assert.Empty(t, objs)
+}
+
+func TestParseErrors(t *testing.T) {
+	objs, err := manifest.Parse([]byte("---\n# Source: bad.tmpl\nname: [dory\n---\n- a list\n---\nname: ok\n"))
+	assert.Len(t, objs, 1)
+	errs, ok := err.(manifest.Errors)
+	if assert.True(t, ok) && assert.Len(t, errs, 2) {
+		assert.Contains(t, errs[0].Error(), "document 1 (bad.tmpl): invalid yaml: ")
+		assert.Equal(t, "document 2: must be an object", errs[1].Error())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	objs, err := manifest.Parse([]byte(`---
+# Source: configmap.yaml.tmpl
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dory
+---
+# Source: missing.yaml.tmpl
+metadata:
+  labels:
+    app: dory
+---
+# Source: duplicate.yaml.tmpl
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dory
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dory
+  namespace: ocean
+`))
+	assert.Nil(t, err)
+
+	err = manifest.Validate(objs, nil)
+	assert.EqualError(t, err, "document 2 (missing.yaml.tmpl): apiVersion, kind, metadata.name is required; "+
+		"document 3 (duplicate.yaml.tmpl): core/ConfigMap dory is also in document 1 (configmap.yaml.tmpl)")
+
+	assert.Nil(t, manifest.Validate(objs[3:], nil))
+}
+
+func TestValidateWithSchemas(t *testing.T) {
+	schemas, err := manifest.NewSchemas("../test/data/schemas")
+	assert.Nil(t, err)
+	objs, err := manifest.Parse([]byte(`---
+apiVersion: apps/v1beta1
+kind: Deployment
+metadata:
+  name: dory
+spec:
+  replicas: two
+---
+apiVersion: apps/v1beta1
+kind: Deployment
+metadata:
+  name: nemo
+spec:
+  replicas: 2
+---
+apiVersion: stable.nicolerenee.io/v1
+kind: Character
+metadata:
+  name: no-schema
+`))
+	assert.Nil(t, err)
+
+	err = manifest.Validate(objs, schemas)
+	assert.EqualError(t, err, "document 1: Deployment dory is not valid: spec.replicas: must be of type integer, not string")
+
+	_, err = manifest.NewSchemas("../test/data/missing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// WaveAnnotation on a rendered object is the wave it is applied in, a whole
+// number. Lower waves are applied first and deleted last, and objects without
+// it are in wave 0.
+const WaveAnnotation = "lostromos.wpengine.io/wave"
+
+// installOrder is the order kinds are applied in within a wave, so that the
+// objects others need exist first. Kinds that aren't here, such as custom
+// resources, come after all of these.
+var installOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"CustomResourceDefinition",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+var kindRank = func() map[string]int {
+	ranks := make(map[string]int, len(installOrder))
+	for i, kind := range installOrder {
+		ranks[kind] = i
+	}
+	return ranks
+}()
+
+// Wave will return the wave of the object from WaveAnnotation, 0 when it
+// isn't set or isn't a whole number
+func Wave(obj *unstructured.Unstructured) int {
+	w, _ := wave(obj)
+	return w
+}
+
+func wave(obj *unstructured.Unstructured) (int, error) {
+	v, ok := obj.GetAnnotations()[WaveAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimSpace(v))
+}
+
+// Sort will put the objects in the order they are applied in, by wave and
+// then by kind. Objects that are equal stay in the order they were rendered.
+func Sort(objs []Object) {
+	sort.SliceStable(objs, func(i, j int) bool { return before(objs[i], objs[j]) })
+}
+
+// SortForDelete will put the objects in the order they are deleted in, the
+// reverse of Sort
+func SortForDelete(objs []Object) {
+	sort.SliceStable(objs, func(i, j int) bool { return before(objs[j], objs[i]) })
+}
+
+// IsSorted will say whether the objects are already in the order Sort puts
+// them in
+func IsSorted(objs []Object) bool {
+	return sort.SliceIsSorted(objs, func(i, j int) bool { return before(objs[i], objs[j]) })
+}
+
+// Waves will split sorted objects into the objects of each wave, keeping
+// their order
+func Waves(objs []Object) [][]Object {
+	var waves [][]Object
+	for i, obj := range objs {
+		if i == 0 || Wave(obj.Unstructured) != Wave(objs[i-1].Unstructured) {
+			waves = append(waves, nil)
+		}
+		waves[len(waves)-1] = append(waves[len(waves)-1], obj)
+	}
+	return waves
+}
+
+// before will say whether a is applied before b
+func before(a, b Object) bool {
+	if wa, wb := Wave(a.Unstructured), Wave(b.Unstructured); wa != wb {
+		return wa < wb
+	}
+	return rank(a.GetKind()) < rank(b.GetKind())
+}
+
+func rank(kind string) int {
+	if r, ok := kindRank[kind]; ok {
+		return r
+	}
+	return len(installOrder)
+}
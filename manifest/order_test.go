@@ -0,0 +1,109 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+)
+
+const unordered = `---
+apiVersion: apps/v1beta1
+kind: Deployment
+metadata:
+  name: dory
+---
+apiVersion: stable.nicolerenee.io/v1
+kind: Character
+metadata:
+  name: nemo
+  annotations:
+    lostromos.wpengine.io/wave: "1"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dory-config
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: characters.stable.nicolerenee.io
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: ocean
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dory-env
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: hooks
+  annotations:
+    lostromos.wpengine.io/wave: "-1"
+`
+
+func names(objs []manifest.Object) []string {
+	var n []string
+	for _, obj := range objs {
+		n = append(n, obj.GetName())
+	}
+	return n
+}
+
+func TestSort(t *testing.T) {
+	objs, err := manifest.Parse([]byte(unordered))
+	assert.Nil(t, err)
+	assert.False(t, manifest.IsSorted(objs))
+
+	manifest.Sort(objs)
+	assert.Equal(t, []string{"hooks", "ocean", "characters.stable.nicolerenee.io", "dory-config", "dory-env", "dory", "nemo"}, names(objs))
+	assert.True(t, manifest.IsSorted(objs))
+
+	manifest.SortForDelete(objs)
+	assert.Equal(t, []string{"nemo", "dory", "dory-config", "dory-env", "characters.stable.nicolerenee.io", "ocean", "hooks"}, names(objs))
+}
+
+func TestWaves(t *testing.T) {
+	objs, err := manifest.Parse([]byte(unordered))
+	assert.Nil(t, err)
+	manifest.Sort(objs)
+
+	waves := manifest.Waves(objs)
+	if assert.Len(t, waves, 3) {
+		assert.Equal(t, []string{"hooks"}, names(waves[0]))
+		assert.Equal(t, -1, manifest.Wave(waves[0][0].Unstructured))
+		assert.Len(t, waves[1], 5)
+		assert.Equal(t, []string{"nemo"}, names(waves[2]))
+		assert.Equal(t, 1, manifest.Wave(waves[2][0].Unstructured))
+	}
+	assert.Empty(t, manifest.Waves(nil))
+}
+
+func TestValidateWave(t *testing.T) {
+	objs, err := manifest.Parse([]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: dory\n  annotations:\n    lostromos.wpengine.io/wave: first\n"))
+	assert.Nil(t, err)
+
+	err = manifest.Validate(objs, nil)
+	assert.EqualError(t, err, `document 1: the lostromos.wpengine.io/wave annotation must be a whole number, not "first"`)
+	assert.Equal(t, 0, manifest.Wave(objs[0].Unstructured))
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The labels and annotations added to rendered objects
+const (
+	ManagedByLabel     = "app.kubernetes.io/managed-by"
+	ManagedBy          = "lostromos"
+	NameLabel          = "lostromos.wpengine.io/cr-name"
+	NamespaceLabel     = "lostromos.wpengine.io/cr-namespace"
+	UIDLabel           = "lostromos.wpengine.io/cr-uid"
+	SourceAnnotation   = "lostromos.wpengine.io/source"
+	TemplateAnnotation = "lostromos.wpengine.io/template"
+)
+
+// maxLabelLength is the longest value a label can have
+const maxLabelLength = 63
+
+// Ownership controls the metadata added to rendered objects to tie them back
+// to the custom resource they were rendered for.
+type Ownership struct {
+	Labels          bool // add the managed-by label and labels with the name, namespace and uid of the custom resource
+	Annotations     bool // add annotations with the custom resource and template the object came from
+	OwnerReferences bool // add an ownerReference to the custom resource when the object is in the same namespace
+}
+
+// Enabled will return true if any metadata is added
+func (o Ownership) Enabled() bool {
+	return o.Labels || o.Annotations || o.OwnerReferences
+}
+
+// Apply will add the metadata to each of the objects. Labels with values that
+// are too long to be valid are left out. An ownerReference is only added when
+// the custom resource has a uid, and either isn't namespaced or the object is
+// explicitly in the same namespace, since kubernetes doesn't allow references
+// across namespaces.
+func (o Ownership) Apply(objs []Object, cr *unstructured.Unstructured) {
+	for _, obj := range objs {
+		if o.Labels {
+			labels := obj.GetLabels()
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[ManagedByLabel] = ManagedBy
+			setLabel(labels, NameLabel, cr.GetName())
+			setLabel(labels, NamespaceLabel, cr.GetNamespace())
+			setLabel(labels, UIDLabel, string(cr.GetUID()))
+			obj.SetLabels(labels)
+		}
+		if o.Annotations {
+			annotations := obj.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[SourceAnnotation] = Source(cr)
+			if obj.Source != "" {
+				annotations[TemplateAnnotation] = obj.Source
+			}
+			obj.SetAnnotations(annotations)
+		}
+		if o.OwnerReferences && canOwn(cr, obj) {
+			setOwnerReference(obj, cr)
+		}
+	}
+}
+
+// Source will describe the custom resource as <kind>/<namespace>/<name>, or
+// <kind>/<name> when it isn't namespaced.
+func Source(cr *unstructured.Unstructured) string {
+	if cr.GetNamespace() == "" {
+		return cr.GetKind() + "/" + cr.GetName()
+	}
+	return cr.GetKind() + "/" + cr.GetNamespace() + "/" + cr.GetName()
+}
+
+func setLabel(labels map[string]string, key, value string) {
+	if value != "" && len(value) <= maxLabelLength {
+		labels[key] = value
+	}
+}
+
+func canOwn(cr *unstructured.Unstructured, obj Object) bool {
+	if cr.GetUID() == "" {
+		return false
+	}
+	return cr.GetNamespace() == "" || cr.GetNamespace() == obj.GetNamespace()
+}
+
+func setOwnerReference(obj Object, cr *unstructured.Unstructured) {
+	refs := obj.GetOwnerReferences()
+	for _, ref := range refs {
+		if ref.UID == cr.GetUID() {
+			return
+		}
+	}
+	isController := true
+	for _, ref := range refs {
+		if ref.Controller != nil && *ref.Controller {
+			// there can only be one controller
+			isController = false
+		}
+	}
+	refs = append(refs, metav1.OwnerReference{
+		APIVersion: cr.GetAPIVersion(),
+		Kind:       cr.GetKind(),
+		Name:       cr.GetName(),
+		UID:        cr.GetUID(),
+		Controller: &isController,
+	})
+	obj.SetOwnerReferences(refs)
+}
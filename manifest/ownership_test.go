@@ -0,0 +1,125 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var testCR = &unstructured.Unstructured{Object: map[string]interface{}{
+	"apiVersion": "stable.nicolerenee.io/v1",
+	"kind":       "Character",
+	"metadata": map[string]interface{}{
+		"name":      "nemo",
+		"namespace": "ocean",
+		"uid":       "1234",
+	},
+}}
+
+func parse(t *testing.T, s string) []manifest.Object {
+	objs, err := manifest.Parse([]byte(s))
+	assert.Nil(t, err)
+	return objs
+}
+
+func TestOwnershipApply(t *testing.T) {
+	objs := parse(t, `---
+# Source: configmap.yaml.tmpl
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nemo
+  namespace: ocean
+  labels:
+    app: nemo
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: elsewhere
+  namespace: reef
+`)
+
+	manifest.Ownership{Labels: true, Annotations: true, OwnerReferences: true}.Apply(objs, testCR)
+
+	assert.Equal(t, map[string]string{
+		"app":                                "nemo",
+		"app.kubernetes.io/managed-by":       "lostromos",
+		"lostromos.wpengine.io/cr-name":      "nemo",
+		"lostromos.wpengine.io/cr-namespace": "ocean",
+		"lostromos.wpengine.io/cr-uid":       "1234",
+	}, objs[0].GetLabels())
+	assert.Equal(t, map[string]string{
+		"lostromos.wpengine.io/source":   "Character/ocean/nemo",
+		"lostromos.wpengine.io/template": "configmap.yaml.tmpl",
+	}, objs[0].GetAnnotations())
+	isController := true
+	assert.Equal(t, []metav1.OwnerReference{{
+		APIVersion: "stable.nicolerenee.io/v1",
+		Kind:       "Character",
+		Name:       "nemo",
+		UID:        "1234",
+		Controller: &isController,
+	}}, objs[0].GetOwnerReferences())
+
+	// references can't cross namespaces
+	assert.Empty(t, objs[1].GetOwnerReferences())
+	assert.Equal(t, map[string]string{"lostromos.wpengine.io/source": "Character/ocean/nemo"}, objs[1].GetAnnotations())
+
+	// applying again doesn't add another reference
+	manifest.Ownership{OwnerReferences: true}.Apply(objs, testCR)
+	assert.Len(t, objs[0].GetOwnerReferences(), 1)
+}
+
+func TestOwnershipApplyOptions(t *testing.T) {
+	objs := parse(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: nemo\n")
+	clusterCR := testCR.DeepCopy()
+	clusterCR.SetNamespace("")
+	clusterCR.SetName(strings.Repeat("n", 64))
+
+	manifest.Ownership{Labels: true}.Apply(objs, clusterCR)
+	assert.Equal(t, map[string]string{
+		"app.kubernetes.io/managed-by": "lostromos",
+		"lostromos.wpengine.io/cr-uid": "1234",
+	}, objs[0].GetLabels(), "names that are too long for a label are left out")
+	assert.Empty(t, objs[0].GetAnnotations())
+	assert.Empty(t, objs[0].GetOwnerReferences())
+
+	// cluster scoped resources can own anything
+	manifest.Ownership{OwnerReferences: true}.Apply(objs, clusterCR)
+	assert.Len(t, objs[0].GetOwnerReferences(), 1)
+
+	assert.False(t, manifest.Ownership{}.Enabled())
+	assert.True(t, manifest.Ownership{Annotations: true}.Enabled())
+}
+
+func TestEncode(t *testing.T) {
+	objs := parse(t, "---\n# Source: configmap.yaml.tmpl\nkind: ConfigMap\napiVersion: v1\nmetadata:\n  name: nemo\n---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: nemo\n")
+
+	out, err := manifest.Encode(objs)
+	assert.Nil(t, err)
+	assert.Equal(t, "---\n# Source: configmap.yaml.tmpl\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: nemo\n"+
+		"---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: nemo\n", string(out))
+
+	again, err := manifest.Parse(out)
+	assert.Nil(t, err)
+	assert.Equal(t, objs, again)
+}
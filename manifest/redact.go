@@ -0,0 +1,177 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Redacted is shown instead of a sensitive value
+const Redacted = "<redacted>"
+
+// Redactor will mask the data of Secrets, and any other fields that are
+// sensitive, in what is logged or shown for dry runs and drift
+type Redactor struct {
+	Fields []string // dotted paths of fields that are sensitive in every kind, like spec.password
+}
+
+// Sensitive will return true if the field at path, or one it is in, is
+// sensitive in an object of kind
+func (rd Redactor) Sensitive(kind, path string) bool {
+	if kind == "Secret" && (within(path, "data") || within(path, "stringData")) {
+		return true
+	}
+	for _, field := range rd.Fields {
+		if within(path, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes will compare the objects like Changes, with the values of sensitive
+// fields shown as Redacted
+func (rd Redactor) Changes(rendered, live map[string]interface{}) []string {
+	kind, _ := rendered["kind"].(string)
+	return changes(rendered, live, func(path string) bool {
+		return rd.Sensitive(kind, path)
+	})
+}
+
+// Values will return the values of the sensitive fields of the objects, so
+// that they can be masked wherever they show up. The data of Secrets is
+// included both encoded and decoded, and each line of values that have more
+// than one.
+func (rd Redactor) Values(objs []Object) []string {
+	var values []string
+	add := func(v string) {
+		values = append(values, v)
+		if strings.Contains(v, "\n") {
+			values = append(values, strings.Split(v, "\n")...)
+		}
+	}
+	for _, obj := range objs {
+		kind := obj.GetKind()
+		walk("", obj.Object, func(path string, v interface{}) {
+			if !rd.Sensitive(kind, path) {
+				return
+			}
+			s := fmt.Sprint(v)
+			add(s)
+			if kind != "Secret" {
+				return
+			}
+			if within(path, "data") {
+				if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+					add(string(b))
+				}
+			} else {
+				add(base64.StdEncoding.EncodeToString([]byte(s)))
+			}
+		})
+	}
+	return values
+}
+
+// Redact will return copies of the objects with the value of every sensitive
+// field replaced by Redacted
+func (rd Redactor) Redact(objs []Object) []Object {
+	redacted := make([]Object, len(objs))
+	for i, obj := range objs {
+		kind := obj.GetKind()
+		v := redact("", obj.Object, func(path string) bool { return rd.Sensitive(kind, path) })
+		redacted[i] = Object{Unstructured: &unstructured.Unstructured{Object: v.(map[string]interface{})}, Index: obj.Index, Source: obj.Source}
+	}
+	return redacted
+}
+
+// Mask will replace every value in text with Redacted, however short it is.
+// Blank values are left alone.
+func Mask(text string, values []string) string {
+	sorted := make([]string, 0, len(values))
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			sorted = append(sorted, v)
+		}
+	}
+	// longest first, so that a value inside another one doesn't leave the
+	// rest of the longer value behind
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, v := range sorted {
+		text = strings.Replace(text, v, Redacted, -1)
+	}
+	return text
+}
+
+// walk will call fn with the path and value of every field in v that isn't a
+// map or list
+func walk(path string, v interface{}, fn func(path string, v interface{})) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if path != "" {
+				key = path + "." + key
+			}
+			walk(key, value, fn)
+		}
+	case []interface{}:
+		for i, value := range t {
+			walk(path+"["+strconv.Itoa(i)+"]", value, fn)
+		}
+	case nil:
+	default:
+		fn(path, v)
+	}
+}
+
+// redact will return a copy of v with the fields that sensitive returns true
+// for, and the fields in them, replaced by Redacted
+func redact(path string, v interface{}, sensitive func(path string) bool) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, value := range t {
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			m[key] = redact(p, value, sensitive)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, value := range t {
+			l[i] = redact(path+"["+strconv.Itoa(i)+"]", value, sensitive)
+		}
+		return l
+	case nil:
+		return nil
+	}
+	if sensitive(path) {
+		return Redacted
+	}
+	return v
+}
+
+// within will return true if path is field or one of the fields in it
+func within(path, field string) bool {
+	return path == field || strings.HasPrefix(path, field+".") || strings.HasPrefix(path, field+"[")
+}
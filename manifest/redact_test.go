@@ -0,0 +1,100 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+)
+
+const secretManifest = `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: nemo
+data:
+  password: aHVudGVyMjI=
+stringData:
+  token: swim-swim
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nemo
+data:
+  password: not-a-secret
+  apiKey: fins-and-gills
+`
+
+func TestSensitive(t *testing.T) {
+	rd := manifest.Redactor{Fields: []string{"data.apiKey", "spec.credentials"}}
+	assert.True(t, rd.Sensitive("Secret", "data"))
+	assert.True(t, rd.Sensitive("Secret", "data.password"))
+	assert.True(t, rd.Sensitive("Secret", "stringData.token"))
+	assert.False(t, rd.Sensitive("Secret", "metadata.name"))
+	assert.False(t, rd.Sensitive("ConfigMap", "data.password"))
+	assert.True(t, rd.Sensitive("ConfigMap", "data.apiKey"))
+	assert.True(t, rd.Sensitive("Nemo", "spec.credentials[0].key"))
+	assert.False(t, rd.Sensitive("Nemo", "spec.credentialsFile"))
+}
+
+func TestRedactorChanges(t *testing.T) {
+	rd := manifest.Redactor{Fields: []string{"spec.password"}}
+	rendered := decode(t, `{"kind": "Secret", "metadata": {"name": "nemo"}, "data": {"password": "aHVudGVyMjI=", "user": "bmVtbw=="}}`)
+	live := decode(t, `{"kind": "Secret", "metadata": {"name": "nemo"}, "data": {"password": "c3dpbQ=="}}`)
+	assert.Equal(t, []string{
+		"data.password: <redacted> -> <redacted>",
+		"data.user: <none> -> <redacted>",
+	}, rd.Changes(rendered, live))
+
+	rendered = decode(t, `{"kind": "Nemo", "spec": {"password": "hunter22", "size": "small"}}`)
+	live = decode(t, `{"kind": "Nemo", "spec": {"password": "hunter2", "size": "large"}}`)
+	assert.Equal(t, []string{
+		"spec.password: <redacted> -> <redacted>",
+		"spec.size: \"large\" -> \"small\"",
+	}, rd.Changes(rendered, live))
+}
+
+func TestRedactorValues(t *testing.T) {
+	objs, err := manifest.Parse([]byte(secretManifest))
+	assert.Nil(t, err)
+	rd := manifest.Redactor{Fields: []string{"data.apiKey"}}
+	values := rd.Values(objs)
+	sort.Strings(values)
+	assert.Equal(t, []string{"aHVudGVyMjI=", "c3dpbS1zd2lt", "fins-and-gills", "hunter22", "swim-swim"}, values)
+}
+
+func TestMask(t *testing.T) {
+	values := []string{"hunter22", "hunter22-and-more", "abc", "line one\nline two", "line one", "line two", "", " "}
+	assert.Equal(t, "password <redacted>, long <redacted>, short <redacted>", manifest.Mask("password hunter22, long hunter22-and-more, short abc", values))
+	assert.Equal(t, "key: <redacted> and <redacted>", manifest.Mask("key: line one and line two", values))
+	assert.Equal(t, "nothing to hide", manifest.Mask("nothing to hide", nil))
+}
+
+func TestRedactorRedact(t *testing.T) {
+	objs, err := manifest.Parse([]byte(secretManifest))
+	assert.Nil(t, err)
+	redacted := manifest.Redactor{Fields: []string{"data.apiKey"}}.Redact(objs)
+	assert.Equal(t, map[string]interface{}{"password": manifest.Redacted}, redacted[0].Object["data"])
+	assert.Equal(t, map[string]interface{}{"token": manifest.Redacted}, redacted[0].Object["stringData"])
+	assert.Equal(t, "nemo", redacted[0].GetName())
+	assert.Equal(t, map[string]interface{}{"password": "not-a-secret", "apiKey": manifest.Redacted}, redacted[1].Object["data"])
+	assert.Equal(t, objs[1].Index, redacted[1].Index)
+	// the objects aren't changed
+	assert.Equal(t, "aHVudGVyMjI=", objs[0].Object["data"].(map[string]interface{})["password"])
+}
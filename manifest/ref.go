@@ -0,0 +1,89 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Ref identifies an object that was applied to Kubernetes
+type Ref struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// RefFor will return the Ref for an object
+func RefFor(obj *unstructured.Unstructured) Ref {
+	return Ref{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+// Refs will return the Ref for each of the objects
+func Refs(objs []Object) []Ref {
+	refs := make([]Ref, len(objs))
+	for i, obj := range objs {
+		refs[i] = RefFor(obj.Unstructured)
+	}
+	return refs
+}
+
+// Object will return an object with only the apiVersion, kind and metadata of
+// the Ref, which is enough for kubectl to find it.
+func (r Ref) Object() Object {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	u.SetAPIVersion(r.APIVersion)
+	u.SetKind(r.Kind)
+	u.SetName(r.Name)
+	if r.Namespace != "" {
+		u.SetNamespace(r.Namespace)
+	}
+	return Object{Unstructured: u}
+}
+
+// Key will return the same key as Key does for the object
+func (r Ref) Key() string {
+	return Key(r.Object())
+}
+
+// Objects will return a stub object for each of the Refs
+func Objects(refs []Ref) []Object {
+	objs := make([]Object, len(refs))
+	for i, r := range refs {
+		objs[i] = r.Object()
+	}
+	return objs
+}
+
+// Missing will return the refs in old that aren't in current, compared by
+// Key so that a change of version doesn't count as a new object.
+func Missing(old, current []Ref) []Ref {
+	keys := make(map[string]bool, len(current))
+	for _, r := range current {
+		keys[r.Key()] = true
+	}
+	var missing []Ref
+	for _, r := range old {
+		if !keys[r.Key()] {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+)
+
+func TestRefs(t *testing.T) {
+	objs, err := manifest.Parse([]byte("apiVersion: apps/v1beta1\nkind: Deployment\nmetadata:\n  name: dory\n  namespace: ocean\n"))
+	assert.Nil(t, err)
+
+	refs := manifest.Refs(objs)
+	assert.Equal(t, []manifest.Ref{{APIVersion: "apps/v1beta1", Kind: "Deployment", Namespace: "ocean", Name: "dory"}}, refs)
+	assert.Equal(t, "apps/Deployment ocean/dory", refs[0].Key())
+}
+
+func TestMissing(t *testing.T) {
+	deployment := manifest.Ref{APIVersion: "apps/v1beta1", Kind: "Deployment", Namespace: "ocean", Name: "dory"}
+	service := manifest.Ref{APIVersion: "v1", Kind: "Service", Namespace: "ocean", Name: "dory"}
+	newVersion := manifest.Ref{APIVersion: "apps/v1beta2", Kind: "Deployment", Namespace: "ocean", Name: "dory"}
+
+	assert.Equal(t, []manifest.Ref{service}, manifest.Missing([]manifest.Ref{deployment, service}, []manifest.Ref{newVersion}))
+	assert.Nil(t, manifest.Missing([]manifest.Ref{deployment}, []manifest.Ref{deployment, service}))
+}
+
+func TestObjects(t *testing.T) {
+	b, err := manifest.Encode(manifest.Objects([]manifest.Ref{{APIVersion: "v1", Kind: "Service", Name: "dory"}}))
+	assert.Nil(t, err)
+	assert.Equal(t, "---\napiVersion: v1\nkind: Service\nmetadata:\n  name: dory\n", string(b))
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/wpengine/lostromos/validation"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Schemas loads the json schemas for kubernetes kinds from a directory. The
+// files are named like the standalone schemas generated from the Kubernetes
+// OpenAPI spec, <kind>-<group>-<version>.json, where group is the first part
+// of the API group and is left out for the core group. For example
+// deployment-apps-v1beta1.json and configmap-v1.json.
+type Schemas struct {
+	Dir   string
+	mutex sync.Mutex
+	cache map[string]*validation.Schema
+}
+
+// NewSchemas will return Schemas for the directory, an error is returned if
+// the directory does not exist.
+func NewSchemas(dir string) (*Schemas, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("manifest schemas %s is not a directory", dir)
+	}
+	return &Schemas{Dir: dir, cache: map[string]*validation.Schema{}}, nil
+}
+
+// For will return the schema for the kind, or nil if there is no schema for
+// it, such as for custom resources.
+func (s *Schemas) For(apiVersion, kind string) (*validation.Schema, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(kind)
+	if gv.Group != "" {
+		name += "-" + strings.Split(gv.Group, ".")[0]
+	}
+	name += "-" + gv.Version + ".json"
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cached, ok := s.cache[name]; ok {
+		return cached, nil
+	}
+	file := filepath.Join(s.Dir, name)
+	var found *validation.Schema
+	if _, err := os.Stat(file); err == nil {
+		found, err = validation.LoadFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.cache[name] = found
+	return found, nil
+}
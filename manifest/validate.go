@@ -0,0 +1,86 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validate will check that every object has an apiVersion, kind and
+// metadata.name, that any WaveAnnotation is a number, and that no object is
+// rendered twice. When schemas is not nil objects are also validated against
+// the schema for their kind. The error is Errors with one error for every
+// problem found.
+func Validate(objs []Object, schemas *Schemas) error {
+	var errs Errors
+	seen := map[string]Object{}
+	for _, obj := range objs {
+		var missing []string
+		if obj.GetAPIVersion() == "" {
+			missing = append(missing, "apiVersion")
+		}
+		if obj.GetKind() == "" {
+			missing = append(missing, "kind")
+		}
+		if obj.GetName() == "" {
+			missing = append(missing, "metadata.name")
+		}
+		if len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("%s: %s is required", obj.Location(), strings.Join(missing, ", ")))
+			continue
+		}
+
+		if _, err := wave(obj.Unstructured); err != nil {
+			errs = append(errs, fmt.Errorf("%s: the %s annotation must be a whole number, not %q", obj.Location(), WaveAnnotation, obj.GetAnnotations()[WaveAnnotation]))
+		}
+
+		key := Key(obj)
+		if first, ok := seen[key]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s is also in %s", obj.Location(), key, first.Location()))
+			continue
+		}
+		seen[key] = obj
+
+		if schemas == nil {
+			continue
+		}
+		s, err := schemas.For(obj.GetAPIVersion(), obj.GetKind())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", obj.Location(), err))
+		} else if s != nil {
+			if err := s.Validate(obj.Object); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s %s is not valid: %s", obj.Location(), obj.GetKind(), obj.GetName(), err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Key will return a string that identifies the object in the cluster, made
+// from its group, kind, namespace and name.
+func Key(obj Object) string {
+	group := obj.GroupVersionKind().Group
+	if group == "" {
+		group = "core"
+	}
+	if ns := obj.GetNamespace(); ns != "" {
+		return fmt.Sprintf("%s/%s %s/%s", group, obj.GetKind(), ns, obj.GetName())
+	}
+	return fmt.Sprintf("%s/%s %s", group, obj.GetKind(), obj.GetName())
+}
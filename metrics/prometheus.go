@@ -90,6 +90,139 @@ var (
 		Namespace: "releases",
 	})
 
+	// ValidationFailures is a metric for the number of custom resources skipped because they are invalid
+	ValidationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Help:      "The number of events skipped because the custom resource failed schema validation",
+		Name:      "validation_error_total",
+		Namespace: "releases",
+	})
+
+	// PendingChanges is a metric for the number of custom resources with changes that weren't applied in dry run mode
+	PendingChanges = prometheus.NewGauge(prometheus.GaugeOpts{
+		Help:      "The number of custom resources with changes that would be applied without dry run",
+		Name:      "pending_changes",
+		Namespace: "releases",
+	})
+
+	// PrunedObjects is a metric for the number of objects deleted because they were no longer rendered
+	PrunedObjects = prometheus.NewCounter(prometheus.CounterOpts{
+		Help:      "The number of objects pruned because they were no longer rendered",
+		Name:      "pruned_objects_total",
+		Namespace: "releases",
+	})
+
+	// PruneFailures is a metric for the number of times pruning objects failed
+	PruneFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Help:      "The number of failed attempts to prune objects",
+		Name:      "prune_error_total",
+		Namespace: "releases",
+	})
+
+	// Rollbacks is a metric for the number of times a failed apply was rolled back
+	Rollbacks = prometheus.NewCounter(prometheus.CounterOpts{
+		Help:      "The number of failed applies that were rolled back to the last successful revision",
+		Name:      "rollback_total",
+		Namespace: "releases",
+	})
+
+	// RollbackFailures is a metric for the number of times rolling back a failed apply failed
+	RollbackFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Help:      "The number of failed rollbacks",
+		Name:      "rollback_error_total",
+		Namespace: "releases",
+	})
+
+	// DriftDetected is a metric for the number of objects of each kind changed or deleted by something other than lostromos
+	DriftDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of times an applied object of each kind was changed or deleted by something else",
+		Name:      "drift_total",
+		Namespace: "releases",
+	}, []string{"kind"})
+
+	// ClusterEvents is a metric for the number of events handled for custom resources in each target cluster
+	ClusterEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of events (create/delete/updates) processed for each target cluster",
+		Name:      "cluster_events_total",
+		Namespace: "releases",
+	}, []string{"cluster"})
+
+	// ClusterFailures is a metric for the number of events that failed for custom resources in each target cluster
+	ClusterFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of failed events for each target cluster",
+		Name:      "cluster_error_total",
+		Namespace: "releases",
+	}, []string{"cluster"})
+
+	// ClusterUnreachable is 1 for each target cluster that couldn't be reached the last time it was checked
+	ClusterUnreachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Help:      "Whether each target cluster couldn't be reached when it was last checked, 1 when it couldn't",
+		Name:      "cluster_unreachable",
+		Namespace: "releases",
+	}, []string{"cluster"})
+
+	// TemplateReloads is a metric for the number of times the templates were reloaded successfully
+	TemplateReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Help:      "The number of successful template reloads",
+		Name:      "reload_total",
+		Namespace: "templates",
+	})
+
+	// TemplateReloadFailures is a metric for the number of times the templates failed to reload
+	TemplateReloadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Help:      "The number of failed template reloads",
+		Name:      "reload_error_total",
+		Namespace: "templates",
+	})
+
+	// LastSuccessfulTemplateReload is a timestamp in UTC seconds of the last successful template reload
+	LastSuccessfulTemplateReload = prometheus.NewGauge(prometheus.GaugeOpts{
+		Help:      "A Unix timestamp (UTC) in seconds of the last successful template reload",
+		Name:      "last_reload_timestamp_utc_seconds",
+		Namespace: "templates",
+	})
+
+	// TemplateSetRenders is a metric for the number of times each template set was rendered successfully
+	TemplateSetRenders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of successful renders of each template set",
+		Name:      "render_total",
+		Namespace: "templates",
+	}, []string{"set"})
+
+	// TemplateSetRenderFailures is a metric for the number of times each template set failed to render
+	TemplateSetRenderFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of failed renders of each template set, including unknown sets",
+		Name:      "render_error_total",
+		Namespace: "templates",
+	}, []string{"set"})
+
+	// KubectlTimeouts is a metric for the number of kubectl and helm 3 commands killed for taking longer than the timeout
+	KubectlTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of kubectl and helm 3 commands that were killed for running longer than the timeout",
+		Name:      "kubectl_timeout_total",
+		Namespace: "releases",
+	}, []string{"command"})
+
+	// KubectlSlow is a metric for the number of kubectl commands that took longer than the slow threshold
+	KubectlSlow = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of kubectl commands that ran longer than the slow threshold",
+		Name:      "kubectl_slow_total",
+		Namespace: "releases",
+	}, []string{"command"})
+
+	// Timeouts is a metric for the number of failed events that were caused by a kubectl command timing out
+	Timeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of failed events (create/delete/updates) that were caused by a kubectl command timing out",
+		Name:      "timeout_total",
+		Namespace: "releases",
+	}, []string{"event"})
+
+	// ObjectActions is a metric for the number of objects sent to the API, by what happened to them
+	ObjectActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Help:      "The number of objects applied or deleted through the API, by what happened to them",
+		Name:      "object_actions_total",
+		Namespace: "releases",
+	}, []string{"action"})
+
 	// TotalEvents is a metric for the number of events that have been handled by this operator
 	TotalEvents = prometheus.NewCounter(prometheus.CounterOpts{
 		Help:      "The number of events (create/delete/updates) processed by this operator",
@@ -110,4 +243,23 @@ func init() {
 	prometheus.MustRegister(UpdateFailures)
 	prometheus.MustRegister(LastSuccessfulUpdate)
 	prometheus.MustRegister(TotalEvents)
+	prometheus.MustRegister(ValidationFailures)
+	prometheus.MustRegister(PendingChanges)
+	prometheus.MustRegister(PrunedObjects)
+	prometheus.MustRegister(PruneFailures)
+	prometheus.MustRegister(Rollbacks)
+	prometheus.MustRegister(RollbackFailures)
+	prometheus.MustRegister(DriftDetected)
+	prometheus.MustRegister(ClusterEvents)
+	prometheus.MustRegister(ClusterFailures)
+	prometheus.MustRegister(ClusterUnreachable)
+	prometheus.MustRegister(KubectlTimeouts)
+	prometheus.MustRegister(KubectlSlow)
+	prometheus.MustRegister(Timeouts)
+	prometheus.MustRegister(ObjectActions)
+	prometheus.MustRegister(TemplateReloads)
+	prometheus.MustRegister(TemplateReloadFailures)
+	prometheus.MustRegister(LastSuccessfulTemplateReload)
+	prometheus.MustRegister(TemplateSetRenders)
+	prometheus.MustRegister(TemplateSetRenderFailures)
 }
@@ -0,0 +1,151 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package target
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/wpengine/lostromos/metrics"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+)
+
+// PingTimeout is how long a cluster has to answer a Ping
+var PingTimeout = 5 * time.Second
+
+// Ping will check that the API server of the cluster answers within
+// PingTimeout
+func Ping(cl Cluster) error {
+	cfg, err := cl.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Timeout = PingTimeout
+	cfg.ContentConfig = dynamic.ContentConfig()
+	cfg.ContentConfig.GroupVersion = &schema.GroupVersion{Version: "v1"}
+	cfg.APIPath = "/api"
+	rc, err := restclient.RESTClientFor(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = rc.Get().AbsPath("/version").Do().Raw()
+	return err
+}
+
+// Cache keeps a client for each Cluster, created by New the first time it is
+// needed and again when its kubeconfig changes. A cluster that can't be
+// reached fails straight away until RetryAfter has passed, so the custom
+// resources for it don't each wait for it to time out and hold up the
+// others. Replaced clients are closed if they are an io.Closer.
+type Cache struct {
+	New        func(Cluster) (interface{}, error) // creates the client for a cluster
+	Ping       func(Cluster) error                // optional, checks a cluster can be reached, defaults to Ping
+	RetryAfter time.Duration                      // how long to fail for an unreachable cluster before trying it again
+	mu         sync.Mutex
+	clients    map[string]*cached
+}
+
+// cached is a client, or the error creating it, which is replaced rather than
+// changed so it can be read without the lock
+type cached struct {
+	cluster Cluster
+	client  interface{}
+	err     error
+	failed  time.Time
+}
+
+// Get will return the client for the cluster
+func (c *Cache) Get(cl Cluster) (interface{}, error) {
+	c.mu.Lock()
+	e, ok := c.clients[cl.Name]
+	c.mu.Unlock()
+	if ok && bytes.Equal(e.cluster.KubeConfig, cl.KubeConfig) {
+		if e.err == nil {
+			return e.client, nil
+		}
+		if time.Since(e.failed) < c.RetryAfter {
+			return nil, e.err
+		}
+	}
+	// connected without the lock so other clusters aren't held up
+	client, err := c.connect(cl)
+	next := &cached{cluster: cl, client: client, err: err}
+	if err != nil {
+		next.failed = time.Now()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clients == nil {
+		c.clients = map[string]*cached{}
+	}
+	if old, ok := c.clients[cl.Name]; ok {
+		closeClient(old.client)
+	}
+	c.clients[cl.Name] = next
+	return client, err
+}
+
+// Check will ping a cluster after a client for it failed, so that when it
+// can't be reached Get fails straight away until RetryAfter has passed.
+func (c *Cache) Check(name string) {
+	c.mu.Lock()
+	e, ok := c.clients[name]
+	c.mu.Unlock()
+	if !ok || e.err != nil {
+		return
+	}
+	err := c.ping(e.cluster)
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clients[name] == e {
+		// the client is kept so that it is closed when it is replaced
+		c.clients[name] = &cached{cluster: e.cluster, client: e.client, err: err, failed: time.Now()}
+	}
+}
+
+func (c *Cache) connect(cl Cluster) (interface{}, error) {
+	if err := c.ping(cl); err != nil {
+		return nil, err
+	}
+	return c.New(cl)
+}
+
+// ping will check the cluster can be reached and set ClusterUnreachable
+func (c *Cache) ping(cl Cluster) error {
+	ping := c.Ping
+	if ping == nil {
+		ping = Ping
+	}
+	if err := ping(cl); err != nil {
+		metrics.ClusterUnreachable.WithLabelValues(cl.Name).Set(1)
+		return fmt.Errorf("cluster %s is unreachable: %s", cl.Name, err)
+	}
+	metrics.ClusterUnreachable.WithLabelValues(cl.Name).Set(0)
+	return nil
+}
+
+func closeClient(client interface{}) {
+	if closer, ok := client.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package target_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/target"
+)
+
+// closer is a client that records when it is closed
+type closer struct {
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+// unreachable returns the releases_cluster_unreachable gauge for the cluster
+func unreachable(cluster string) float64 {
+	mf, _ := prometheus.DefaultGatherer.Gather()
+	for _, s := range mf {
+		if s.GetName() != "releases_cluster_unreachable" {
+			continue
+		}
+		for _, m := range s.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "cluster" && l.GetValue() == cluster {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return -1
+}
+
+func newCache(pingErr *error) (*target.Cache, *int) {
+	created := 0
+	return &target.Cache{
+		New: func(cl target.Cluster) (interface{}, error) {
+			created++
+			return &closer{}, nil
+		},
+		Ping: func(cl target.Cluster) error {
+			return *pingErr
+		},
+		RetryAfter: time.Hour,
+	}, &created
+}
+
+func TestCacheGet(t *testing.T) {
+	var pingErr error
+	c, created := newCache(&pingErr)
+	reef := target.Cluster{Name: "ocean/reef", KubeConfig: kubeConfig("https://reef:6443")}
+
+	first, err := c.Get(reef)
+	assert.Nil(t, err)
+	again, err := c.Get(reef)
+	assert.Nil(t, err)
+	assert.True(t, first == again)
+	assert.Equal(t, 1, *created)
+
+	// a new kubeconfig replaces the client
+	reef.KubeConfig = kubeConfig("https://reef:7443")
+	replaced, err := c.Get(reef)
+	assert.Nil(t, err)
+	assert.False(t, first == replaced)
+	assert.True(t, first.(*closer).closed)
+	assert.Equal(t, 2, *created)
+}
+
+func TestCacheGetFailsFastForUnreachableCluster(t *testing.T) {
+	pingErr := errors.New("connection refused")
+	c, created := newCache(&pingErr)
+	deep := target.Cluster{Name: "ocean/deep", KubeConfig: kubeConfig("https://deep:6443")}
+
+	_, err := c.Get(deep)
+	assert.EqualError(t, err, "cluster ocean/deep is unreachable: connection refused")
+	assert.Equal(t, float64(1), unreachable("ocean/deep"))
+
+	// not tried again until RetryAfter has passed
+	pingErr = nil
+	_, err = c.Get(deep)
+	assert.EqualError(t, err, "cluster ocean/deep is unreachable: connection refused")
+	assert.Equal(t, 0, *created)
+
+	c.RetryAfter = 0
+	_, err = c.Get(deep)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), unreachable("ocean/deep"))
+}
+
+func TestCacheCheck(t *testing.T) {
+	var pingErr error
+	c, _ := newCache(&pingErr)
+	wreck := target.Cluster{Name: "ocean/wreck", KubeConfig: kubeConfig("https://wreck:6443")}
+	_, err := c.Get(wreck)
+	assert.Nil(t, err)
+
+	c.Check("ocean/wreck")
+	_, err = c.Get(wreck)
+	assert.Nil(t, err)
+
+	pingErr = errors.New("i/o timeout")
+	c.Check("ocean/wreck")
+	_, err = c.Get(wreck)
+	assert.EqualError(t, err, "cluster ocean/wreck is unreachable: i/o timeout")
+	assert.Equal(t, float64(1), unreachable("ocean/wreck"))
+
+	// unknown clusters are ignored
+	c.Check("ocean/trench")
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package target
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// allowedUserFields and allowedClusterFields are the fields that a kubeconfig
+// in a Secret can set for its users and clusters. Whoever can create the
+// Secret picks its kubeconfig, and client-go, kubectl and helm would read the
+// files it names or run the commands it gives as lostromos, so only inline
+// credentials are allowed.
+var (
+	allowedUserFields    = map[string]bool{"client-certificate-data": true, "client-key-data": true, "token": true}
+	allowedClusterFields = map[string]bool{"server": true, "certificate-authority-data": true, "insecure-skip-tls-verify": true}
+)
+
+// kubeConfig is the part of a kubeconfig that is checked
+type kubeConfig struct {
+	Clusters []struct {
+		Name    string                 `json:"name"`
+		Cluster map[string]interface{} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string                 `json:"name"`
+		User map[string]interface{} `json:"user"`
+	} `json:"users"`
+}
+
+// checkKubeConfig will return an error when a user or cluster in the
+// kubeconfig sets a field that isn't allowed, such as exec, auth-provider,
+// tokenFile or the path of a certificate or key
+func checkKubeConfig(b []byte) error {
+	var cfg kubeConfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return err
+	}
+	for _, c := range cfg.Clusters {
+		if err := checkFields("cluster", c.Name, c.Cluster, allowedClusterFields); err != nil {
+			return err
+		}
+	}
+	for _, u := range cfg.Users {
+		if err := checkFields("user", u.Name, u.User, allowedUserFields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkFields(kind, name string, fields map[string]interface{}, allowed map[string]bool) error {
+	var keys []string
+	for key := range fields {
+		if !allowed[key] {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	var names []string
+	for key := range allowed {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%s %s sets %s, only %s are allowed", kind, name, strings.Join(keys, ", "), strings.Join(names, ", "))
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package target picks the Kubernetes cluster that the objects for each
+// custom resource are deployed into, which doesn't have to be the cluster the
+// custom resources are watched in.
+package target
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/wpengine/lostromos/tmpl"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// DefaultName is the name of the cluster used by custom resources that don't
+// pick one
+const DefaultName = "default"
+
+// DefaultKey is the key in a Secret with the kubeconfig of a cluster
+const DefaultKey = "kubeconfig"
+
+// Cluster is a Kubernetes cluster that objects are deployed into
+type Cluster struct {
+	Name       string // DefaultName, or namespace/name of the Secret with the kubeconfig
+	KubeConfig []byte // contents of the kubeconfig, empty for the cluster lostromos is configured for
+}
+
+// LoadCluster will return the default Cluster with the kubeconfig in file
+func LoadCluster(file string) (Cluster, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Cluster{}, err
+	}
+	return Cluster{Name: DefaultName, KubeConfig: b}, nil
+}
+
+// Config will return the client config for the current context of the
+// kubeconfig
+func (c Cluster) Config() (*restclient.Config, error) {
+	cfg, err := clientcmd.Load(c.KubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig for cluster %s: %s", c.Name, err)
+	}
+	return clientcmd.NewDefaultClientConfig(*cfg, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// Resolver will find the Cluster for each custom resource. A custom resource
+// can name a Secret with the kubeconfig of its cluster in Field, otherwise it
+// goes to Default.
+type Resolver struct {
+	Default   Cluster                                          // cluster for custom resources that don't name a Secret
+	Field     string                                           // optional, dotted path of a custom resource field with the name of a kubeconfig Secret
+	Key       string                                           // key of the kubeconfig in the Secret
+	Namespace string                                           // namespace of the Secrets for cluster scoped custom resources
+	Client    func(namespace string) dynamic.ResourceInterface // client for the Secrets in a namespace
+}
+
+// NewResolver will return a Resolver that reads the Secrets named in field
+// from the namespace of each custom resource, or namespace for cluster scoped
+// ones.
+func NewResolver(kubeCfg *restclient.Config, field, namespace string) (*Resolver, error) {
+	cfg := *kubeCfg
+	cfg.ContentConfig.GroupVersion = &schema.GroupVersion{Version: "v1"}
+	cfg.APIPath = "/api"
+	dc, err := dynamic.NewClient(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	apiResource := &metav1.APIResource{Name: "secrets", Namespaced: true}
+	return &Resolver{
+		Default:   Cluster{Name: DefaultName},
+		Field:     field,
+		Key:       DefaultKey,
+		Namespace: namespace,
+		Client: func(ns string) dynamic.ResourceInterface {
+			return dc.Resource(apiResource, ns)
+		},
+	}, nil
+}
+
+// Name will return the name of the cluster for the custom resource without
+// reading its Secret
+func (r *Resolver) Name(cr *unstructured.Unstructured) string {
+	ns, name := r.secret(cr)
+	if name == "" {
+		return r.Default.Name
+	}
+	return ns + "/" + name
+}
+
+// Remote will return true when the objects of the custom resource are
+// deployed into a cluster that isn't the one it is in, without reading its
+// Secret
+func (r *Resolver) Remote(cr *unstructured.Unstructured) bool {
+	_, name := r.secret(cr)
+	return name != "" || len(r.Default.KubeConfig) > 0
+}
+
+// Cluster will return the cluster for the custom resource. The kubeconfig in
+// a Secret can only have inline credentials, see checkKubeConfig.
+func (r *Resolver) Cluster(cr *unstructured.Unstructured) (Cluster, error) {
+	ns, name := r.secret(cr)
+	if name == "" {
+		return r.Default, nil
+	}
+	secret, err := r.Client(ns).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return Cluster{}, fmt.Errorf("failed to get the kubeconfig for cluster %s/%s: %s", ns, name, err)
+	}
+	data, _ := secret.Object["data"].(map[string]interface{})
+	encoded, _ := data[r.Key].(string)
+	if encoded == "" {
+		return Cluster{}, fmt.Errorf("secret %s/%s has no %s", ns, name, r.Key)
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("secret %s/%s has an invalid %s: %s", ns, name, r.Key, err)
+	}
+	if err := checkKubeConfig(b); err != nil {
+		return Cluster{}, fmt.Errorf("secret %s/%s has an invalid %s: %s", ns, name, r.Key, err)
+	}
+	return Cluster{Name: ns + "/" + name, KubeConfig: b}, nil
+}
+
+// secret will return the namespace and name of the kubeconfig Secret for the
+// custom resource, the name is empty when it doesn't name one
+func (r *Resolver) secret(cr *unstructured.Unstructured) (string, string) {
+	if r.Field == "" {
+		return "", ""
+	}
+	name, _ := tmpl.CustomResource{Resource: cr}.GetField(strings.Split(r.Field, ".")...)
+	ns := cr.GetNamespace()
+	if ns == "" {
+		ns = r.Namespace
+	}
+	return ns, name
+}
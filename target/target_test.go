@@ -0,0 +1,206 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package target_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/target"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+)
+
+// fakeSecrets implements the parts of dynamic.ResourceInterface used by the
+// Resolver
+type fakeSecrets struct {
+	dynamic.ResourceInterface
+	namespace string
+	data      map[string]map[string]interface{}
+}
+
+func (f *fakeSecrets) Get(name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+	data, ok := f.data[f.namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{"data": data}}, nil
+}
+
+// kubeConfig returns a kubeconfig for the API server at server
+func kubeConfig(server string) []byte {
+	return []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: reef
+  cluster:
+    server: ` + server + `
+users:
+- name: marlin
+  user:
+    token: abc
+contexts:
+- name: reef
+  context:
+    cluster: reef
+    user: marlin
+current-context: reef
+`)
+}
+
+func newResolver(secrets map[string]map[string]interface{}) *target.Resolver {
+	return &target.Resolver{
+		Default:   target.Cluster{Name: target.DefaultName},
+		Field:     "spec.cluster",
+		Key:       target.DefaultKey,
+		Namespace: "lostromos",
+		Client: func(namespace string) dynamic.ResourceInterface {
+			return &fakeSecrets{namespace: namespace, data: secrets}
+		},
+	}
+}
+
+func customResource(namespace, cluster string) *unstructured.Unstructured {
+	cr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+	cr.SetNamespace(namespace)
+	cr.SetName("dory")
+	if cluster != "" {
+		cr.Object["spec"].(map[string]interface{})["cluster"] = cluster
+	}
+	return cr
+}
+
+func TestNewResolver(t *testing.T) {
+	r, err := target.NewResolver(&restclient.Config{}, "spec.cluster", "lostromos")
+	assert.Nil(t, err)
+	assert.Equal(t, target.Cluster{Name: target.DefaultName}, r.Default)
+	assert.Equal(t, target.DefaultKey, r.Key)
+	assert.NotNil(t, r.Client("ocean"))
+}
+
+func TestLoadCluster(t *testing.T) {
+	f, _ := ioutil.TempFile("", "kubeconfig")
+	defer os.Remove(f.Name())
+	f.Write(kubeConfig("https://reef:6443"))
+	f.Close()
+
+	cl, err := target.LoadCluster(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, target.DefaultName, cl.Name)
+	cfg, err := cl.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://reef:6443", cfg.Host)
+	assert.Equal(t, "abc", cfg.BearerToken)
+
+	_, err = target.LoadCluster("/not/there")
+	assert.NotNil(t, err)
+}
+
+func TestClusterConfigFailsForInvalidKubeConfig(t *testing.T) {
+	_, err := target.Cluster{Name: "ocean/reef", KubeConfig: []byte("clusters: 3")}.Config()
+	assert.Contains(t, err.Error(), "invalid kubeconfig for cluster ocean/reef")
+}
+
+func TestResolverCluster(t *testing.T) {
+	secrets := map[string]map[string]interface{}{
+		"ocean/reef":     {"kubeconfig": base64.StdEncoding.EncodeToString(kubeConfig("https://reef:6443"))},
+		"lostromos/deep": {"kubeconfig": base64.StdEncoding.EncodeToString(kubeConfig("https://deep:6443"))},
+		"ocean/empty":    {},
+	}
+	r := newResolver(secrets)
+
+	cl, err := r.Cluster(customResource("ocean", ""))
+	assert.Nil(t, err)
+	assert.Equal(t, r.Default, cl)
+	assert.Equal(t, target.DefaultName, r.Name(customResource("ocean", "")))
+
+	cl, err = r.Cluster(customResource("ocean", "reef"))
+	assert.Nil(t, err)
+	assert.Equal(t, "ocean/reef", cl.Name)
+	assert.Equal(t, kubeConfig("https://reef:6443"), cl.KubeConfig)
+	assert.Equal(t, "ocean/reef", r.Name(customResource("ocean", "reef")))
+
+	// cluster scoped custom resources use the Secrets in the resolver namespace
+	cl, err = r.Cluster(customResource("", "deep"))
+	assert.Nil(t, err)
+	assert.Equal(t, "lostromos/deep", cl.Name)
+
+	assert.False(t, r.Remote(customResource("ocean", "")))
+	assert.True(t, r.Remote(customResource("ocean", "reef")))
+	r.Default.KubeConfig = kubeConfig("https://lagoon:6443")
+	assert.True(t, r.Remote(customResource("ocean", "")))
+
+	_, err = r.Cluster(customResource("ocean", "empty"))
+	assert.EqualError(t, err, "secret ocean/empty has no kubeconfig")
+
+	_, err = r.Cluster(customResource("ocean", "missing"))
+	assert.EqualError(t, err, `failed to get the kubeconfig for cluster ocean/missing: secrets "missing" not found`)
+}
+
+func TestResolverClusterRejectsUnsafeKubeConfig(t *testing.T) {
+	safe := strings.Replace(string(kubeConfig("https://reef:6443")), "token: abc", `client-certificate-data: Y2VydA==
+    client-key-data: a2V5`, 1)
+	safe = strings.Replace(safe, "server: https://reef:6443", `server: https://reef:6443
+    certificate-authority-data: Y2E=`, 1)
+	tests := []struct {
+		name   string
+		old    string
+		new    string
+		errMsg string
+	}{
+		{"exec", "client-key-data: a2V5", "exec:\n      command: /bin/sh", "user marlin sets exec"},
+		{"auth-provider", "client-key-data: a2V5", "auth-provider:\n      name: gcp", "user marlin sets auth-provider"},
+		{"token file", "client-key-data: a2V5", "tokenFile: /var/run/secrets/kubernetes.io/serviceaccount/token", "user marlin sets tokenFile"},
+		{"client certificate", "client-certificate-data: Y2VydA==", "client-certificate: /etc/lostromos/tls.crt", "user marlin sets client-certificate"},
+		{"client key", "client-key-data: a2V5", "client-key: /etc/lostromos/tls.key", "user marlin sets client-key"},
+		{"certificate authority", "certificate-authority-data: Y2E=", "certificate-authority: /etc/lostromos/ca.crt", "cluster reef sets certificate-authority"},
+	}
+	for _, test := range tests {
+		kc := strings.Replace(safe, test.old, test.new, 1)
+		assert.NotEqual(t, safe, kc, test.name)
+		r := newResolver(map[string]map[string]interface{}{
+			"ocean/reef": {"kubeconfig": base64.StdEncoding.EncodeToString([]byte(kc))},
+		})
+		_, err := r.Cluster(customResource("ocean", "reef"))
+		if assert.NotNil(t, err, test.name) {
+			assert.Contains(t, err.Error(), "secret ocean/reef has an invalid kubeconfig: "+test.errMsg, test.name)
+		}
+	}
+
+	r := newResolver(map[string]map[string]interface{}{
+		"ocean/reef": {"kubeconfig": base64.StdEncoding.EncodeToString([]byte(safe))},
+	})
+	cl, err := r.Cluster(customResource("ocean", "reef"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(safe), cl.KubeConfig)
+}
+
+func TestDefaultClusterIsNotChecked(t *testing.T) {
+	// the default cluster is configured by whoever runs lostromos
+	kc := strings.Replace(string(kubeConfig("https://reef:6443")), "token: abc", "tokenFile: /var/run/token", 1)
+	r := newResolver(nil)
+	r.Default.KubeConfig = []byte(kc)
+	cl, err := r.Cluster(customResource("ocean", ""))
+	assert.Nil(t, err)
+	assert.Equal(t, r.Default, cl)
+}
@@ -14,12 +14,20 @@
 
 package tmpl
 
-import "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
 
 // CustomResource provides some helper methods for interacting with the
 // kubernetes custom resource inside the templates.
 type CustomResource struct {
 	Resource *unstructured.Unstructured // represents the resource from kubernetes
+	Strict   bool                       // return errors for missing fields instead of empty values
+	Values   map[string]interface{}     // the spec merged over the default values, the spec alone when nil
 }
 
 // Name will return the Name from the custom resource
@@ -27,23 +35,177 @@ func (cr CustomResource) Name() string {
 	return cr.Resource.GetName()
 }
 
+// Namespace will return the Namespace from the custom resource
+func (cr CustomResource) Namespace() string {
+	return cr.Resource.GetNamespace()
+}
+
+// UID will return the UID from the custom resource
+func (cr CustomResource) UID() string {
+	return string(cr.Resource.GetUID())
+}
+
+// Generation will return the Generation from the custom resource
+func (cr CustomResource) Generation() int64 {
+	return cr.Resource.GetGeneration()
+}
+
+// Labels will return the Labels from the custom resource
+func (cr CustomResource) Labels() map[string]string {
+	return cr.Resource.GetLabels()
+}
+
+// Label will return the value of a single label, or an empty string if it is
+// not set
+func (cr CustomResource) Label(key string) string {
+	return cr.Resource.GetLabels()[key]
+}
+
+// Annotations will return the Annotations from the custom resource
+func (cr CustomResource) Annotations() map[string]string {
+	return cr.Resource.GetAnnotations()
+}
+
+// Annotation will return the value of a single annotation, or an empty string
+// if it is not set
+func (cr CustomResource) Annotation(key string) string {
+	return cr.Resource.GetAnnotations()[key]
+}
+
+// OwnerReferences will return the OwnerReferences from the custom resource
+func (cr CustomResource) OwnerReferences() []metav1.OwnerReference {
+	return cr.Resource.GetOwnerReferences()
+}
+
+// Field will traverse all the fields and return the raw value of the
+// requested field, which is useful with range. If the field is not found it
+// will return nil, or an error when Strict is set
+func (cr CustomResource) Field(fields ...string) (interface{}, error) {
+	val, found := NestedField(cr.Resource.Object, fields...)
+	if !found && cr.Strict {
+		return nil, fmt.Errorf("field %s not found", fieldPath(fields))
+	}
+	return val, nil
+}
+
+// HasField will return true if the requested field exists, even if the value
+// of the field is null
+func (cr CustomResource) HasField(fields ...string) bool {
+	_, found := NestedField(cr.Resource.Object, fields...)
+	return found
+}
+
 // GetField will traverse all the fields to return the string value of the
-// requested field. If the field is not found it will return an empty string
-func (cr CustomResource) GetField(fields ...string) string {
-	if str, ok := getNestedField(cr.Resource.Object, fields...).(string); ok {
-		return str
+// requested field. If the field is not found it will return an empty string.
+// When Strict is set a missing field or a field of another type is an error
+// for this and the other Get methods.
+func (cr CustomResource) GetField(fields ...string) (string, error) {
+	val, err := cr.Field(fields...)
+	if str, ok := val.(string); ok || err != nil {
+		return str, err
+	}
+	return "", cr.typeError(fields, val, "a string")
+}
+
+// GetInt will return the integer value of the requested field. Whole floats
+// are converted, anything else will return 0
+func (cr CustomResource) GetInt(fields ...string) (int64, error) {
+	val, err := cr.Field(fields...)
+	if err != nil {
+		return 0, err
+	}
+	switch v := val.(type) {
+	case int64:
+		return v, nil
+	case int32:
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+	}
+	return 0, cr.typeError(fields, val, "an integer")
+}
+
+// GetFloat will return the float value of the requested field. Integers are
+// converted, anything else will return 0
+func (cr CustomResource) GetFloat(fields ...string) (float64, error) {
+	val, err := cr.Field(fields...)
+	if err != nil {
+		return 0, err
+	}
+	switch v := val.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	}
+	return 0, cr.typeError(fields, val, "a number")
+}
+
+// GetBool will return the boolean value of the requested field. If the field
+// is not found or is not a boolean it will return false
+func (cr CustomResource) GetBool(fields ...string) (bool, error) {
+	val, err := cr.Field(fields...)
+	if b, ok := val.(bool); ok || err != nil {
+		return b, err
 	}
-	return ""
+	return false, cr.typeError(fields, val, "a boolean")
 }
 
-// copied from https://github.com/kubernetes/apimachinery/blob/master/pkg/apis/meta/v1/unstructured/unstructured.go
-func getNestedField(obj map[string]interface{}, fields ...string) interface{} {
+// GetSlice will return the list value of the requested field. If the field is
+// not found or is not a list it will return nil
+func (cr CustomResource) GetSlice(fields ...string) ([]interface{}, error) {
+	val, err := cr.Field(fields...)
+	if s, ok := val.([]interface{}); ok || err != nil {
+		return s, err
+	}
+	return nil, cr.typeError(fields, val, "a list")
+}
+
+// GetMap will return the object value of the requested field. If the field is
+// not found or is not an object it will return nil
+func (cr CustomResource) GetMap(fields ...string) (map[string]interface{}, error) {
+	val, err := cr.Field(fields...)
+	if m, ok := val.(map[string]interface{}); ok || err != nil {
+		return m, err
+	}
+	return nil, cr.typeError(fields, val, "an object")
+}
+
+// typeError returns an error for a field with an unexpected type when Strict
+// is set, otherwise the zero value is used and there is no error.
+func (cr CustomResource) typeError(fields []string, val interface{}, expected string) error {
+	if !cr.Strict {
+		return nil
+	}
+	return fmt.Errorf("field %s is not %s", fieldPath(fields), expected)
+}
+
+func fieldPath(fields []string) string {
+	return strings.Join(fields, ".")
+}
+
+// NestedField will return the value at the path of fields in obj, and false
+// when it isn't set. Based on
+// https://github.com/kubernetes/apimachinery/blob/master/pkg/apis/meta/v1/unstructured/unstructured.go
+func NestedField(obj map[string]interface{}, fields ...string) (interface{}, bool) {
 	var val interface{} = obj
 	for _, field := range fields {
-		if _, ok := val.(map[string]interface{}); !ok {
-			return nil
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		val, ok = m[field]
+		if !ok {
+			return nil, false
 		}
-		val = val.(map[string]interface{})[field]
 	}
-	return val
+	return val, true
 }
@@ -18,19 +18,164 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/tmpl"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+var typedCR = &tmpl.CustomResource{
+	Resource: &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":        "nemo",
+				"namespace":   "reef",
+				"uid":         "1234",
+				"generation":  int64(3),
+				"labels":      map[string]interface{}{"app": "nemo"},
+				"annotations": map[string]interface{}{"by": "Pixar"},
+				"ownerReferences": []interface{}{
+					map[string]interface{}{"kind": "Ocean", "name": "pacific"},
+				},
+			},
+			"spec": map[string]interface{}{
+				"replicas": int64(2),
+				"ratio":    float64(0.5),
+				"whole":    float64(4),
+				"enabled":  true,
+				"ports":    []interface{}{int64(80), int64(443)},
+				"limits":   map[string]interface{}{"cpu": "1"},
+				"nothing":  nil,
+			},
+		},
+	},
+}
+
 func TestName(t *testing.T) {
 	r := testCR.Name()
 	assert.Equal(t, "dory", r)
 }
 
 func TestGetField(t *testing.T) {
-	r := testCR.GetField("metadata", "name")
+	r, err := testCR.GetField("metadata", "name")
+	assert.Nil(t, err)
 	assert.Equal(t, "dory", r)
 }
 
 func TestGetFieldReturnsEmptyIfNotFound(t *testing.T) {
-	r := testCR.GetField("Something", "made", "up")
+	r, err := testCR.GetField("Something", "made", "up")
+	assert.Nil(t, err)
 	assert.Empty(t, r)
 }
+
+func TestMetadataAccessors(t *testing.T) {
+	assert.Equal(t, "reef", typedCR.Namespace())
+	assert.Equal(t, "1234", typedCR.UID())
+	assert.Equal(t, int64(3), typedCR.Generation())
+	assert.Equal(t, map[string]string{"app": "nemo"}, typedCR.Labels())
+	assert.Equal(t, "nemo", typedCR.Label("app"))
+	assert.Equal(t, map[string]string{"by": "Pixar"}, typedCR.Annotations())
+	assert.Equal(t, "Pixar", typedCR.Annotation("by"))
+	assert.Empty(t, typedCR.Annotation("missing"))
+	refs := typedCR.OwnerReferences()
+	assert.Len(t, refs, 1)
+	assert.Equal(t, "pacific", refs[0].Name)
+}
+
+func TestTypedAccessors(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		get      func(...string) (interface{}, error)
+		field    string
+		expected interface{}
+	}{
+		{"GetInt", wrap(typedCR.GetInt), "replicas", int64(2)},
+		{"GetInt from whole float", wrap(typedCR.GetInt), "whole", int64(4)},
+		{"GetInt from fraction", wrap(typedCR.GetInt), "ratio", int64(0)},
+		{"GetFloat", wrap(typedCR.GetFloat), "ratio", 0.5},
+		{"GetFloat from int", wrap(typedCR.GetFloat), "replicas", float64(2)},
+		{"GetBool", wrap(typedCR.GetBool), "enabled", true},
+		{"GetBool from int", wrap(typedCR.GetBool), "replicas", false},
+		{"GetSlice", wrap(typedCR.GetSlice), "ports", []interface{}{int64(80), int64(443)}},
+		{"GetSlice from map", wrap(typedCR.GetSlice), "limits", []interface{}(nil)},
+		{"GetMap", wrap(typedCR.GetMap), "limits", map[string]interface{}{"cpu": "1"}},
+		{"GetMap from list", wrap(typedCR.GetMap), "ports", map[string]interface{}(nil)},
+		{"GetField missing", wrap(typedCR.GetField), "missing", ""},
+	}
+
+	for _, tt := range testCases {
+		r, err := tt.get("spec", tt.field)
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.expected, r, tt.name)
+	}
+}
+
+func TestStrictAccessors(t *testing.T) {
+	strictCR := &tmpl.CustomResource{Resource: typedCR.Resource, Strict: true}
+	var testCases = []struct {
+		name  string
+		get   func(...string) (interface{}, error)
+		field string
+		err   string
+	}{
+		{"GetField missing", wrap(strictCR.GetField), "missing", "field spec.missing not found"},
+		{"GetField wrong type", wrap(strictCR.GetField), "replicas", "field spec.replicas is not a string"},
+		{"GetField null", wrap(strictCR.GetField), "nothing", "field spec.nothing is not a string"},
+		{"GetInt from fraction", wrap(strictCR.GetInt), "ratio", "field spec.ratio is not an integer"},
+		{"GetFloat from string", wrap(strictCR.GetFloat), "limits", "field spec.limits is not a number"},
+		{"GetBool from int", wrap(strictCR.GetBool), "replicas", "field spec.replicas is not a boolean"},
+		{"GetSlice from map", wrap(strictCR.GetSlice), "limits", "field spec.limits is not a list"},
+		{"GetMap from list", wrap(strictCR.GetMap), "ports", "field spec.ports is not an object"},
+		{"Field missing", strictCR.Field, "missing", "field spec.missing not found"},
+	}
+
+	for _, tt := range testCases {
+		_, err := tt.get("spec", tt.field)
+		assert.NotNil(t, err, tt.name)
+		if err != nil {
+			assert.Equal(t, tt.err, err.Error(), tt.name)
+		}
+	}
+
+	r, err := strictCR.GetInt("spec", "replicas")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), r)
+}
+
+func TestField(t *testing.T) {
+	r, err := typedCR.Field("spec", "ports")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{int64(80), int64(443)}, r)
+	r, err = typedCR.Field("spec", "missing")
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+	r, err = typedCR.Field("spec", "ports", "too", "deep")
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+}
+
+func TestHasField(t *testing.T) {
+	assert.True(t, typedCR.HasField("spec", "replicas"))
+	assert.True(t, typedCR.HasField("spec", "nothing"))
+	assert.False(t, typedCR.HasField("spec", "missing"))
+	assert.False(t, typedCR.HasField("spec", "replicas", "deeper"))
+}
+
+// wrap converts the typed accessors so they can share a test table
+func wrap(f interface{}) func(...string) (interface{}, error) {
+	return func(fields ...string) (interface{}, error) {
+		switch get := f.(type) {
+		case func(...string) (string, error):
+			return get(fields...)
+		case func(...string) (int64, error):
+			return get(fields...)
+		case func(...string) (float64, error):
+			return get(fields...)
+		case func(...string) (bool, error):
+			return get(fields...)
+		case func(...string) ([]interface{}, error):
+			return get(fields...)
+		case func(...string) (map[string]interface{}, error):
+			return get(fields...)
+		}
+		panic("unsupported accessor")
+	}
+}
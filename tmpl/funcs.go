@@ -0,0 +1,399 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+)
+
+// FuncMap returns the functions available to lostromos templates. The names
+// follow the sprig library used by Helm so chart authors can reuse what they
+// already know. See docs/templates.md for the full list.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		// defaults and flow control
+		"default":  defaultValue,
+		"empty":    empty,
+		"coalesce": coalesce,
+		"ternary":  ternary,
+		"required": required,
+		"fail":     fail,
+
+		// strings
+		"quote":      quote,
+		"squote":     squote,
+		"indent":     indent,
+		"nindent":    nindent,
+		"trim":       strings.TrimSpace,
+		"trimAll":    func(cutset, s string) string { return strings.Trim(s, cutset) },
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      strings.Title,
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"trunc":      trunc,
+		"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       join,
+		"cat":        cat,
+		"toString":   toString,
+
+		// encoding and hashing
+		"b64enc":    func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":    b64dec,
+		"sha1sum":   func(s string) string { h := sha1.Sum([]byte(s)); return hex.EncodeToString(h[:]) },
+		"sha256sum": func(s string) string { h := sha256.Sum256([]byte(s)); return hex.EncodeToString(h[:]) },
+		"toYaml":    toYaml,
+		"fromYaml":  fromYaml,
+		"toJson":    toJSON,
+		"fromJson":  fromJSON,
+
+		// lists and dictionaries
+		"list":   func(v ...interface{}) []interface{} { return v },
+		"dict":   dict,
+		"hasKey": func(d map[string]interface{}, key string) bool { _, ok := d[key]; return ok },
+		"keys":   keys,
+
+		// numbers
+		"int":   toInt,
+		"int64": toInt64,
+		"add":   add,
+		"sub":   sub,
+		"mul":   mul,
+		"div":   div,
+		"mod":   mod,
+
+		// include is replaced by Parse with a version bound to the parsed
+		// templates, it only needs to exist here so templates using it parse.
+		"include": func(string, interface{}) (string, error) {
+			return "", errors.New("include is not available outside of a parsed template")
+		},
+	}
+}
+
+// maxIncludeDepth is how deeply includes can be nested, so that a template
+// that includes itself fails instead of overflowing the stack
+const maxIncludeDepth = 100
+
+// includeFunc returns an include function bound to t that renders the named
+// template and returns the result as a string so it can be piped. depth is
+// the number of includes being rendered, it must not be shared between
+// goroutines.
+func includeFunc(t *template.Template, depth *int) func(string, interface{}) (string, error) {
+	return func(name string, data interface{}) (string, error) {
+		if *depth >= maxIncludeDepth {
+			return "", fmt.Errorf("include %q: includes are nested more than %d deep, a template probably includes itself", name, maxIncludeDepth)
+		}
+		*depth++
+		defer func() { *depth-- }()
+		var b bytes.Buffer
+		err := t.ExecuteTemplate(&b, name, data)
+		return b.String(), err
+	}
+}
+
+func defaultValue(d interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return d
+	}
+	return given[0]
+}
+
+// empty reports whether the value is nil or the zero value for its type. Empty
+// slices and maps are also considered empty.
+func empty(given interface{}) bool {
+	v := reflect.ValueOf(given)
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Chan, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func coalesce(v ...interface{}) interface{} {
+	for _, val := range v {
+		if !empty(val) {
+			return val
+		}
+	}
+	return nil
+}
+
+func ternary(vt, vf interface{}, v bool) interface{} {
+	if v {
+		return vt
+	}
+	return vf
+}
+
+func required(msg string, val interface{}) (interface{}, error) {
+	if empty(val) {
+		return nil, errors.New(msg)
+	}
+	return val, nil
+}
+
+func fail(msg string) (string, error) {
+	return "", errors.New(msg)
+}
+
+func quote(str ...interface{}) string {
+	out := make([]string, 0, len(str))
+	for _, s := range str {
+		if s != nil {
+			out = append(out, strconv.Quote(toString(s)))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func squote(str ...interface{}) string {
+	out := make([]string, 0, len(str))
+	for _, s := range str {
+		if s != nil {
+			out = append(out, fmt.Sprintf("'%s'", toString(s)))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func indent(spaces int, v string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(v, "\n", "\n"+pad, -1)
+}
+
+func nindent(spaces int, v string) string {
+	return "\n" + indent(spaces, v)
+}
+
+func add(v ...interface{}) int64 {
+	var sum int64
+	for _, n := range v {
+		sum += toInt64(n)
+	}
+	return sum
+}
+
+func sub(a interface{}, v ...interface{}) int64 {
+	diff := toInt64(a)
+	for _, n := range v {
+		diff -= toInt64(n)
+	}
+	return diff
+}
+
+func mul(a interface{}, v ...interface{}) int64 {
+	product := toInt64(a)
+	for _, n := range v {
+		product *= toInt64(n)
+	}
+	return product
+}
+
+func trunc(c int, s string) string {
+	if c >= 0 && len(s) > c {
+		return s[:c]
+	}
+	if c < 0 && len(s) > -c {
+		return s[len(s)+c:]
+	}
+	return s
+}
+
+func join(sep string, v interface{}) string {
+	return strings.Join(toStrings(v), sep)
+}
+
+func cat(v ...interface{}) string {
+	out := make([]string, 0, len(v))
+	for _, s := range v {
+		if s != nil {
+			out = append(out, toString(s))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case error:
+		return s.Error()
+	case fmt.Stringer:
+		return s.String()
+	case nil:
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func toStrings(v interface{}) []string {
+	if v == nil {
+		return []string{}
+	}
+	switch s := v.(type) {
+	case []string:
+		return s
+	case string:
+		return []string{s}
+	}
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return []string{toString(v)}
+	}
+	out := make([]string, 0, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		out = append(out, toString(val.Index(i).Interface()))
+	}
+	return out
+}
+
+func b64dec(s string) (string, error) {
+	out, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// toYaml marshals the value into yaml without the trailing newline so it can
+// be used with nindent, ex: {{ toYaml .Resource.Object.spec | nindent 4 }}
+func toYaml(v interface{}) (string, error) {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func fromYaml(s string) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	err := yaml.Unmarshal([]byte(s), &m)
+	return m, err
+}
+
+func toJSON(v interface{}) (string, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func fromJSON(s string) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	err := json.Unmarshal([]byte(s), &m)
+	return m, err
+}
+
+func dict(v ...interface{}) (map[string]interface{}, error) {
+	if len(v)%2 != 0 {
+		return nil, errors.New("dict requires an even number of arguments")
+	}
+	d := make(map[string]interface{}, len(v)/2)
+	for i := 0; i < len(v); i += 2 {
+		d[toString(v[i])] = v[i+1]
+	}
+	return d, nil
+}
+
+func keys(d map[string]interface{}) []string {
+	k := make([]string, 0, len(d))
+	for key := range d {
+		k = append(k, key)
+	}
+	sort.Strings(k)
+	return k
+}
+
+func toInt(v interface{}) int {
+	return int(toInt64(v))
+}
+
+// toInt64 converts numbers and numeric strings to an int64, anything else
+// becomes 0.
+func toInt64(v interface{}) int64 {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(val.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int64(val.Float())
+	case reflect.String:
+		i, err := strconv.ParseInt(val.String(), 10, 64)
+		if err != nil {
+			f, _ := strconv.ParseFloat(val.String(), 64)
+			return int64(f)
+		}
+		return i
+	case reflect.Bool:
+		if val.Bool() {
+			return 1
+		}
+	}
+	return 0
+}
+
+func div(a, b interface{}) (int64, error) {
+	d := toInt64(b)
+	if d == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return toInt64(a) / d, nil
+}
+
+func mod(a, b interface{}) (int64, error) {
+	d := toInt64(b)
+	if d == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return toInt64(a) % d, nil
+}
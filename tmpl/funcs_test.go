@@ -0,0 +1,115 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpl_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/tmpl"
+)
+
+func TestFuncMap(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"default with missing value", `{{ default "nginx" "" }}`, "nginx"},
+		{"default with value", `{{ default "nginx" "redis" }}`, "redis"},
+		{"empty", `{{ empty "" }} {{ empty "a" }}`, "true false"},
+		{"coalesce", `{{ coalesce "" 0 "first" "second" }}`, "first"},
+		{"ternary", `{{ ternary "yes" "no" true }}`, "yes"},
+		{"quote", `{{ quote "dory" }}`, `"dory"`},
+		{"squote", `{{ "dory" | squote }}`, `'dory'`},
+		{"indent", `{{ indent 2 "a\nb" }}`, "  a\n  b"},
+		{"nindent", `{{ nindent 2 "a" }}`, "\n  a"},
+		{"trim", `{{ trim "  dory  " }}`, "dory"},
+		{"upper and lower", `{{ upper "dory" }} {{ lower "DORY" }}`, "DORY dory"},
+		{"replace", `{{ "finding-nemo" | replace "-" " " }}`, "finding nemo"},
+		{"trunc", `{{ trunc 4 "finding" }}`, "find"},
+		{"join", `{{ splitList "," "a,b" | join "-" }}`, "a-b"},
+		{"b64enc", `{{ b64enc "dory" }}`, "ZG9yeQ=="},
+		{"b64dec", `{{ b64dec "ZG9yeQ==" }}`, "dory"},
+		{"sha256sum", `{{ sha256sum "dory" }}`, "5cb8ad155351b80ef8385b3beabce3be352abb773ba9f4e44854c814188a0936"},
+		{"toYaml", `{{ dict "name" "dory" | toYaml }}`, "name: dory"},
+		{"toJson", `{{ dict "name" "dory" | toJson }}`, `{"name":"dory"}`},
+		{"fromYaml", `{{ (fromYaml "name: dory").name }}`, "dory"},
+		{"hasKey", `{{ hasKey (dict "a" 1) "a" }}`, "true"},
+		{"keys", `{{ keys (dict "b" 1 "a" 2) | join "," }}`, "a,b"},
+		{"math", `{{ add 1 2 }} {{ sub 5 2 }} {{ mul 2 3 }} {{ div 7 2 }} {{ mod 7 2 }}`, "3 3 6 3 1"},
+		{"variadic math", `{{ add 1 2 3 }} {{ sub 10 2 3 }} {{ mul 2 3 4 }} {{ add }}`, "6 5 24 0"},
+		{"int", `{{ int "3" }}`, "3"},
+	}
+
+	for _, tt := range testCases {
+		tp, err := template.New(tt.name).Funcs(tmpl.FuncMap()).Parse(tt.template)
+		assert.Nil(t, err, tt.name)
+		buf := bytes.NewBufferString("")
+		err = tp.Execute(buf, nil)
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.expected, buf.String(), tt.name)
+	}
+}
+
+func TestFuncMapErrors(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		template string
+		err      string
+	}{
+		{"required", `{{ required "image is required" "" }}`, "image is required"},
+		{"fail", `{{ fail "something went wrong" }}`, "something went wrong"},
+		{"div by zero", `{{ div 1 0 }}`, "division by zero"},
+		{"dict with odd arguments", `{{ dict "a" }}`, "dict requires an even number of arguments"},
+	}
+
+	for _, tt := range testCases {
+		tp, err := template.New(tt.name).Funcs(tmpl.FuncMap()).Parse(tt.template)
+		assert.Nil(t, err, tt.name)
+		err = tp.Execute(bytes.NewBufferString(""), nil)
+		assert.NotNil(t, err, tt.name)
+		assert.Contains(t, err.Error(), tt.err, tt.name)
+	}
+}
+
+func TestParseIncludeRecursion(t *testing.T) {
+	dir := createTestDir([]templateFile{
+		{"0_base.tmpl", `{{ include "loop.tmpl" . }}`},
+		{"loop.tmpl", `{{ include "loop.tmpl" . }}`},
+	})
+	defer os.RemoveAll(dir)
+
+	err := tmpl.Parse(testCR, filepath.Join(dir, "*.tmpl"), bytes.NewBufferString(""))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `include "loop.tmpl": includes are nested more than 100 deep`)
+}
+
+func TestParseWithFunctions(t *testing.T) {
+	dir := createTestDir([]templateFile{
+		{"0_base.tmpl", `spec:{{ include "spec.tmpl" . | nindent 2 }}`},
+		{"spec.tmpl", `{{ toYaml .Resource.Object.spec }}`},
+	})
+	defer os.RemoveAll(dir)
+
+	buf := bytes.NewBufferString("")
+	err := tmpl.Parse(testCR, filepath.Join(dir, "*.tmpl"), buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "spec:\n  By: Disney\n  From: Finding Nemo\n  Name: Dory", buf.String())
+}
@@ -15,17 +15,178 @@
 package tmpl
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
 	"text/template"
 )
 
+// Options changes how templates are parsed and executed
+type Options struct {
+	Strict  bool // missing fields and map keys are an error instead of an empty value
+	PerFile bool // every file not starting with _ is rendered as its own document
+}
+
+// Templates is a set of parsed template files. It is safe to call Execute
+// from multiple goroutines.
+type Templates struct {
+	tmpl     *template.Template
+	render   []string // the templates executed in PerFile mode
+	options  Options
+	checksum string // sha256 of the file names and contents
+}
+
+// Load will parse all of the template files matching pattern so that they
+// can be executed many times. An error is returned if no files match or any
+// of the files fail to parse.
+func Load(pattern string, opts Options) (*Templates, error) {
+	files, err := ReadFiles(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return New(files, opts)
+}
+
+// ReadFiles will return the contents of all the files matching pattern keyed
+// by their base name. An error is returned if no files match.
+func ReadFiles(pattern string) (map[string]string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("template: pattern matches no files: %#q", pattern)
+	}
+
+	contents := make(map[string]string, len(files))
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		contents[filepath.Base(f)] = string(b)
+	}
+	return contents, nil
+}
+
+// New will parse the given templates, keyed by file name, so that they can be
+// executed many times. The first file name in sorted order is the one that
+// gets executed, the others can be used with template or include. With the
+// PerFile option every file whose name doesn't start with an underscore is
+// executed instead, and the files starting with an underscore are partials.
+func New(files map[string]string, opts Options) (*Templates, error) {
+	if len(files) == 0 {
+		return nil, errors.New("template: no templates to parse")
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tmpl := template.New(names[0]).Funcs(FuncMap())
+	if opts.Strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	var render []string
+	h := sha256.New()
+	for _, name := range names {
+		// the length of each file keeps the name and content boundaries unambiguous
+		fmt.Fprintf(h, "%s\x00%d\x00%s", name, len(files[name]), files[name])
+		t := tmpl
+		if name != tmpl.Name() {
+			t = tmpl.New(name)
+		}
+		if _, err := t.Parse(files[name]); err != nil {
+			return nil, err
+		}
+		if !isPartial(name) {
+			render = append(render, name)
+		}
+	}
+	if opts.PerFile && len(render) == 0 {
+		return nil, errors.New("template: no templates to render, every file is a partial")
+	}
+	return &Templates{tmpl: tmpl, render: render, options: opts, checksum: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Checksum will return the sha256 of the template files, which only changes
+// when a file is added, removed, renamed or edited.
+func (t *Templates) Checksum() string {
+	return t.checksum
+}
+
+// isPartial will return true for files that are only used by other templates
+// when rendering per file.
+func isPartial(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+// Execute will render the templates for the CustomResource and print the
+// result to the io.Writer. Nothing is written if rendering fails.
+func (t *Templates) Execute(cr *CustomResource, w io.Writer) error {
+	data := *cr
+	data.Strict = t.options.Strict
+	if data.Values == nil {
+		spec, _ := data.Resource.Object["spec"].(map[string]interface{})
+		data.Values = MergeValues(nil, spec)
+	}
+
+	// each execution gets its own copy of the templates, with an include that
+	// counts how deeply it is nested in this execution
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	depth := 0
+	tmpl.Funcs(template.FuncMap{"include": includeFunc(tmpl, &depth)})
+
+	var buf bytes.Buffer
+	if t.options.PerFile {
+		if err := t.executePerFile(tmpl, &buf, &data); err != nil {
+			return err
+		}
+	} else if err := tmpl.Execute(&buf, &data); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// executePerFile will render each template as a separate yaml document. Files
+// that render to nothing but whitespace are left out.
+func (t *Templates) executePerFile(tmpl *template.Template, buf *bytes.Buffer, data *CustomResource) error {
+	var doc bytes.Buffer
+	for _, name := range t.render {
+		doc.Reset()
+		if err := tmpl.ExecuteTemplate(&doc, name, data); err != nil {
+			return err
+		}
+		// a leading separator would make an empty document
+		content := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(doc.String()), "---"))
+		if content == "" {
+			continue
+		}
+		buf.WriteString("---\n# Source: " + name + "\n" + content + "\n")
+	}
+	return nil
+}
+
 // Parse will take a CustomResource, template directory and an io.Writer and
-// print the resulting templates to the io.Writer
+// print the resulting templates to the io.Writer. The functions from FuncMap
+// are available to all templates. If the CustomResource is Strict then missing
+// map keys are also an error. Nothing is written if rendering fails.
 func Parse(cr *CustomResource, dir string, w io.Writer) error {
-	tmpl, err := template.ParseGlob(dir)
+	t, err := Load(dir, Options{Strict: cr.Strict})
 	if err != nil {
 		return err
 	}
-
-	return tmpl.Execute(w, cr)
+	return t.Execute(cr, w)
 }
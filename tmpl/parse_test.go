@@ -101,3 +101,104 @@ func TestGenerateTemplateNoTemplatePath(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Empty(t, buf.String(), "If an error occurs nothing should be written")
 }
+
+func TestParseStrict(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		template string
+		err      string
+	}{
+		{"missing field", `image: {{ .GetField "spec" "image" }}`, `executing "0_base.tmpl" at <.GetField>: error calling GetField: field spec.image not found`},
+		{"missing key", `image: {{ .Resource.Object.spec.image }}`, `executing "0_base.tmpl" at <.Resource.Object.spec.image>: map has no entry for key "image"`},
+	}
+
+	for _, tt := range testCases {
+		dir := createTestDir([]templateFile{{"0_base.tmpl", tt.template}})
+		defer os.RemoveAll(dir)
+
+		strictCR := &tmpl.CustomResource{Resource: testResource, Strict: true}
+		buf := bytes.NewBufferString("")
+		err := tmpl.Parse(strictCR, filepath.Join(dir, "*.tmpl"), buf)
+		assert.NotNil(t, err, tt.name)
+		if err != nil {
+			assert.Contains(t, err.Error(), tt.err, tt.name)
+		}
+		assert.Empty(t, buf.String(), "If an error occurs nothing should be written")
+
+		buf.Reset()
+		err = tmpl.Parse(testCR, filepath.Join(dir, "*.tmpl"), buf)
+		assert.Nil(t, err, tt.name)
+	}
+}
+
+func TestNew(t *testing.T) {
+	files := map[string]string{
+		"file1.tmpl":  `name: {{ .GetField "metadata" "name"  }}-configmap`,
+		"0_base.tmpl": `--- {{template "file1.tmpl" . }}`,
+	}
+	templates, err := tmpl.New(files, tmpl.Options{})
+	assert.Nil(t, err)
+
+	buf := bytes.NewBufferString("")
+	err = templates.Execute(testCR, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "--- name: dory-configmap", buf.String())
+}
+
+func TestChecksum(t *testing.T) {
+	checksum := func(files map[string]string) string {
+		templates, err := tmpl.New(files, tmpl.Options{})
+		assert.Nil(t, err)
+		return templates.Checksum()
+	}
+	base := checksum(map[string]string{"a.tmpl": "kind: ConfigMap", "b.tmpl": "data: {}"})
+	assert.Len(t, base, 64)
+	assert.Equal(t, base, checksum(map[string]string{"b.tmpl": "data: {}", "a.tmpl": "kind: ConfigMap"}))
+	assert.NotEqual(t, base, checksum(map[string]string{"a.tmpl": "kind: ConfigMap", "b.tmpl": "data: {a: b}"}))
+	assert.NotEqual(t, base, checksum(map[string]string{"a.tmpl": "kind: ConfigMap", "c.tmpl": "data: {}"}))
+	assert.NotEqual(t, base, checksum(map[string]string{"a.tmpl": "kind: ConfigMap"}))
+}
+
+func TestNewWithoutTemplates(t *testing.T) {
+	templates, err := tmpl.New(map[string]string{}, tmpl.Options{})
+	assert.Nil(t, templates)
+	assert.NotNil(t, err)
+}
+
+func TestNewPerFile(t *testing.T) {
+	files := map[string]string{
+		"_helpers.tmpl":     `{{ define "name" }}{{ .GetField "metadata" "name" }}{{ end }}`,
+		"configmap.tmpl":    "---\nname: {{ template \"name\" . }}-configmap\n",
+		"deployment.tmpl":   `name: {{ include "name" . | upper }}-nginx`,
+		"not-rendered.tmpl": `{{ if .HasField "spec" "missing" }}name: missing{{ end }}`,
+		"_unused.tmpl":      `name: unused`,
+	}
+	templates, err := tmpl.New(files, tmpl.Options{PerFile: true})
+	assert.Nil(t, err)
+
+	buf := bytes.NewBufferString("")
+	err = templates.Execute(testCR, buf)
+	assert.Nil(t, err)
+	expected := "---\n# Source: configmap.tmpl\nname: dory-configmap\n" +
+		"---\n# Source: deployment.tmpl\nname: DORY-nginx\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestNewPerFileErrors(t *testing.T) {
+	templates, err := tmpl.New(map[string]string{"_helpers.tmpl": `{{ define "name" }}{{ end }}`}, tmpl.Options{PerFile: true})
+	assert.Nil(t, templates)
+	assert.EqualError(t, err, "template: no templates to render, every file is a partial")
+
+	templates, err = tmpl.New(map[string]string{
+		"configmap.tmpl": `name: dory`,
+		"secret.tmpl":    `name: {{ .GetField "spec" "missing" }}`,
+	}, tmpl.Options{PerFile: true, Strict: true})
+	assert.Nil(t, err)
+	buf := bytes.NewBufferString("")
+	err = templates.Execute(testCR, buf)
+	assert.NotNil(t, err)
+	if err != nil {
+		assert.Contains(t, err.Error(), `executing "secret.tmpl"`)
+	}
+	assert.Empty(t, buf.String(), "If an error occurs nothing should be written")
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpl
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+)
+
+// ReadValues will read a yaml file of default values. An empty file has no
+// values.
+func ReadValues(file string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return nil, fmt.Errorf("invalid values file %s: %s", file, err)
+	}
+	return values, nil
+}
+
+// MergeValues will deep merge values on top of defaults and return the result
+// without changing either of them. Maps are merged key by key, any other value
+// replaces the default, and a null value removes the default.
+func MergeValues(defaults, values map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults)+len(values))
+	for key, val := range defaults {
+		merged[key] = copyValue(val)
+	}
+	for key, val := range values {
+		if val == nil {
+			delete(merged, key)
+			continue
+		}
+		src, srcOK := val.(map[string]interface{})
+		dst, dstOK := merged[key].(map[string]interface{})
+		if srcOK && dstOK {
+			merged[key] = MergeValues(dst, src)
+			continue
+		}
+		merged[key] = copyValue(val)
+	}
+	return merged
+}
+
+// copyValue will return a deep copy of the maps and slices in a value so that
+// templates can't change the defaults or the custom resource.
+func copyValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return MergeValues(nil, v)
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i := range v {
+			c[i] = copyValue(v[i])
+		}
+		return c
+	default:
+		return v
+	}
+}
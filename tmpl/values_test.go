@@ -0,0 +1,97 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpl_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/tmpl"
+)
+
+func TestMergeValues(t *testing.T) {
+	defaults := map[string]interface{}{
+		"replicas": float64(1),
+		"image":    map[string]interface{}{"name": "nginx", "tag": "alpine"},
+		"ports":    []interface{}{float64(80)},
+		"debug":    true,
+	}
+	spec := map[string]interface{}{
+		"replicas": float64(3),
+		"image":    map[string]interface{}{"tag": "latest"},
+		"ports":    []interface{}{float64(8080)},
+		"debug":    nil,
+	}
+
+	merged := tmpl.MergeValues(defaults, spec)
+
+	assert.Equal(t, map[string]interface{}{
+		"replicas": float64(3),
+		"image":    map[string]interface{}{"name": "nginx", "tag": "latest"},
+		"ports":    []interface{}{float64(8080)},
+	}, merged)
+	// neither of the inputs is changed
+	assert.Equal(t, "alpine", defaults["image"].(map[string]interface{})["tag"])
+	assert.Equal(t, true, defaults["debug"])
+	merged["ports"].([]interface{})[0] = float64(443)
+	assert.Equal(t, float64(8080), spec["ports"].([]interface{})[0])
+}
+
+func TestReadValues(t *testing.T) {
+	dir := createTestDir([]templateFile{
+		{"values.yaml", "replicas: 2\nimage:\n  name: nginx\n"},
+		{"empty.yaml", ""},
+		{"invalid.yaml", "replicas: [2"},
+	})
+	defer os.RemoveAll(dir)
+
+	values, err := tmpl.ReadValues(filepath.Join(dir, "values.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"replicas": float64(2), "image": map[string]interface{}{"name": "nginx"}}, values)
+
+	values, err = tmpl.ReadValues(filepath.Join(dir, "empty.yaml"))
+	assert.Nil(t, err)
+	assert.Empty(t, values)
+
+	_, err = tmpl.ReadValues(filepath.Join(dir, "invalid.yaml"))
+	assert.NotNil(t, err)
+
+	_, err = tmpl.ReadValues(filepath.Join(dir, "missing.yaml"))
+	assert.NotNil(t, err)
+}
+
+func TestExecuteValues(t *testing.T) {
+	templates, err := tmpl.New(map[string]string{
+		"0_base.tmpl": `{{ .Name }}: {{ .Values.Name }} {{ .Values.replicas }}`,
+	}, tmpl.Options{})
+	assert.Nil(t, err)
+
+	// without defaults the values are the spec
+	buf := bytes.NewBufferString("")
+	assert.Nil(t, templates.Execute(testCR, buf))
+	assert.Equal(t, "dory: Dory <no value>", buf.String())
+
+	buf.Reset()
+	spec := testResource.Object["spec"].(map[string]interface{})
+	cr := &tmpl.CustomResource{
+		Resource: testResource,
+		Values:   tmpl.MergeValues(map[string]interface{}{"Name": "Nemo", "replicas": 2}, spec),
+	}
+	assert.Nil(t, templates.Execute(cr, buf))
+	assert.Equal(t, "dory: Dory 2", buf.String())
+}
@@ -0,0 +1,359 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wpengine/lostromos/manifest"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+// FieldManager owns the fields that lostromos sets with server-side apply
+const FieldManager = "lostromos"
+
+// applyPatchType is the content type of a server-side apply, which this
+// version of apimachinery doesn't know about
+const applyPatchType types.PatchType = "application/apply-patch+yaml"
+
+// The actions in a Result
+const (
+	ActionApplied  = "applied"
+	ActionDeleted  = "deleted"
+	ActionNotFound = "not found"
+	ActionFailed   = "failed"
+)
+
+// Result is what happened to a single object
+type Result struct {
+	Object manifest.Ref
+	Action string
+	Err    error // set when the Action is ActionFailed
+}
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s %s: %s", r.Object.Key(), r.Action, r.Err)
+	}
+	return r.Object.Key() + " " + r.Action
+}
+
+// Results are the Result for each object, in the order they were sent
+type Results []Result
+
+// String will return one line for each object, like the output of kubectl
+func (rs Results) String() string {
+	lines := make([]string, len(rs))
+	for i, r := range rs {
+		lines[i] = r.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Err will return the errors of the objects that failed, or nil
+func (rs Results) Err() error {
+	var errs manifest.Errors
+	for _, r := range rs {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", r.Object.Key(), r.Err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ObjectClient is a KubeClient that is sent the parsed objects instead of a
+// file, and reports what happened to each of them
+type ObjectClient interface {
+	KubeClient
+	// ApplyObjects applies each of the objects
+	ApplyObjects(objs []manifest.Object) Results
+	// DeleteObjects deletes each of the objects, ones that don't exist
+	// aren't an error
+	DeleteObjects(refs []manifest.Ref) Results
+}
+
+// APIClient is an ObjectClient that talks to the Kubernetes API directly instead
+// of running kubectl. Objects are applied with server-side apply, owned by
+// FieldManager, so the API server merges them with the fields set by others.
+// It needs a cluster that supports server-side apply.
+type APIClient struct {
+	Namespace string               // namespace for namespaced objects that don't set one
+	REST      restclient.Interface // client for the API server, used for every group and version
+	As        string               // optional, user to impersonate
+	Force     bool                 // take fields owned by other field managers when they conflict, instead of failing
+	Logger    *zap.SugaredLogger   // optional, where the conflicts that were forced are logged
+	parent    *APIClient           // the client that discovers the resources when impersonating
+	mu        sync.Mutex
+	resources map[string]map[string]metav1.APIResource // group version to kind to resource
+}
+
+// NewAPIClient will return an APIClient for the cluster, namespaced objects
+// that don't set a namespace go in namespace. Conflicts with other field
+// managers fail until Force is set.
+func NewAPIClient(kubeCfg *restclient.Config, namespace string) (*APIClient, error) {
+	cfg := *kubeCfg
+	// the dynamic client can't set the field manager, so its content config
+	// is used with a plain REST client and absolute paths
+	cfg.ContentConfig = dynamic.ContentConfig()
+	cfg.ContentConfig.GroupVersion = &schema.GroupVersion{Version: "v1"}
+	cfg.APIPath = "/api"
+	rc, err := restclient.RESTClientFor(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &APIClient{Namespace: namespace, REST: rc}, nil
+}
+
+// Apply will apply the objects in file, continuing past objects that fail
+func (c *APIClient) Apply(file string) (string, error) {
+	objs, err := readManifest(file)
+	if err != nil {
+		return "", err
+	}
+	results := c.ApplyObjects(objs)
+	return results.String(), results.Err()
+}
+
+// Delete will delete the objects in file, objects that don't exist aren't
+// an error
+func (c *APIClient) Delete(file string) (string, error) {
+	objs, err := readManifest(file)
+	if err != nil {
+		return "", err
+	}
+	results := c.DeleteObjects(manifest.Refs(objs))
+	return results.String(), results.Err()
+}
+
+// Get will return a json List of the objects in file that exist
+func (c *APIClient) Get(file string) (string, error) {
+	objs, err := readManifest(file)
+	if err != nil {
+		return "", err
+	}
+	live, err := c.GetObjects(manifest.Refs(objs))
+	if err != nil {
+		return "", err
+	}
+	items := make([]interface{}, len(live))
+	for i, obj := range live {
+		items[i] = obj.Object
+	}
+	b, err := json.Marshal(map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": items})
+	return string(b), err
+}
+
+// Impersonate will return an APIClient that sends everything as user. The
+// resources are still discovered as the user of this client.
+func (c *APIClient) Impersonate(user string) KubeClient {
+	return &APIClient{Namespace: c.Namespace, REST: c.REST, As: user, Force: c.Force, Logger: c.Logger, parent: c}
+}
+
+// ApplyObjects will apply each of the objects with server-side apply. When a
+// field is owned by another field manager the object fails, or with Force the
+// conflict is logged and the object is applied again taking the field.
+func (c *APIClient) ApplyObjects(objs []manifest.Object) Results {
+	results := make(Results, len(objs))
+	for i, obj := range objs {
+		ref := manifest.RefFor(obj.Unstructured)
+		results[i] = Result{Object: ref, Action: ActionApplied}
+		p, ns, err := c.path(ref)
+		if err == nil {
+			body := obj.Unstructured.DeepCopy()
+			if ns != "" {
+				body.SetNamespace(ns)
+			}
+			var data []byte
+			if data, err = json.Marshal(body.Object); err == nil {
+				err = c.apply(p, data, false)
+				if apierrors.IsConflict(err) && c.Force {
+					if c.Logger != nil {
+						c.Logger.Warnw("taking fields owned by another field manager", "object", ref.Key(), "conflicts", err.Error())
+					}
+					err = c.apply(p, data, true)
+				}
+			}
+		}
+		if err != nil {
+			results[i].Action = ActionFailed
+			results[i].Err = err
+		}
+	}
+	return results
+}
+
+// apply will send a server-side apply of data to the object at p
+func (c *APIClient) apply(p string, data []byte, force bool) error {
+	_, err := c.as(c.REST.Patch(applyPatchType)).AbsPath(p).
+		Param("fieldManager", FieldManager).
+		Param("force", strconv.FormatBool(force)).
+		Body(data).Do().Raw()
+	return err
+}
+
+// DeleteObjects will delete each of the objects, letting the garbage
+// collector delete their dependents in the background
+func (c *APIClient) DeleteObjects(refs []manifest.Ref) Results {
+	propagation := metav1.DeletePropagationBackground
+	opts, _ := json.Marshal(&metav1.DeleteOptions{
+		TypeMeta:          metav1.TypeMeta{APIVersion: "v1", Kind: "DeleteOptions"},
+		PropagationPolicy: &propagation,
+	})
+	results := make(Results, len(refs))
+	for i, ref := range refs {
+		results[i] = Result{Object: ref, Action: ActionDeleted}
+		p, _, err := c.path(ref)
+		if err == nil {
+			_, err = c.as(c.REST.Delete()).AbsPath(p).Body(opts).Do().Raw()
+		}
+		if apierrors.IsNotFound(err) {
+			results[i].Action = ActionNotFound
+		} else if err != nil {
+			results[i].Action = ActionFailed
+			results[i].Err = err
+		}
+	}
+	return results
+}
+
+// GetObjects will return the objects that exist
+func (c *APIClient) GetObjects(refs []manifest.Ref) ([]*unstructured.Unstructured, error) {
+	var live []*unstructured.Unstructured
+	for _, ref := range refs {
+		p, _, err := c.path(ref)
+		if err != nil {
+			return nil, err
+		}
+		b, err := c.as(c.REST.Get()).AbsPath(p).Do().Raw()
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(b, &obj.Object); err != nil {
+			return nil, err
+		}
+		live = append(live, obj)
+	}
+	return live, nil
+}
+
+// as will set the user to impersonate on the request
+func (c *APIClient) as(req *restclient.Request) *restclient.Request {
+	if c.As != "" {
+		req.SetHeader(transport.ImpersonateUserHeader, c.As)
+	}
+	return req
+}
+
+// path will return the API path of the object and the namespace it is in. It
+// fails for a namespaced object when neither it nor the client has a
+// namespace.
+func (c *APIClient) path(ref manifest.Ref) (string, string, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return "", "", err
+	}
+	res, err := c.resource(gv, ref.Kind)
+	if err != nil {
+		return "", "", err
+	}
+	segments := []string{"/apis", gv.Group, gv.Version}
+	if gv.Group == "" {
+		segments = []string{"/api", gv.Version}
+	}
+	ns := ""
+	if res.Namespaced {
+		ns = ref.Namespace
+		if ns == "" {
+			ns = c.Namespace
+		}
+		if ns == "" {
+			return "", "", fmt.Errorf("%s is namespaced, but no namespace was given for it", ref.Key())
+		}
+		segments = append(segments, "namespaces", ns)
+	}
+	segments = append(segments, res.Name, ref.Name)
+	return path.Join(segments...), ns, nil
+}
+
+// resource will find the resource for the kind using discovery. Discovery is
+// done again when the kind isn't found, in case it was just created by a
+// CustomResourceDefinition.
+func (c *APIClient) resource(gv schema.GroupVersion, kind string) (metav1.APIResource, error) {
+	if c.parent != nil {
+		return c.parent.resource(gv, kind)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if res, ok := c.resources[gv.String()][kind]; ok {
+		return res, nil
+	}
+	p := path.Join("/apis", gv.Group, gv.Version)
+	if gv.Group == "" {
+		p = path.Join("/api", gv.Version)
+	}
+	b, err := c.REST.Get().AbsPath(p).Do().Raw()
+	if err != nil {
+		return metav1.APIResource{}, fmt.Errorf("failed to discover the resources in %s: %s", gv, err)
+	}
+	list := &metav1.APIResourceList{}
+	if err := json.Unmarshal(b, list); err != nil {
+		return metav1.APIResource{}, fmt.Errorf("failed to discover the resources in %s: %s", gv, err)
+	}
+	kinds := map[string]metav1.APIResource{}
+	for _, res := range list.APIResources {
+		// subresources such as deployments/scale have the kind of another object
+		if !strings.Contains(res.Name, "/") {
+			kinds[res.Kind] = res
+		}
+	}
+	if c.resources == nil {
+		c.resources = map[string]map[string]metav1.APIResource{}
+	}
+	c.resources[gv.String()] = kinds
+	res, ok := kinds[kind]
+	if !ok {
+		return res, fmt.Errorf("the server doesn't have a resource for %s in %s", kind, gv)
+	}
+	return res, nil
+}
+
+func readManifest(file string) ([]manifest.Object, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Parse(b)
+}
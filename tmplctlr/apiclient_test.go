@@ -0,0 +1,250 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/tmplctlr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	restclient "k8s.io/client-go/rest"
+)
+
+// fakeAPIServer serves discovery for v1 and apps/v1 and stores the objects
+// sent to it by path. Applies to the paths in conflicts fail unless forced.
+type fakeAPIServer struct {
+	sync.Mutex
+	objects   map[string]string
+	conflicts map[string]bool
+	requests  []*http.Request
+	bodies    []string
+}
+
+const (
+	notFoundStatus = `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`
+	conflictStatus = `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "Conflict", "code": 409,
+		"message": "Apply failed with 1 conflict: conflict with \"kubectl\": .data.fish"}`
+)
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	f.requests = append(f.requests, r)
+	f.bodies = append(f.bodies, string(body))
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path {
+	case "/api/v1":
+		w.Write([]byte(`{"kind": "APIResourceList", "groupVersion": "v1", "resources": [
+			{"name": "configmaps", "namespaced": true, "kind": "ConfigMap"},
+			{"name": "namespaces", "namespaced": false, "kind": "Namespace"}
+		]}`))
+		return
+	case "/apis/apps/v1":
+		w.Write([]byte(`{"kind": "APIResourceList", "groupVersion": "apps/v1", "resources": [
+			{"name": "deployments", "namespaced": true, "kind": "Deployment"},
+			{"name": "deployments/scale", "namespaced": true, "kind": "Scale"}
+		]}`))
+		return
+	}
+	switch r.Method {
+	case "PATCH":
+		if f.conflicts[r.URL.Path] && r.URL.Query().Get("force") != "true" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(conflictStatus))
+			return
+		}
+		f.objects[r.URL.Path] = string(body)
+		w.Write(body)
+	case "GET", "DELETE":
+		obj, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(notFoundStatus))
+			return
+		}
+		if r.Method == "DELETE" {
+			delete(f.objects, r.URL.Path)
+		}
+		w.Write([]byte(obj))
+	}
+}
+
+func newAPIClient(t *testing.T) (*tmplctlr.APIClient, *fakeAPIServer, func()) {
+	f := &fakeAPIServer{objects: map[string]string{}, conflicts: map[string]bool{}}
+	srv := httptest.NewServer(f)
+	c, err := tmplctlr.NewAPIClient(&restclient.Config{Host: srv.URL}, "default")
+	assert.Nil(t, err)
+	return c, f, srv.Close
+}
+
+func writeManifest(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "manifest")
+	assert.Nil(t, err)
+	defer f.Close()
+	f.WriteString(content)
+	return f.Name()
+}
+
+const apiTestManifest = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dory
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dory
+  namespace: ocean
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: ocean
+`
+
+func TestAPIClientApply(t *testing.T) {
+	c, f, cleanup := newAPIClient(t)
+	defer cleanup()
+	file := writeManifest(t, apiTestManifest)
+	defer os.Remove(file)
+
+	out, err := c.Apply(file)
+	assert.Nil(t, err)
+	assert.Equal(t, "core/ConfigMap dory applied\napps/Deployment ocean/dory applied\ncore/Namespace ocean applied", out)
+
+	assert.Contains(t, f.objects, "/api/v1/namespaces/default/configmaps/dory")
+	assert.Contains(t, f.objects, "/apis/apps/v1/namespaces/ocean/deployments/dory")
+	assert.Contains(t, f.objects, "/api/v1/namespaces/ocean")
+
+	var patch *http.Request
+	for i, r := range f.requests {
+		if r.Method == "PATCH" {
+			patch = r
+			var obj map[string]interface{}
+			assert.Nil(t, json.Unmarshal([]byte(f.bodies[i]), &obj))
+			assert.Equal(t, "default", obj["metadata"].(map[string]interface{})["namespace"])
+			break
+		}
+	}
+	assert.Equal(t, "application/apply-patch+yaml", patch.Header.Get("Content-Type"))
+	assert.Equal(t, tmplctlr.FieldManager, patch.URL.Query().Get("fieldManager"))
+	assert.Equal(t, "false", patch.URL.Query().Get("force"))
+}
+
+func TestAPIClientApplyConflict(t *testing.T) {
+	c, f, cleanup := newAPIClient(t)
+	defer cleanup()
+	f.conflicts["/api/v1/namespaces/default/configmaps/dory"] = true
+	objs := manifest.Objects([]manifest.Ref{{APIVersion: "v1", Kind: "ConfigMap", Name: "dory"}})
+
+	results := c.ApplyObjects(objs)
+	assert.Equal(t, tmplctlr.ActionFailed, results[0].Action)
+	assert.Contains(t, results[0].Err.Error(), "conflict")
+	assert.Empty(t, f.objects)
+
+	core, logs := observer.New(zap.WarnLevel)
+	c.Force = true
+	c.Logger = zap.New(core).Sugar()
+	results = c.ApplyObjects(objs)
+	assert.Equal(t, tmplctlr.ActionApplied, results[0].Action)
+	assert.Contains(t, f.objects, "/api/v1/namespaces/default/configmaps/dory")
+	assert.Equal(t, "true", f.requests[len(f.requests)-1].URL.Query().Get("force"))
+	assert.Equal(t, 1, logs.FilterField(zap.String("object", "core/ConfigMap dory")).Len())
+}
+
+func TestAPIClientNoNamespace(t *testing.T) {
+	c, f, cleanup := newAPIClient(t)
+	defer cleanup()
+	c.Namespace = ""
+
+	results := c.ApplyObjects(manifest.Objects([]manifest.Ref{
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "dory"},
+		{APIVersion: "v1", Kind: "Namespace", Name: "ocean"},
+	}))
+	assert.Equal(t, tmplctlr.ActionFailed, results[0].Action)
+	assert.Contains(t, results[0].Err.Error(), "namespaced")
+	assert.Equal(t, tmplctlr.ActionApplied, results[1].Action)
+	for _, r := range f.requests {
+		assert.NotContains(t, r.URL.Path, "configmaps")
+	}
+}
+
+func TestAPIClientApplyUnknownKind(t *testing.T) {
+	c, f, cleanup := newAPIClient(t)
+	defer cleanup()
+
+	results := c.ApplyObjects(manifest.Objects([]manifest.Ref{
+		{APIVersion: "v1", Kind: "Fish", Name: "dory"},
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "dory"},
+	}))
+	assert.Equal(t, tmplctlr.ActionFailed, results[0].Action)
+	assert.NotNil(t, results[0].Err)
+	assert.Equal(t, tmplctlr.ActionApplied, results[1].Action)
+	assert.NotNil(t, results.Err())
+	assert.Len(t, f.objects, 1)
+}
+
+func TestAPIClientGetAndDelete(t *testing.T) {
+	c, f, cleanup := newAPIClient(t)
+	defer cleanup()
+	f.objects["/api/v1/namespaces/default/configmaps/dory"] = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "dory", "namespace": "default"}}`
+	file := writeManifest(t, apiTestManifest)
+	defer os.Remove(file)
+
+	out, err := c.Get(file)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"apiVersion": "v1", "kind": "List", "items": [
+		{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "dory", "namespace": "default"}}
+	]}`, out)
+
+	out, err = c.Delete(file)
+	assert.Nil(t, err)
+	assert.Equal(t, "core/ConfigMap dory deleted\napps/Deployment ocean/dory not found\ncore/Namespace ocean not found", out)
+	assert.Empty(t, f.objects)
+
+	last := f.bodies[len(f.bodies)-1]
+	assert.Contains(t, last, `"propagationPolicy":"Background"`)
+}
+
+func TestAPIClientImpersonate(t *testing.T) {
+	c, f, cleanup := newAPIClient(t)
+	defer cleanup()
+	file := writeManifest(t, apiTestManifest)
+	defer os.Remove(file)
+
+	_, err := c.Impersonate("system:serviceaccount:ocean:deployer").Apply(file)
+	assert.Nil(t, err)
+
+	for _, r := range f.requests {
+		if r.Method == "PATCH" {
+			assert.Equal(t, "system:serviceaccount:ocean:deployer", r.Header.Get("Impersonate-User"), r.URL.Path)
+		} else {
+			// discovery is done as lostromos
+			assert.Empty(t, r.Header.Get("Impersonate-User"), r.URL.Path)
+		}
+	}
+}
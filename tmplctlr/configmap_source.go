@@ -0,0 +1,233 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ConfigMapSource reads templates from the keys ending in .tmpl of one or more
+// ConfigMaps or Secrets in a namespace. They are selected by name, or by label
+// when no names are given.
+type ConfigMapSource struct {
+	Kind     string                    // ConfigMap or Secret
+	Names    []string                  // names of the ConfigMaps or Secrets
+	Selector string                    // label selector used when Names is empty
+	Client   dynamic.ResourceInterface // client for the ConfigMaps or Secrets in the namespace
+}
+
+// NewConfigMapSource will return a ConfigMapSource for the ConfigMaps, or the
+// Secrets when kind is Secret, in the given namespace.
+func NewConfigMapSource(kubeCfg *restclient.Config, kind, namespace string, names []string, selector string) (*ConfigMapSource, error) {
+	resource := "configmaps"
+	switch strings.ToLower(kind) {
+	case "", "configmap":
+		kind = "ConfigMap"
+	case "secret":
+		kind = "Secret"
+		resource = "secrets"
+	default:
+		return nil, fmt.Errorf("templates can't be loaded from %s, only ConfigMap and Secret are supported", kind)
+	}
+	if len(names) == 0 && selector == "" {
+		return nil, errors.New("a name or label selector is required to load templates from a " + kind)
+	}
+
+	cfg := *kubeCfg
+	cfg.ContentConfig.GroupVersion = &schema.GroupVersion{Version: "v1"}
+	cfg.APIPath = "/api"
+	dc, err := dynamic.NewClient(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	apiResource := &metav1.APIResource{Name: resource, Namespaced: true}
+	return &ConfigMapSource{
+		Kind:     kind,
+		Names:    names,
+		Selector: selector,
+		Client:   dc.Resource(apiResource, namespace),
+	}, nil
+}
+
+// Templates returns the contents of every key ending in .tmpl. It is an error
+// for the same key to be in more than one of the ConfigMaps or Secrets.
+func (s ConfigMapSource) Templates() (map[string]string, error) {
+	objs, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{}
+	from := map[string]string{}
+	for _, obj := range objs {
+		data, err := s.data(obj)
+		if err != nil {
+			return nil, err
+		}
+		for key, content := range data {
+			if !strings.HasSuffix(key, ".tmpl") {
+				continue
+			}
+			if other, ok := from[key]; ok {
+				return nil, fmt.Errorf("template %s is in both %s %s and %s", key, s.Kind, other, obj.GetName())
+			}
+			files[key] = content
+			from[key] = obj.GetName()
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no templates found in %s %s", s.Kind, s.describe())
+	}
+	return files, nil
+}
+
+// Watch calls changed when any of the selected ConfigMaps or Secrets are
+// added, updated or deleted. Named ones are each watched with a field selector
+// on their name, so that nothing else in the namespace is listed or watched.
+func (s ConfigMapSource) Watch(stopCh <-chan struct{}, changed func()) error {
+	if len(s.Names) == 0 {
+		s.watch(stopCh, changed, metav1.ListOptions{LabelSelector: s.Selector})
+		return nil
+	}
+	for _, name := range s.Names {
+		s.watch(stopCh, changed, metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String()})
+	}
+	return nil
+}
+
+// watch will start an informer for the ConfigMaps or Secrets matching the
+// selectors in opts
+func (s ConfigMapSource) watch(stopCh <-chan struct{}, changed func(), opts metav1.ListOptions) {
+	selectors := func(o metav1.ListOptions) metav1.ListOptions {
+		o.LabelSelector = opts.LabelSelector
+		o.FieldSelector = opts.FieldSelector
+		return o
+	}
+	// the objects from the first list were already loaded, so adding them to
+	// the informer's store isn't a change
+	var (
+		once    sync.Once
+		initial = map[string]string{}
+	)
+	lw := &cache.ListWatch{
+		ListFunc: func(o metav1.ListOptions) (runtime.Object, error) {
+			l, err := s.Client.List(selectors(o))
+			if list, ok := l.(*unstructured.UnstructuredList); ok && err == nil {
+				once.Do(func() {
+					for _, item := range list.Items {
+						initial[item.GetName()] = item.GetResourceVersion()
+					}
+				})
+			}
+			return l, err
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			return s.Client.Watch(selectors(o))
+		},
+	}
+	_, ctlr := cache.NewInformer(lw, &unstructured.Unstructured{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			r := obj.(*unstructured.Unstructured)
+			if rv, ok := initial[r.GetName()]; ok && rv == r.GetResourceVersion() {
+				return
+			}
+			changed()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldR := oldObj.(*unstructured.Unstructured)
+			newR := newObj.(*unstructured.Unstructured)
+			if oldR.GetResourceVersion() == newR.GetResourceVersion() {
+				return
+			}
+			changed()
+		},
+		DeleteFunc: func(obj interface{}) {
+			changed()
+		},
+	})
+	go ctlr.Run(stopCh)
+}
+
+func (s ConfigMapSource) list() ([]*unstructured.Unstructured, error) {
+	if len(s.Names) == 0 {
+		l, err := s.Client.List(metav1.ListOptions{LabelSelector: s.Selector})
+		if err != nil {
+			return nil, err
+		}
+		list, ok := l.(*unstructured.UnstructuredList)
+		if !ok {
+			return nil, fmt.Errorf("unexpected type %T listing %s", l, s.Kind)
+		}
+		objs := make([]*unstructured.Unstructured, 0, len(list.Items))
+		for i := range list.Items {
+			objs = append(objs, &list.Items[i])
+		}
+		// sort so errors about duplicate keys are consistent
+		sort.Slice(objs, func(i, j int) bool { return objs[i].GetName() < objs[j].GetName() })
+		return objs, nil
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(s.Names))
+	for _, name := range s.Names {
+		obj, err := s.Client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// data returns the data of the ConfigMap or Secret, the values of a Secret
+// are decoded.
+func (s ConfigMapSource) data(obj *unstructured.Unstructured) (map[string]string, error) {
+	raw, _ := obj.Object["data"].(map[string]interface{})
+	data := make(map[string]string, len(raw))
+	for key, val := range raw {
+		str, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s %s has a non string value for %s", s.Kind, obj.GetName(), key)
+		}
+		if s.Kind == "Secret" {
+			b, err := base64.StdEncoding.DecodeString(str)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s has an invalid value for %s: %s", s.Kind, obj.GetName(), key, err)
+			}
+			str = string(b)
+		}
+		data[key] = str
+	}
+	return data, nil
+}
+
+func (s ConfigMapSource) describe() string {
+	if len(s.Names) == 0 {
+		return "matching " + s.Selector
+	}
+	return strings.Join(s.Names, ", ")
+}
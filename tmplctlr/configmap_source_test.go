@@ -0,0 +1,244 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/tmplctlr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+)
+
+// fakeConfigMaps implements the parts of dynamic.ResourceInterface used by
+// the ConfigMapSource
+type fakeConfigMaps struct {
+	dynamic.ResourceInterface
+	objs          map[string]*unstructured.Unstructured
+	watcher       *watch.FakeWatcher
+	labelSelector string
+	sync.Mutex
+	watched []string // the field selectors of each watch
+}
+
+func newFakeConfigMaps(objs ...*unstructured.Unstructured) *fakeConfigMaps {
+	f := &fakeConfigMaps{objs: map[string]*unstructured.Unstructured{}, watcher: watch.NewFake()}
+	for _, obj := range objs {
+		f.objs[obj.GetName()] = obj
+	}
+	return f
+}
+
+func (f *fakeConfigMaps) Get(name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+	if obj, ok := f.objs[name]; ok {
+		return obj, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (f *fakeConfigMaps) List(opts metav1.ListOptions) (runtime.Object, error) {
+	f.Lock()
+	f.labelSelector = opts.LabelSelector
+	f.Unlock()
+	list := &unstructured.UnstructuredList{}
+	for _, obj := range f.objs {
+		list.Items = append(list.Items, *obj)
+	}
+	return list, nil
+}
+
+func (f *fakeConfigMaps) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.watched = append(f.watched, opts.FieldSelector)
+	return f.watcher, nil
+}
+
+func (f *fakeConfigMaps) watchedSelectors() []string {
+	f.Lock()
+	defer f.Unlock()
+	watched := append([]string(nil), f.watched...)
+	sort.Strings(watched)
+	return watched
+}
+
+func configMap(name string, data map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":            name,
+				"resourceVersion": "1",
+			},
+			"data": data,
+		},
+	}
+}
+
+func TestNewConfigMapSource(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		kind     string
+		names    []string
+		selector string
+		errors   bool
+	}{
+		{"ConfigMap by name", "ConfigMap", []string{"templates"}, "", false},
+		{"default to ConfigMap", "", []string{"templates"}, "", false},
+		{"Secret by label", "secret", nil, "app=lostromos", false},
+		{"unsupported kind", "Deployment", []string{"templates"}, "", true},
+		{"no name or selector", "ConfigMap", nil, "", true},
+	}
+
+	for _, tt := range testCases {
+		s, err := tmplctlr.NewConfigMapSource(&restclient.Config{}, tt.kind, "default", tt.names, tt.selector)
+		if tt.errors {
+			assert.NotNil(t, err, tt.name)
+			assert.Nil(t, s, tt.name)
+		} else {
+			assert.Nil(t, err, tt.name)
+			assert.NotNil(t, s.Client, tt.name)
+		}
+	}
+}
+
+func TestConfigMapSourceTemplatesByName(t *testing.T) {
+	s := tmplctlr.ConfigMapSource{
+		Kind:  "ConfigMap",
+		Names: []string{"base", "files"},
+		Client: newFakeConfigMaps(
+			configMap("base", map[string]interface{}{"0_base.tmpl": `--- {{template "file1.tmpl" . }}`}),
+			configMap("files", map[string]interface{}{"file1.tmpl": "name: dory", "README": "ignored"}),
+			configMap("other", map[string]interface{}{"other.tmpl": "not selected"}),
+		),
+	}
+
+	files, err := s.Templates()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"0_base.tmpl": `--- {{template "file1.tmpl" . }}`,
+		"file1.tmpl":  "name: dory",
+	}, files)
+}
+
+func TestConfigMapSourceTemplatesBySelector(t *testing.T) {
+	client := newFakeConfigMaps(configMap("base", map[string]interface{}{"0_base.tmpl": "name: dory"}))
+	s := tmplctlr.ConfigMapSource{Kind: "ConfigMap", Selector: "app=lostromos", Client: client}
+
+	files, err := s.Templates()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"0_base.tmpl": "name: dory"}, files)
+	assert.Equal(t, "app=lostromos", client.labelSelector)
+}
+
+func TestConfigMapSourceTemplatesFromSecret(t *testing.T) {
+	s := tmplctlr.ConfigMapSource{
+		Kind:   "Secret",
+		Names:  []string{"base"},
+		Client: newFakeConfigMaps(configMap("base", map[string]interface{}{"0_base.tmpl": "bmFtZTogZG9yeQ=="})),
+	}
+
+	files, err := s.Templates()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"0_base.tmpl": "name: dory"}, files)
+}
+
+func TestConfigMapSourceTemplatesErrors(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		source tmplctlr.ConfigMapSource
+	}{
+		{"missing ConfigMap", tmplctlr.ConfigMapSource{
+			Kind:   "ConfigMap",
+			Names:  []string{"missing"},
+			Client: newFakeConfigMaps(),
+		}},
+		{"no templates", tmplctlr.ConfigMapSource{
+			Kind:   "ConfigMap",
+			Names:  []string{"base"},
+			Client: newFakeConfigMaps(configMap("base", map[string]interface{}{"README": "no templates"})),
+		}},
+		{"duplicate templates", tmplctlr.ConfigMapSource{
+			Kind:  "ConfigMap",
+			Names: []string{"base", "copy"},
+			Client: newFakeConfigMaps(
+				configMap("base", map[string]interface{}{"0_base.tmpl": "name: dory"}),
+				configMap("copy", map[string]interface{}{"0_base.tmpl": "name: nemo"}),
+			),
+		}},
+		{"invalid secret", tmplctlr.ConfigMapSource{
+			Kind:   "Secret",
+			Names:  []string{"base"},
+			Client: newFakeConfigMaps(configMap("base", map[string]interface{}{"0_base.tmpl": "not base64!"})),
+		}},
+	}
+
+	for _, tt := range testCases {
+		files, err := tt.source.Templates()
+		assert.Nil(t, files, tt.name)
+		assert.NotNil(t, err, tt.name)
+	}
+}
+
+func TestConfigMapSourceWatch(t *testing.T) {
+	client := newFakeConfigMaps(configMap("base", map[string]interface{}{"0_base.tmpl": "name: dory"}))
+	s := tmplctlr.ConfigMapSource{Kind: "ConfigMap", Names: []string{"base"}, Client: client}
+	changed := make(chan struct{}, 10)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	assert.Nil(t, s.Watch(stopCh, func() { changed <- struct{}{} }))
+
+	updated := configMap("base", map[string]interface{}{"0_base.tmpl": "name: nemo"})
+	updated.SetResourceVersion("2")
+	client.watcher.Modify(updated)
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("changed was not called after the ConfigMap was updated")
+	}
+	assert.Len(t, changed, 0)
+	// only the named ConfigMap is watched
+	assert.Equal(t, []string{"metadata.name=base"}, client.watchedSelectors())
+	client.Lock()
+	assert.Empty(t, client.labelSelector)
+	client.Unlock()
+}
+
+func TestConfigMapSourceWatchEachName(t *testing.T) {
+	client := newFakeConfigMaps()
+	s := tmplctlr.ConfigMapSource{Kind: "ConfigMap", Names: []string{"base", "partials"}, Client: client}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	assert.Nil(t, s.Watch(stopCh, func() {}))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(client.watchedSelectors()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"metadata.name=base", "metadata.name=partials"}, client.watchedSelectors())
+}
@@ -15,12 +15,20 @@
 package tmplctlr
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
-	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
 	"github.com/wpengine/lostromos/metrics"
+	"github.com/wpengine/lostromos/target"
 	"github.com/wpengine/lostromos/tmpl"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -29,23 +37,173 @@ import (
 // Controller implements a valid crwatcher.ResourceController that will manage
 // resources in kubernetes based on the provided template files.
 type Controller struct {
-	templatePath string     //path to dir where templates are located
-	Client       KubeClient //client for talking with kubernetes
-	logger       *zap.SugaredLogger
+	Config        *Config
+	templates     *templateCache                           //parsed template sets and default values, replaced when they are reloaded
+	Client        KubeClient                               //client for talking with kubernetes
+	Resync        func()                                   //optional, called after the templates are reloaded to render all resources again
+	Reconcile     func(namespace, name string) bool        //optional, called to render a resource again when its objects drift
+	Watcher       KindWatcher                              //watches the kinds of applied objects, required to watch for drift
+	ClusterClient func(target.Cluster) (KubeClient, error) //creates the client for a target cluster from Config.Targets, defaults to kubectl
+	schemas       *manifest.Schemas                        //optional, schemas rendered objects are validated against
+	diffs         *diffCache                               //latest changes for each CR in dry run mode
+	drift         *driftDetector                           //objects applied for each CR and the kinds being watched
+	clusters      *target.Cache                            //clients for the target clusters
+	logger        *zap.SugaredLogger
 }
 
-// NewController will return a configured Controller
-func NewController(tmplDir string, kubeCfg string, logger *zap.SugaredLogger) *Controller {
+// Config provides config for a template Controller
+type Config struct {
+	TemplateDir        string             // path to dir where templates are located, used when Source is nil
+	Source             TemplateSource     // optional, where to load the templates from instead of TemplateDir
+	KubeConfig         string             // path to the kubeconfig file for kubectl, empty to use the default
+	KubeContext        string             // optional, context in the kubeconfig for kubectl instead of the current context
+	Namespace          string             // optional, namespace for rendered objects that don't set one instead of the one in the context
+	ImpersonateUser    string             // optional, user everything is sent to kubernetes as
+	ImpersonateSA      string             // optional, name of a service account in the namespace of each CR that its objects are sent as
+	Strict             bool               // fail rendering when the templates reference a missing field
+	PerFile            bool               // render every template not starting with _ as its own document
+	SetsDir            string             // optional, dir with a subdir of templates for each template set, used instead of TemplateDir
+	SetAnnotation      string             // annotation on the CR with the name of its template set
+	SetField           string             // optional, dotted path of a CR field with the name of its template set, used when the annotation is missing
+	DefaultSet         string             // optional, template set used when the CR doesn't pick one
+	ValuesFile         string             // optional, yaml file with default values merged beneath the CR spec
+	NamespaceValuesDir string             // optional, dir with a <namespace>.yaml file of default values for each namespace, merged over ValuesFile
+	ManifestSchemasDir string             // optional, dir with json schemas for kubernetes kinds to validate rendered objects against
+	Ownership          manifest.Ownership // metadata added to rendered objects to tie them to the CR
+	Redactor           manifest.Redactor  // masks the data of Secrets and other sensitive fields in logs and dry run output
+	Prune              bool               // delete objects that were applied for the CR but are no longer rendered
+	PruneDryRun        bool               // only log the objects that would be pruned
+	Inventory          inventory.Store    // optional, where the manifest and objects applied for each CR are kept, required to prune
+	DryRun             bool               // only work out and log what would change, without changing anything
+	Wait               bool               // wait for applied objects to be ready before marking the CR successful
+	WaitTimeout        int64              // time in seconds to wait for applied objects to be ready
+	KubectlTimeout     int64              // optional, time in seconds a kubectl command can run before it is killed
+	KubectlSlow        int64              // optional, time in seconds a kubectl command can run before it is logged as slow
+	Rollback           bool               // apply the last successful manifest again when an apply or wait fails, requires Inventory
+	History            inventory.History  // optional, where each successful manifest is kept as a revision, requires Inventory
+	Drift              bool               // render the CR again when its objects are changed or deleted by something else, requires Ownership.Labels
+	DriftAlertOnly     bool               // only log and count drift instead of rendering the CR again
+	Targets            *target.Resolver   // optional, picks the cluster the objects of each CR are deployed into
+}
+
+// defaultSet is the name of the only template set when SetsDir isn't used
+const defaultSet = "default"
+
+// unknownSet is the set label of the render metrics for custom resources that
+// pick a set that doesn't exist, so that they can't add a series for every
+// name they pick
+const unknownSet = "unknown"
+
+// templateCache holds the parsed template sets and default values so they can
+// be swapped out on reload while events are being processed.
+type templateCache struct {
+	sync.RWMutex
+	sets     map[string]*tmpl.Templates
+	defaults *defaultValues
+}
+
+func (tc *templateCache) get(set string) (*tmpl.Templates, bool) {
+	tc.RLock()
+	defer tc.RUnlock()
+	t, ok := tc.sets[set]
+	return t, ok
+}
+
+func (tc *templateCache) names() []string {
+	tc.RLock()
+	defer tc.RUnlock()
+	names := make([]string, 0, len(tc.sets))
+	for name := range tc.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (tc *templateCache) values(namespace string) map[string]interface{} {
+	tc.RLock()
+	defer tc.RUnlock()
+	return tc.defaults.forNamespace(namespace)
+}
+
+func (tc *templateCache) set(sets map[string]*tmpl.Templates, defaults *defaultValues) {
+	tc.Lock()
+	defer tc.Unlock()
+	tc.sets = sets
+	tc.defaults = defaults
+}
+
+// NewController will return a configured Controller. The templates are parsed
+// once here, so an error is returned if they are not valid.
+func NewController(cfg *Config, logger *zap.SugaredLogger) (*Controller, error) {
 	if logger == nil {
 		// If you don't give us a logger, set logger to a nop logger
 		logger = zap.NewNop().Sugar()
 	}
+	if cfg.SetsDir != "" && cfg.Source != nil {
+		return nil, errors.New("template sets can only be loaded from a directory")
+	}
+	if cfg.Prune && cfg.Inventory == nil {
+		return nil, errors.New("an inventory store is required to prune objects")
+	}
+	if cfg.Rollback && cfg.Inventory == nil {
+		return nil, errors.New("an inventory store is required to roll back")
+	}
+	if cfg.History != nil && cfg.Inventory == nil {
+		return nil, errors.New("an inventory store is required to keep history")
+	}
+	if cfg.ImpersonateUser != "" && cfg.ImpersonateSA != "" {
+		return nil, errors.New("only one of a user or a service account can be impersonated")
+	}
+	if cfg.Drift && !cfg.Ownership.Labels {
+		return nil, errors.New("the ownership labels are required to watch for drift")
+	}
+	if cfg.Drift && cfg.Targets != nil && cfg.Targets.Field != "" {
+		return nil, errors.New("drift can't be watched with a target cluster for each custom resource")
+	}
+	if cfg.Source == nil {
+		cfg.Source = DirSource{Dir: cfg.TemplateDir}
+	}
 	c := &Controller{
-		Client:       &Kubectl{ConfigFile: kubeCfg},
-		templatePath: filepath.Join(tmplDir, "*.tmpl"),
-		logger:       logger,
+		Config:    cfg,
+		templates: &templateCache{},
+		diffs:     &diffCache{diffs: map[string]Diff{}},
+		drift:     newDriftDetector(),
+		logger:    logger,
+	}
+	c.Client = c.kubectl(&Kubectl{ConfigFile: cfg.KubeConfig, Context: cfg.KubeContext, Namespace: cfg.Namespace})
+	c.ClusterClient = c.kubectlFor
+	c.clusters = &target.Cache{
+		New: func(cl target.Cluster) (interface{}, error) {
+			return c.ClusterClient(cl)
+		},
+		RetryAfter: clusterRetryAfter,
+	}
+	if cfg.ManifestSchemasDir != "" {
+		s, err := manifest.NewSchemas(cfg.ManifestSchemasDir)
+		if err != nil {
+			return nil, err
+		}
+		c.schemas = s
+	}
+	t, err := c.loadTemplates()
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.loadValues()
+	if err != nil {
+		return nil, err
 	}
-	return c
+	c.templates.set(t, d)
+	return c, nil
+}
+
+// kubectl will give k the timeouts from the config and the logger
+func (c Controller) kubectl(k *Kubectl) *Kubectl {
+	k.Timeout = time.Duration(c.Config.KubectlTimeout) * time.Second
+	k.SlowAfter = time.Duration(c.Config.KubectlSlow) * time.Second
+	k.Logger = c.logger
+	return k
 }
 
 // ResourceAdded is called when a custom resource is created and will generate
@@ -53,9 +211,17 @@ func NewController(tmplDir string, kubeCfg string, logger *zap.SugaredLogger) *C
 func (c Controller) ResourceAdded(r *unstructured.Unstructured) {
 	metrics.TotalEvents.Inc()
 	c.logger.Infow("resource added", "resource", r.GetName())
+	if c.Config.DryRun {
+		if err := c.dryRun(r, false); err != nil {
+			c.logger.Errorw("dry run failed", "resource", r.GetName(), "error", err)
+		}
+		return
+	}
 	out, err := c.apply(r)
+	c.trackCluster(r, err)
 	if err != nil {
-		c.logger.Errorw("failed to add resource", "resource", r.GetName(), "error", err, "cmdOutput", out)
+		timeout := countTimeout("create", err)
+		c.logger.Errorw("failed to add resource", "resource", r.GetName(), "error", err, "timeout", timeout, "cmdOutput", out)
 		metrics.CreateFailures.Inc()
 		return
 	}
@@ -69,9 +235,17 @@ func (c Controller) ResourceAdded(r *unstructured.Unstructured) {
 func (c Controller) ResourceUpdated(oldR, newR *unstructured.Unstructured) {
 	metrics.TotalEvents.Inc()
 	c.logger.Infow("resource updated", "resource", newR.GetName())
+	if c.Config.DryRun {
+		if err := c.dryRun(newR, false); err != nil {
+			c.logger.Errorw("dry run failed", "resource", newR.GetName(), "error", err)
+		}
+		return
+	}
 	out, err := c.apply(newR)
+	c.trackCluster(newR, err)
 	if err != nil {
-		c.logger.Errorw("failed to update resource", "resource", newR.GetName(), "error", err, "cmdOutput", out)
+		timeout := countTimeout("update", err)
+		c.logger.Errorw("failed to update resource", "resource", newR.GetName(), "error", err, "timeout", timeout, "cmdOutput", out)
 		metrics.UpdateFailures.Inc()
 		return
 	}
@@ -79,14 +253,23 @@ func (c Controller) ResourceUpdated(oldR, newR *unstructured.Unstructured) {
 	metrics.LastSuccessfulUpdate.Set(float64(time.Now().UTC().UnixNano()) / 1000000000)
 }
 
-// ResourceDeleted is called when a custom resource is created and will generate
-// the template files and delete them from Kubernetes
+// ResourceDeleted is called when a custom resource is deleted and will delete
+// the objects in its inventory, or generate the template files and delete them
+// from Kubernetes when there isn't one
 func (c Controller) ResourceDeleted(r *unstructured.Unstructured) {
 	metrics.TotalEvents.Inc()
 	c.logger.Infow("resource deleted", "resource", r.GetName())
+	if c.Config.DryRun {
+		if err := c.dryRun(r, true); err != nil {
+			c.logger.Errorw("dry run failed", "resource", r.GetName(), "error", err)
+		}
+		return
+	}
 	out, err := c.delete(r)
+	c.trackCluster(r, err)
 	if err != nil {
-		c.logger.Errorw("failed to delete resource", "resource", r.GetName(), "error", err, "cmdOutput", out)
+		timeout := countTimeout("delete", err)
+		c.logger.Errorw("failed to delete resource", "resource", r.GetName(), "error", err, "timeout", timeout, "cmdOutput", out)
 		metrics.DeleteFailures.Inc()
 		return
 	}
@@ -95,30 +278,196 @@ func (c Controller) ResourceDeleted(r *unstructured.Unstructured) {
 	metrics.LastSuccessfulDelete.Set(float64(time.Now().UTC().UnixNano()) / 1000000000)
 }
 
+// countTimeout will count a failed event when it was caused by kubectl timing
+// out, and return true if it was
+func countTimeout(event string, err error) bool {
+	if !IsTimeout(err) {
+		return false
+	}
+	metrics.Timeouts.WithLabelValues(event).Inc()
+	return true
+}
+
+// apply will apply the rendered templates a wave at a time and, when waiting
+// is enabled, wait for them to be ready. The inventory is only updated with the applied
+// manifest, and old objects pruned, once that has succeeded. Otherwise the
+// last successful manifest is applied again when rollback is enabled.
 func (c Controller) apply(r *unstructured.Unstructured) (output string, err error) {
-	tmpFile, err := c.buildTemplate(r)
+	out, objs, err := c.render(r)
 	if err != nil {
 		return "", err
 	}
-	return c.Client.Apply(tmpFile.Name())
+	refs := manifest.Refs(objs)
+	// tracked before applying so the changes made by the apply aren't drift
+	c.trackDrift(r, objs)
+	output, err = c.applyWaves(r, out, objs)
+	if err != nil {
+		// nothing to compare with until an apply succeeds
+		c.drift.forget(r)
+	} else {
+		c.settleDrift(r, refs)
+	}
+	if c.Config.Inventory == nil {
+		return output, err
+	}
+	if err != nil {
+		c.trackFailedApply(r, refs)
+		if c.Config.Rollback {
+			c.rollback(r)
+		}
+		return output, err
+	}
+	return output, c.updateInventory(r, out, refs)
 }
 
+// delete will delete the objects in the inventory of the custom resource, or
+// the rendered templates when there isn't an inventory.
 func (c Controller) delete(r *unstructured.Unstructured) (output string, err error) {
-	tmpFile, err := c.buildTemplate(r)
+	c.drift.forget(r)
+	if c.Config.Inventory != nil {
+		inv, err := c.Config.Inventory.Get(r)
+		if err != nil {
+			return "", err
+		}
+		if inv != nil {
+			if output, err = c.deleteObjects(r, inv.Objects); err != nil {
+				return output, err
+			}
+			if c.Config.History != nil {
+				if err := c.Config.History.Delete(r); err != nil {
+					return output, err
+				}
+			}
+			return output, c.Config.Inventory.Delete(r)
+		}
+		c.logger.Infow("no inventory for resource, deleting the rendered templates", "resource", r.GetName())
+	}
+	_, objs, err := c.render(r)
 	if err != nil {
 		return "", err
 	}
-	return c.Client.Delete(tmpFile.Name())
+	return c.deleteInOrder(r, objs)
 }
 
-func (c Controller) buildTemplate(r *unstructured.Unstructured) (tmpFile *os.File, err error) {
+// render will execute the templates for the custom resource and check that
+// the result is valid before anything is sent to kubernetes. The rendered
+// objects are returned along with the yaml to apply.
+func (c Controller) render(r *unstructured.Unstructured) ([]byte, []manifest.Object, error) {
 	cr := &tmpl.CustomResource{
 		Resource: r,
 	}
-	tmpFile, err = ioutil.TempFile("", "lostromos")
+	set, err := c.templateSet(cr)
 	if err != nil {
-		return tmpFile, err
+		return nil, nil, err
+	}
+	t, ok := c.templates.get(set)
+	if !ok {
+		metrics.TemplateSetRenderFailures.WithLabelValues(unknownSet).Inc()
+		return nil, nil, fmt.Errorf("unknown template set %q, the template sets are %s", set, strings.Join(c.templates.names(), ", "))
+	}
+	spec, _ := r.Object["spec"].(map[string]interface{})
+	cr.Values = tmpl.MergeValues(c.templates.values(r.GetNamespace()), spec)
+	var (
+		buf  bytes.Buffer
+		out  []byte
+		objs []manifest.Object
+	)
+	if err = t.Execute(cr, &buf); err == nil {
+		out, objs, err = c.finish(r, buf.Bytes())
+	}
+	if err != nil {
+		metrics.TemplateSetRenderFailures.WithLabelValues(set).Inc()
+		return nil, nil, err
+	}
+	metrics.TemplateSetRenders.WithLabelValues(set).Inc()
+	return out, objs, nil
+}
+
+// finish will parse and check the rendered templates, sort them into the
+// order they are applied in, then add the ownership metadata for the custom
+// resource to the objects when it is enabled. Objects deployed into another
+// cluster don't get an ownerReference, since the custom resource isn't there
+// and the garbage collector would delete them.
+func (c Controller) finish(r *unstructured.Unstructured, rendered []byte) ([]byte, []manifest.Object, error) {
+	objs, err := manifest.Parse(rendered)
+	if err == nil {
+		err = manifest.Validate(objs, c.schemas)
+	}
+	if err != nil {
+		return nil, nil, redactErr(fmt.Errorf("invalid manifests rendered: %s", err), c.Config.Redactor.Values(objs))
+	}
+	sorted := manifest.IsSorted(objs)
+	manifest.Sort(objs)
+	ownership := c.Config.Ownership
+	if c.Config.Targets != nil && c.Config.Targets.Remote(r) {
+		ownership.OwnerReferences = false
+	}
+	if !ownership.Enabled() && sorted {
+		return rendered, objs, nil
+	}
+	ownership.Apply(objs, r)
+	out, err := manifest.Encode(objs)
+	return out, objs, err
+}
+
+// templateSet will return the name of the template set picked by the custom
+// resource, from its annotation or field, or the default set.
+func (c Controller) templateSet(cr *tmpl.CustomResource) (string, error) {
+	if c.Config.SetsDir == "" {
+		return defaultSet, nil
+	}
+	set := cr.Annotation(c.Config.SetAnnotation)
+	if set == "" && c.Config.SetField != "" {
+		set, _ = cr.GetField(strings.Split(c.Config.SetField, ".")...)
+	}
+	if set == "" {
+		set = c.Config.DefaultSet
+	}
+	if set == "" {
+		return "", fmt.Errorf("no template set selected, set the %s annotation", c.Config.SetAnnotation)
+	}
+	return set, nil
+}
+
+// sources will return where to load each template set from.
+func (c Controller) sources() (map[string]TemplateSource, error) {
+	if c.Config.SetsDir == "" {
+		return map[string]TemplateSource{defaultSet: c.Config.Source}, nil
+	}
+	entries, err := ioutil.ReadDir(c.Config.SetsDir)
+	if err != nil {
+		return nil, err
+	}
+	sources := map[string]TemplateSource{}
+	for _, e := range entries {
+		if e.IsDir() {
+			sources[e.Name()] = DirSource{Dir: filepath.Join(c.Config.SetsDir, e.Name())}
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no template sets found in %s", c.Config.SetsDir)
+	}
+	return sources, nil
+}
+
+func (c Controller) loadTemplates() (map[string]*tmpl.Templates, error) {
+	sources, err := c.sources()
+	if err != nil {
+		return nil, err
+	}
+	opts := tmpl.Options{Strict: c.Config.Strict, PerFile: c.Config.PerFile}
+	sets := make(map[string]*tmpl.Templates, len(sources))
+	for name, src := range sources {
+		files, err := src.Templates()
+		if err == nil {
+			sets[name], err = tmpl.New(files, opts)
+		}
+		if err != nil {
+			if c.Config.SetsDir == "" {
+				return nil, err
+			}
+			return nil, fmt.Errorf("template set %s: %s", name, err)
+		}
 	}
-	err = tmpl.Parse(cr, c.templatePath, tmpFile)
-	return tmpFile, err
+	return sets, nil
 }
@@ -26,6 +26,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
 	"github.com/wpengine/lostromos/metrics"
 	"github.com/wpengine/lostromos/tmplctlr"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -50,14 +51,28 @@ var (
 		// T0.tmpl is a plain template file that just invokes T1.
 		{"0_base.tmpl", `--- {{template "file1.tmpl" . }}`},
 		// T1.tmpl defines a template, T1 that invokes T2.
-		{"file1.tmpl", `name: {{ .GetField "metadata" "name"  }}-configmap`},
+		{"file1.tmpl", configMapTemplate(`{{ .GetField "metadata" "name"  }}-configmap`)},
 	}
 
 	testBadTemplates = []testFile{
-		{"base", `--- {{template "not there.tmpl" . }}`},
+		{"0_base.tmpl", `--- {{template "not there.tmpl" . }}`},
+	}
+
+	testInvalidTemplates = []testFile{
+		{"0_base.tmpl", `--- {{ .GetField "metadata" "name" }`},
+	}
+
+	testMissingFieldTemplates = []testFile{
+		{"0_base.tmpl", `image: {{ .GetField "spec" "image" }}`},
 	}
 )
 
+// configMapTemplate returns a ConfigMap manifest with the given name, since
+// only valid manifests are applied
+func configMapTemplate(name string) string {
+	return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name
+}
+
 // templateFile defines the contents of a template to be stored in a file, for testing.
 type testFile struct {
 	name     string
@@ -93,6 +108,23 @@ func getPromCounterValue(metric string) float64 {
 	return 0
 }
 
+func getPromLabeledCounterValue(metric, label, value string) float64 {
+	mf, _ := prometheus.DefaultGatherer.Gather()
+	for _, s := range mf {
+		if s.GetName() != metric {
+			continue
+		}
+		for _, m := range s.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label && l.GetValue() == value {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
 func getPromGaugeValue(metric string) float64 {
 	mf, _ := prometheus.DefaultGatherer.Gather()
 	for _, s := range mf {
@@ -181,11 +213,144 @@ func lessThan(a float64, b float64) bool {
 	return a < b
 }
 
+func TestNewControllerFailsWithInvalidTemplates(t *testing.T) {
+	dir := createTestDir(testInvalidTemplates)
+	defer os.RemoveAll(dir)
+
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
+}
+
+func TestNewControllerFailsWithoutTemplates(t *testing.T) {
+	dir := createTestDir([]testFile{})
+	defer os.RemoveAll(dir)
+
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
+}
+
+func createSetsDir(sets map[string][]testFile) string {
+	dir, err := ioutil.TempDir("", "template-sets")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for name, files := range sets {
+		setDir := createTestDir(files)
+		if err := os.Rename(setDir, filepath.Join(dir, name)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func setResource(annotations map[string]interface{}, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":        "dory",
+				"annotations": annotations,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+func TestTemplateSets(t *testing.T) {
+	dir := createSetsDir(map[string][]testFile{
+		"small": {{"0_base.tmpl", configMapTemplate("small")}},
+		"large": {{"0_base.tmpl", configMapTemplate("large")}},
+	})
+	defer os.RemoveAll(dir)
+
+	var testCases = []struct {
+		name       string
+		defaultSet string
+		resource   *unstructured.Unstructured
+		set        string
+		content    string
+	}{
+		{"annotation", "", setResource(map[string]interface{}{"lostromos/template-set": "large"}, nil), "large", configMapTemplate("large")},
+		{"field", "", setResource(nil, map[string]interface{}{"size": "small"}), "small", configMapTemplate("small")},
+		{"annotation before field", "", setResource(map[string]interface{}{"lostromos/template-set": "large"}, map[string]interface{}{"size": "small"}), "large", configMapTemplate("large")},
+		{"default", "large", setResource(nil, nil), "large", configMapTemplate("large")},
+	}
+
+	for _, tt := range testCases {
+		c, err := tmplctlr.NewController(&tmplctlr.Config{
+			SetsDir:       dir,
+			SetAnnotation: "lostromos/template-set",
+			SetField:      "spec.size",
+			DefaultSet:    tt.defaultSet,
+		}, nil)
+		assert.Nil(t, err, tt.name)
+		mockCtrl := gomock.NewController(t)
+		mockKube := NewMockKubeClient(mockCtrl)
+		c.Client = mockKube
+
+		var content string
+		renderedContent(mockKube, &content)
+		before := getPromLabeledCounterValue("templates_render_total", "set", tt.set)
+		c.ResourceAdded(tt.resource)
+		assert.Equal(t, tt.content, content, tt.name)
+		assert.Equal(t, float64(1), getPromLabeledCounterValue("templates_render_total", "set", tt.set)-before, tt.name)
+		mockCtrl.Finish()
+	}
+}
+
+func TestTemplateSetsFailForUnknownSet(t *testing.T) {
+	dir := createSetsDir(map[string][]testFile{
+		"small": {{"0_base.tmpl", configMapTemplate("small")}},
+	})
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{SetsDir: dir, SetAnnotation: "lostromos/template-set"}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	c.Client = NewMockKubeClient(mockCtrl)
+
+	ct := counterTest{
+		events:    2,
+		createErr: 2,
+	}
+	before := getPromLabeledCounterValue("templates_render_error_total", "set", "unknown")
+	assertMetrics(t, ct, func() {
+		c.ResourceAdded(setResource(map[string]interface{}{"lostromos/template-set": "huge"}, nil))
+		// without a default set the CR has to pick one
+		c.ResourceAdded(setResource(nil, nil))
+	}, timestampTestMap())
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("templates_render_error_total", "set", "unknown")-before)
+	assert.Equal(t, float64(0), getPromLabeledCounterValue("templates_render_error_total", "set", "huge"))
+}
+
+func TestNewControllerFailsWithInvalidTemplateSet(t *testing.T) {
+	dir := createSetsDir(map[string][]testFile{
+		"small": {{"0_base.tmpl", configMapTemplate("small")}},
+		"large": testInvalidTemplates,
+	})
+	defer os.RemoveAll(dir)
+
+	c, err := tmplctlr.NewController(&tmplctlr.Config{SetsDir: dir}, nil)
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
+	if err != nil {
+		assert.Contains(t, err.Error(), "template set large: ")
+	}
+
+	empty := createSetsDir(nil)
+	defer os.RemoveAll(empty)
+	c, err = tmplctlr.NewController(&tmplctlr.Config{SetsDir: empty}, nil)
+	assert.Nil(t, c)
+	assert.EqualError(t, err, "no template sets found in "+empty)
+}
+
 func TestResourceAddedHappyPath(t *testing.T) {
 	dir := createTestDir(testTemplates)
 	// Clean up after the test; another quirk of running as an example.
 	defer os.RemoveAll(dir)
-	c := tmplctlr.NewController(dir, "", nil)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	mockKube := NewMockKubeClient(mockCtrl)
@@ -208,7 +373,8 @@ func TestResourceAddedApplyFails(t *testing.T) {
 	dir := createTestDir(testTemplates)
 	// Clean up after the test; another quirk of running as an example.
 	defer os.RemoveAll(dir)
-	c := tmplctlr.NewController(dir, "", nil)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	mockKube := NewMockKubeClient(mockCtrl)
@@ -229,7 +395,27 @@ func TestResourceAddedTemplatingFails(t *testing.T) {
 	dir := createTestDir(testBadTemplates)
 	// Clean up after the test; another quirk of running as an example.
 	defer os.RemoveAll(dir)
-	c := tmplctlr.NewController(dir, "", nil)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	ct := counterTest{
+		events:    1,
+		createErr: 1,
+	}
+	tsExpected := timestampTestMap()
+
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, tsExpected)
+}
+
+func TestResourceAddedStrictTemplatingFails(t *testing.T) {
+	dir := createTestDir(testMissingFieldTemplates)
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Strict: true}, nil)
+	assert.Nil(t, err)
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	mockKube := NewMockKubeClient(mockCtrl)
@@ -248,7 +434,8 @@ func TestResourceDeletedHappyPath(t *testing.T) {
 	dir := createTestDir(testTemplates)
 	// Clean up after the test; another quirk of running as an example.
 	defer os.RemoveAll(dir)
-	c := tmplctlr.NewController(dir, "", nil)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	mockKube := NewMockKubeClient(mockCtrl)
@@ -271,7 +458,8 @@ func TestResourceDeletedApplyFails(t *testing.T) {
 	dir := createTestDir(testTemplates)
 	// Clean up after the test; another quirk of running as an example.
 	defer os.RemoveAll(dir)
-	c := tmplctlr.NewController(dir, "", nil)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	mockKube := NewMockKubeClient(mockCtrl)
@@ -292,7 +480,8 @@ func TestResourceDeletedTemplatingFails(t *testing.T) {
 	dir := createTestDir(testBadTemplates)
 	// Clean up after the test; another quirk of running as an example.
 	defer os.RemoveAll(dir)
-	c := tmplctlr.NewController(dir, "", nil)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	mockKube := NewMockKubeClient(mockCtrl)
@@ -311,7 +500,8 @@ func TestResourceUpdatedHappyPath(t *testing.T) {
 	dir := createTestDir(testTemplates)
 	// Clean up after the test; another quirk of running as an example.
 	defer os.RemoveAll(dir)
-	c := tmplctlr.NewController(dir, "", nil)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	mockKube := NewMockKubeClient(mockCtrl)
@@ -333,7 +523,8 @@ func TestResourceUpdatedApplyFails(t *testing.T) {
 	dir := createTestDir(testTemplates)
 	// Clean up after the test; another quirk of running as an example.
 	defer os.RemoveAll(dir)
-	c := tmplctlr.NewController(dir, "", nil)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 	mockKube := NewMockKubeClient(mockCtrl)
@@ -349,3 +540,105 @@ func TestResourceUpdatedApplyFails(t *testing.T) {
 
 	assertMetrics(t, ct, func() { c.ResourceUpdated(testResource, testResource) }, tsExpected)
 }
+
+func TestResourceAddedInvalidManifestFails(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		files  []testFile
+		config tmplctlr.Config
+	}{
+		{"missing kind", []testFile{{"0_base.tmpl", "name: dory"}}, tmplctlr.Config{}},
+		{"invalid yaml", []testFile{{"0_base.tmpl", configMapTemplate("[dory")}}, tmplctlr.Config{}},
+		{"duplicate", []testFile{{"0_base.tmpl", configMapTemplate("dory") + "\n---\n" + configMapTemplate("dory")}}, tmplctlr.Config{}},
+		{"schema", []testFile{{"0_base.tmpl", "apiVersion: apps/v1beta1\nkind: Deployment\nmetadata:\n  name: dory\nspec:\n  replicas: one"}}, tmplctlr.Config{ManifestSchemasDir: "../test/data/schemas"}},
+	}
+
+	for _, tt := range testCases {
+		dir := createTestDir(tt.files)
+		defer os.RemoveAll(dir)
+		cfg := tt.config
+		cfg.TemplateDir = dir
+		c, err := tmplctlr.NewController(&cfg, nil)
+		assert.Nil(t, err, tt.name)
+		mockCtrl := gomock.NewController(t)
+		c.Client = NewMockKubeClient(mockCtrl)
+
+		ct := counterTest{
+			events:    1,
+			createErr: 1,
+		}
+		assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, timestampTestMap())
+		mockCtrl.Finish()
+	}
+}
+
+func TestNewControllerFailsWithMissingManifestSchemas(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, ManifestSchemasDir: "/path/not/found"}, nil)
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
+}
+
+func TestResourceAddedWithOwnership(t *testing.T) {
+	dir := createTestDir([]testFile{{"0_base.tmpl", configMapTemplate("{{ .Name }}\n  namespace: {{ .Namespace }}")}})
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{
+		TemplateDir: dir,
+		Ownership:   manifest.Ownership{Labels: true, OwnerReferences: true},
+	}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	r := testResource.DeepCopy()
+	r.SetAPIVersion("stable.nicolerenee.io/v1")
+	r.SetKind("Character")
+	r.SetNamespace("ocean")
+	r.SetUID("1234")
+	var content string
+	renderedContent(mockKube, &content)
+	c.ResourceAdded(r)
+
+	assert.Equal(t, `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    app.kubernetes.io/managed-by: lostromos
+    lostromos.wpengine.io/cr-name: dory
+    lostromos.wpengine.io/cr-namespace: ocean
+    lostromos.wpengine.io/cr-uid: "1234"
+  name: dory
+  namespace: ocean
+  ownerReferences:
+  - apiVersion: stable.nicolerenee.io/v1
+    controller: true
+    kind: Character
+    name: dory
+    uid: "1234"
+`, content)
+}
+
+func TestResourceAddedWaitFails(t *testing.T) {
+	dir := createTestDir([]testFile{{"0_base.tmpl", "apiVersion: apps/v1beta1\nkind: Deployment\nmetadata:\n  name: dory"}})
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Wait: true}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	mockKube.EXPECT().Get(gomock.Any()).Return(`{"apiVersion": "apps/v1beta1", "kind": "Deployment", "metadata": {"name": "dory"}, "status": {}}`, nil)
+
+	ct := counterTest{
+		events:    1,
+		createErr: 1,
+	}
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, timestampTestMap())
+}
@@ -0,0 +1,289 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The ways an object can drift
+const (
+	DriftChanged = "changed"
+	DriftDeleted = "deleted"
+)
+
+// KindWatcher will return a ListerWatcher for the objects of a kind with the
+// managed-by label, in every namespace
+type KindWatcher func(apiVersion, kind string) (cache.ListerWatcher, error)
+
+// NewKindWatcher will return a KindWatcher for the cluster, which finds the
+// resource for each kind with discovery.
+func NewKindWatcher(kubeCfg *restclient.Config) (KindWatcher, error) {
+	discovery, err := NewAPIClient(kubeCfg, "")
+	if err != nil {
+		return nil, err
+	}
+	pool := dynamic.NewDynamicClientPool(kubeCfg)
+	selector := manifest.ManagedByLabel + "=" + manifest.ManagedBy
+	return func(apiVersion, kind string) (cache.ListerWatcher, error) {
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			return nil, err
+		}
+		res, err := discovery.resource(gv, kind)
+		if err != nil {
+			return nil, err
+		}
+		client, err := pool.ClientForGroupVersionKind(gv.WithKind(kind))
+		if err != nil {
+			return nil, err
+		}
+		ri := client.Resource(&res, metav1.NamespaceAll)
+		return &cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				opts.LabelSelector = selector
+				return ri.List(opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				opts.LabelSelector = selector
+				return ri.Watch(opts)
+			},
+		}, nil
+	}, nil
+}
+
+// trackedObject is an object as it was last applied for a custom resource
+type trackedObject struct {
+	namespace string // namespace of the custom resource
+	name      string // name of the custom resource
+	object    map[string]interface{}
+	applied   bool // the apply has finished and object has the values the API server stored
+}
+
+// driftDetector keeps the objects that were applied for each custom resource,
+// and which kinds are being watched for changes to them.
+type driftDetector struct {
+	sync.Mutex
+	objects  map[string]trackedObject // by manifest.Key
+	owned    map[string][]string      // keys of the objects of each custom resource
+	watching map[string]bool          // apiVersion and kind of the watched kinds
+	stopCh   <-chan struct{}          // set once watching has started
+}
+
+func newDriftDetector() *driftDetector {
+	return &driftDetector{
+		objects:  map[string]trackedObject{},
+		owned:    map[string][]string{},
+		watching: map[string]bool{},
+	}
+}
+
+// track will replace the objects of the custom resource, and return the kinds
+// that need to be watched. No kinds are returned before watching has started.
+func (d *driftDetector) track(r *unstructured.Unstructured, objs []manifest.Object) []schema.GroupVersionKind {
+	d.Lock()
+	defer d.Unlock()
+	d.forgetLocked(r)
+	owner := manifest.Source(r)
+	var kinds []schema.GroupVersionKind
+	for _, obj := range objs {
+		key := manifest.Key(obj)
+		d.objects[key] = trackedObject{namespace: r.GetNamespace(), name: r.GetName(), object: obj.Object}
+		d.owned[owner] = append(d.owned[owner], key)
+		gvk := obj.GroupVersionKind()
+		if id := gvk.GroupVersion().String() + "/" + gvk.Kind; !d.watching[id] && d.stopCh != nil {
+			d.watching[id] = true
+			kinds = append(kinds, gvk)
+		}
+	}
+	return kinds
+}
+
+// forget will stop tracking the objects of the custom resource
+func (d *driftDetector) forget(r *unstructured.Unstructured) {
+	d.Lock()
+	defer d.Unlock()
+	d.forgetLocked(r)
+}
+
+func (d *driftDetector) forgetLocked(r *unstructured.Unstructured) {
+	owner := manifest.Source(r)
+	for _, key := range d.owned[owner] {
+		delete(d.objects, key)
+	}
+	delete(d.owned, owner)
+}
+
+// unwatch will allow the kind to be watched again after watching it failed
+func (d *driftDetector) unwatch(gvk schema.GroupVersionKind) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.watching, gvk.GroupVersion().String()+"/"+gvk.Kind)
+}
+
+// start will return the kinds of the tracked objects, which should all be
+// watched from now on until stopCh is closed.
+func (d *driftDetector) start(stopCh <-chan struct{}) []schema.GroupVersionKind {
+	d.Lock()
+	defer d.Unlock()
+	d.stopCh = stopCh
+	var kinds []schema.GroupVersionKind
+	for _, t := range d.objects {
+		gvk := (&unstructured.Unstructured{Object: t.object}).GroupVersionKind()
+		if id := gvk.GroupVersion().String() + "/" + gvk.Kind; !d.watching[id] {
+			d.watching[id] = true
+			kinds = append(kinds, gvk)
+		}
+	}
+	return kinds
+}
+
+// settle will replace the tracked objects of the custom resource with the
+// rendered fields as they are in the live objects, now that they have been
+// applied. Objects that weren't found keep the rendered values.
+func (d *driftDetector) settle(r *unstructured.Unstructured, live map[string]*unstructured.Unstructured) {
+	d.Lock()
+	defer d.Unlock()
+	for _, key := range d.owned[manifest.Source(r)] {
+		t := d.objects[key]
+		if l, ok := live[key]; ok {
+			t.object = manifest.AsApplied(t.object, l.Object)
+		}
+		t.applied = true
+		d.objects[key] = t
+	}
+}
+
+// lookup will return the tracked object for a live object. Objects rendered
+// without a namespace are in the namespace of the kubeconfig when live.
+func (d *driftDetector) lookup(live *unstructured.Unstructured) (string, trackedObject, bool) {
+	d.Lock()
+	defer d.Unlock()
+	obj := manifest.Object{Unstructured: live}
+	key := manifest.Key(obj)
+	if t, ok := d.objects[key]; ok {
+		return key, t, true
+	}
+	ref := manifest.RefFor(live)
+	ref.Namespace = ""
+	key = ref.Key()
+	t, ok := d.objects[key]
+	return key, t, ok
+}
+
+// WatchDrift will watch the kinds of the objects that are applied until stopCh
+// is closed. When one of the objects is changed or deleted by something else
+// the custom resource it was rendered for is reconciled again, or only logged
+// in alert only mode. The objects need the ownership labels to be watched.
+func (c Controller) WatchDrift(stopCh <-chan struct{}) error {
+	if c.Watcher == nil {
+		return errors.New("a kind watcher is required to watch for drift")
+	}
+	for _, gvk := range c.drift.start(stopCh) {
+		c.watchKind(gvk)
+	}
+	return nil
+}
+
+// trackDrift will remember the objects that are about to be applied for the
+// custom resource, so that changes to them are noticed once the apply has
+// finished.
+func (c Controller) trackDrift(r *unstructured.Unstructured, objs []manifest.Object) {
+	if !c.Config.Drift {
+		return
+	}
+	for _, gvk := range c.drift.track(r, objs) {
+		c.watchKind(gvk)
+	}
+}
+
+// settleDrift will get the objects that were applied for the custom resource,
+// so that they are compared with the values the API server stored rather than
+// the rendered ones, which it may have normalised or defaulted.
+func (c Controller) settleDrift(r *unstructured.Unstructured, refs []manifest.Ref) {
+	if !c.Config.Drift {
+		return
+	}
+	live, err := c.liveByKey(r, refs)
+	if err != nil {
+		c.logger.Warnw("failed to get the applied objects, drift is compared with the rendered objects", "resource", r.GetName(), "error", err)
+	}
+	c.drift.settle(r, live)
+}
+
+func (c Controller) watchKind(gvk schema.GroupVersionKind) {
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	lw, err := c.Watcher(apiVersion, kind)
+	if err != nil {
+		c.drift.unwatch(gvk)
+		c.logger.Errorw("failed to watch for drift", "kind", kind, "apiVersion", apiVersion, "error", err)
+		return
+	}
+	_, informer := cache.NewInformer(lw, &unstructured.Unstructured{}, 0, cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if live, ok := newObj.(*unstructured.Unstructured); ok {
+				c.checkDrift(live, false)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if live, ok := obj.(*unstructured.Unstructured); ok {
+				c.checkDrift(live, true)
+			}
+		},
+	})
+	c.logger.Infow("watching for drift", "kind", kind, "apiVersion", apiVersion)
+	go informer.Run(c.drift.stopCh)
+}
+
+// checkDrift will reconcile the custom resource of a tracked object when the
+// fields that were rendered no longer match, or it was deleted.
+func (c Controller) checkDrift(live *unstructured.Unstructured, deleted bool) {
+	key, tracked, ok := c.drift.lookup(live)
+	if !ok || !tracked.applied {
+		return
+	}
+	event := DriftDeleted
+	var changes []string
+	if !deleted {
+		event = DriftChanged
+		if changes = c.Config.Redactor.Changes(tracked.object, live.Object); len(changes) == 0 {
+			return
+		}
+	}
+	metrics.DriftDetected.WithLabelValues(live.GetKind()).Inc()
+	if c.Config.DriftAlertOnly || c.Reconcile == nil {
+		c.logger.Warnw("object drifted", "resource", tracked.name, "object", key, "event", event, "changes", changes)
+		return
+	}
+	c.logger.Infow("object drifted, reconciling the resource", "resource", tracked.name, "object", key, "event", event, "changes", changes)
+	if !c.Reconcile(tracked.namespace, tracked.name) {
+		c.logger.Errorw("failed to reconcile drifted object, the resource wasn't found", "resource", tracked.name, "object", key)
+	}
+}
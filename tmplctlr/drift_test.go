@@ -0,0 +1,202 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/tmplctlr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// driftTemplate and driftLive are the ConfigMap the drift tests apply, and
+// how it is returned by the API server
+var (
+	driftTemplate = configMapTemplate("dory-configmap") + "\ndata:\n  by: Disney"
+	driftLive     = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "dory-configmap", "namespace": "default", "resourceVersion": "2"},
+		"data": {"by": "Disney"}}`
+)
+
+// newDriftController will return a Controller for the template watching for
+// drift with a fake watch, and a channel with the resources it reconciles
+func newDriftController(t *testing.T, alertOnly bool, tmpl string) (*tmplctlr.Controller, *MockKubeClient, *watch.FakeWatcher, chan string, func()) {
+	dir := createTestDir([]testFile{{"0_base.tmpl", tmpl}})
+	c, err := tmplctlr.NewController(&tmplctlr.Config{
+		TemplateDir:    dir,
+		Ownership:      manifest.Ownership{Labels: true},
+		Drift:          true,
+		DriftAlertOnly: alertOnly,
+	}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	fw := watch.NewFake()
+	c.Watcher = func(apiVersion, kind string) (cache.ListerWatcher, error) {
+		assert.Equal(t, "v1", apiVersion)
+		assert.Contains(t, tmpl, "kind: "+kind)
+		return &cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				list := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+				list.SetResourceVersion("1")
+				return list, nil
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				return fw, nil
+			},
+		}, nil
+	}
+	reconciled := make(chan string, 10)
+	c.Reconcile = func(namespace, name string) bool {
+		reconciled <- namespace + "/" + name
+		return true
+	}
+	stopCh := make(chan struct{})
+	assert.Nil(t, c.WatchDrift(stopCh))
+	return c, mockKube, fw, reconciled, func() {
+		close(stopCh)
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	}
+}
+
+// applyForDrift will add the test resource, with the API server returning
+// live for the applied object, and return it
+func applyForDrift(t *testing.T, c *tmplctlr.Controller, mockKube *MockKubeClient, live string) *unstructured.Unstructured {
+	mockKube.EXPECT().Apply(gomock.Any())
+	mockKube.EXPECT().Get(gomock.Any()).Return(live, nil)
+	c.ResourceAdded(testResource)
+	obj := &unstructured.Unstructured{}
+	assert.Nil(t, obj.UnmarshalJSON([]byte(live)))
+	return obj
+}
+
+func expectReconciled(t *testing.T, reconciled chan string, expected string) {
+	select {
+	case r := <-reconciled:
+		assert.Equal(t, expected, r)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resource wasn't reconciled")
+	}
+}
+
+func TestDriftReconcilesResource(t *testing.T) {
+	c, mockKube, fw, reconciled, cleanup := newDriftController(t, false, driftTemplate)
+	defer cleanup()
+
+	live := applyForDrift(t, c, mockKube, driftLive)
+	fw.Add(live)
+	// a status or defaulted field isn't drift
+	updated := live.DeepCopy()
+	updated.Object["status"] = map[string]interface{}{"ready": true}
+	fw.Modify(updated)
+	changed := updated.DeepCopy()
+	changed.Object["data"] = map[string]interface{}{"by": "Pixar"}
+	fw.Modify(changed)
+	expectReconciled(t, reconciled, "/dory")
+
+	before := getPromLabeledCounterValue("releases_drift_total", "kind", "ConfigMap")
+	fw.Delete(changed)
+	expectReconciled(t, reconciled, "/dory")
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("releases_drift_total", "kind", "ConfigMap")-before)
+	assert.Empty(t, reconciled)
+}
+
+func TestDriftComparesWithAppliedValues(t *testing.T) {
+	tmpl := `apiVersion: v1
+kind: LimitRange
+metadata:
+  name: dory-limits
+spec:
+  limits:
+  - type: Container
+    default:
+      cpu: 0.5`
+	c, mockKube, fw, reconciled, cleanup := newDriftController(t, false, tmpl)
+	defer cleanup()
+
+	// the API server stores the cpu as 500m and defaults the request
+	live := applyForDrift(t, c, mockKube, `{"apiVersion": "v1", "kind": "LimitRange",
+		"metadata": {"name": "dory-limits", "namespace": "default", "resourceVersion": "2"},
+		"spec": {"limits": [{"type": "Container", "default": {"cpu": "500m"}, "defaultRequest": {"cpu": "500m"}}]}}`)
+	fw.Add(live)
+	updated := live.DeepCopy()
+	updated.SetResourceVersion("3")
+	fw.Modify(updated)
+	changed := updated.DeepCopy()
+	changed.Object["spec"] = map[string]interface{}{"limits": []interface{}{map[string]interface{}{
+		"type": "Container", "default": map[string]interface{}{"cpu": "1"},
+	}}}
+	fw.Modify(changed)
+	// only the change to the limits is drift
+	expectReconciled(t, reconciled, "/dory")
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, reconciled)
+}
+
+func TestDriftAlertOnly(t *testing.T) {
+	c, mockKube, fw, reconciled, cleanup := newDriftController(t, true, driftTemplate)
+	defer cleanup()
+
+	live := applyForDrift(t, c, mockKube, driftLive)
+	before := getPromLabeledCounterValue("releases_drift_total", "kind", "ConfigMap")
+	fw.Add(live)
+	fw.Delete(live)
+	deadline := time.Now().Add(5 * time.Second)
+	for getPromLabeledCounterValue("releases_drift_total", "kind", "ConfigMap") == before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("releases_drift_total", "kind", "ConfigMap")-before)
+	assert.Empty(t, reconciled)
+}
+
+func TestDriftIgnoresDeletedResources(t *testing.T) {
+	c, mockKube, fw, reconciled, cleanup := newDriftController(t, false, driftTemplate)
+	defer cleanup()
+
+	live := applyForDrift(t, c, mockKube, driftLive)
+	fw.Add(live)
+	mockKube.EXPECT().Delete(gomock.Any())
+	c.ResourceDeleted(testResource)
+	fw.Delete(live)
+	// give the informer time to handle the delete
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, reconciled)
+}
+
+func TestNewControllerDriftRequiresLabels(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	_, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Drift: true}, nil)
+	assert.EqualError(t, err, "the ownership labels are required to watch for drift")
+}
+
+func TestWatchDriftRequiresWatcher(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Drift: true, Ownership: manifest.Ownership{Labels: true}}, nil)
+	assert.Nil(t, err)
+	assert.EqualError(t, c.WatchDrift(make(chan struct{})), "a kind watcher is required to watch for drift")
+}
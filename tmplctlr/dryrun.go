@@ -0,0 +1,225 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/metrics"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The actions in an ObjectDiff
+const (
+	DiffCreate = "create"
+	DiffUpdate = "update"
+	DiffPrune  = "prune"
+	DiffDelete = "delete"
+)
+
+// ObjectDiff is what would be done to a single object in dry run mode
+type ObjectDiff struct {
+	Object  manifest.Ref `json:"object"`
+	Action  string       `json:"action"`
+	Changes []string     `json:"changes,omitempty"` // the fields that would change in an update
+}
+
+// Diff is every change that would be made for a custom resource in dry run
+// mode
+type Diff struct {
+	Resource string       `json:"resource"` // kind/namespace/name of the custom resource
+	Objects  []ObjectDiff `json:"objects"`
+}
+
+// String will describe the changes like a diff, with + for objects that would
+// be created, ~ for updates and - for deletes.
+func (d Diff) String() string {
+	var lines []string
+	for _, o := range d.Objects {
+		switch o.Action {
+		case DiffCreate:
+			lines = append(lines, "+ "+o.Object.Key())
+		case DiffUpdate:
+			lines = append(lines, "~ "+o.Object.Key())
+			for _, change := range o.Changes {
+				lines = append(lines, "    "+change)
+			}
+		case DiffPrune:
+			lines = append(lines, "- "+o.Object.Key()+" (prune)")
+		default:
+			lines = append(lines, "- "+o.Object.Key())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// diffCache holds the latest Diff for each custom resource
+type diffCache struct {
+	sync.Mutex
+	diffs map[string]Diff
+}
+
+func (dc *diffCache) set(d Diff) {
+	dc.Lock()
+	defer dc.Unlock()
+	dc.diffs[d.Resource] = d
+	pending := 0
+	for _, d := range dc.diffs {
+		if len(d.Objects) > 0 {
+			pending++
+		}
+	}
+	metrics.PendingChanges.Set(float64(pending))
+}
+
+func (dc *diffCache) list() []Diff {
+	dc.Lock()
+	defer dc.Unlock()
+	diffs := make([]Diff, 0, len(dc.diffs))
+	for _, d := range dc.diffs {
+		diffs = append(diffs, d)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Resource < diffs[j].Resource })
+	return diffs
+}
+
+// Diffs will return the latest Diff for every custom resource seen in dry
+// run mode, including the ones without changes
+func (c Controller) Diffs() []Diff {
+	return c.diffs.list()
+}
+
+// DiffHandler will serve the Diffs as json
+func (c Controller) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.Diffs()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// dryRun will work out what an apply of the custom resource would change, or
+// what would be deleted when deleted is true, without changing anything.
+func (c Controller) dryRun(r *unstructured.Unstructured, deleted bool) error {
+	var (
+		d   Diff
+		err error
+	)
+	if deleted {
+		d, err = c.diffDelete(r)
+	} else {
+		d, err = c.diffApply(r)
+	}
+	if err != nil {
+		return err
+	}
+	c.diffs.set(d)
+	if len(d.Objects) == 0 {
+		c.logger.Infow("dry run, no changes", "resource", r.GetName())
+		return nil
+	}
+	c.logger.Infow("dry run, changes not applied", "resource", r.GetName(), "objects", len(d.Objects), "diff", d.String())
+	return nil
+}
+
+func (c Controller) diffApply(r *unstructured.Unstructured) (Diff, error) {
+	d := Diff{Resource: manifest.Source(r)}
+	_, objs, err := c.render(r)
+	if err != nil {
+		return d, err
+	}
+	refs := manifest.Refs(objs)
+	live, err := c.liveByKey(r, refs)
+	if err != nil {
+		return d, err
+	}
+	for i, obj := range objs {
+		l, ok := live[refs[i].Key()]
+		if !ok {
+			d.Objects = append(d.Objects, ObjectDiff{Object: refs[i], Action: DiffCreate})
+			continue
+		}
+		if changes := c.Config.Redactor.Changes(obj.Object, l.Object); len(changes) > 0 {
+			d.Objects = append(d.Objects, ObjectDiff{Object: refs[i], Action: DiffUpdate, Changes: changes})
+		}
+	}
+	if !c.Config.Prune {
+		return d, nil
+	}
+	inv, err := c.Config.Inventory.Get(r)
+	if err != nil || inv == nil {
+		return d, err
+	}
+	stale := manifest.Missing(inv.Objects, refs)
+	if len(stale) == 0 {
+		return d, nil
+	}
+	existing, err := c.getLive(r, stale)
+	if err != nil {
+		return d, err
+	}
+	for _, obj := range existing {
+		if obj.GetAnnotations()[PruneAnnotation] != PruneDisabled {
+			d.Objects = append(d.Objects, ObjectDiff{Object: match(stale, obj), Action: DiffPrune})
+		}
+	}
+	return d, nil
+}
+
+func (c Controller) diffDelete(r *unstructured.Unstructured) (Diff, error) {
+	d := Diff{Resource: manifest.Source(r)}
+	var refs []manifest.Ref
+	if c.Config.Inventory != nil {
+		inv, err := c.Config.Inventory.Get(r)
+		if err != nil {
+			return d, err
+		}
+		if inv != nil {
+			refs = inv.Objects
+		}
+	}
+	if refs == nil {
+		_, objs, err := c.render(r)
+		if err != nil {
+			return d, err
+		}
+		refs = manifest.Refs(objs)
+	}
+	existing, err := c.getLive(r, refs)
+	if err != nil {
+		return d, err
+	}
+	for _, obj := range existing {
+		d.Objects = append(d.Objects, ObjectDiff{Object: match(refs, obj), Action: DiffDelete})
+	}
+	return d, nil
+}
+
+// liveByKey will return the objects that exist keyed by the Key of their ref
+func (c Controller) liveByKey(r *unstructured.Unstructured, refs []manifest.Ref) (map[string]*unstructured.Unstructured, error) {
+	existing, err := c.getLive(r, refs)
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]*unstructured.Unstructured, len(existing))
+	for _, obj := range existing {
+		live[match(refs, obj).Key()] = obj
+	}
+	return live, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/tmplctlr"
+)
+
+var dryRunTemplates = []testFile{
+	{"0_base.tmpl", configMapTemplate("{{ .Name }}-configmap") + "\ndata:\n  by: {{ .GetField \"spec\" \"By\" }}"},
+}
+
+const liveConfigMap = `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "dory-configmap", "namespace": "default", "uid": "1"}, "data": {"by": "%s"}}`
+
+func newDryRunController(t *testing.T, cfg tmplctlr.Config) (*tmplctlr.Controller, *MockKubeClient, func()) {
+	dir := createTestDir(dryRunTemplates)
+	cfg.TemplateDir = dir
+	cfg.DryRun = true
+	c, err := tmplctlr.NewController(&cfg, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	// only Get is expected, anything that changes the cluster fails the test
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+	return c, mockKube, func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDryRunCreate(t *testing.T) {
+	c, mockKube, cleanup := newDryRunController(t, tmplctlr.Config{})
+	defer cleanup()
+
+	mockKube.EXPECT().Get(gomock.Any()).Return("", nil)
+	ct := counterTest{events: 1}
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, timestampTestMap())
+
+	diffs := c.Diffs()
+	assert.Len(t, diffs, 1)
+	assert.Equal(t, "/dory", diffs[0].Resource)
+	assert.Equal(t, "+ core/ConfigMap dory-configmap", diffs[0].String())
+	assert.Equal(t, float64(1), getPromGaugeValue("releases_pending_changes"))
+}
+
+func TestDryRunUpdate(t *testing.T) {
+	c, mockKube, cleanup := newDryRunController(t, tmplctlr.Config{})
+	defer cleanup()
+
+	mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveConfigMap, "Pixar"), nil)
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, "~ core/ConfigMap dory-configmap\n    data.by: \"Pixar\" -> \"Disney\"", c.Diffs()[0].String())
+
+	// once the live object matches there are no pending changes
+	mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveConfigMap, "Disney"), nil)
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Empty(t, c.Diffs()[0].Objects)
+	assert.Equal(t, float64(0), getPromGaugeValue("releases_pending_changes"))
+}
+
+func TestDryRunUpdateRedacted(t *testing.T) {
+	c, mockKube, cleanup := newDryRunController(t, tmplctlr.Config{Redactor: manifest.Redactor{Fields: []string{"data.by"}}})
+	defer cleanup()
+
+	mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveConfigMap, "Pixar"), nil)
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, "~ core/ConfigMap dory-configmap\n    data.by: <redacted> -> <redacted>", c.Diffs()[0].String())
+}
+
+func TestDryRunPrune(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef, staleRef}}}
+	c, mockKube, cleanup := newDryRunController(t, tmplctlr.Config{Prune: true, Inventory: inv})
+	defer cleanup()
+
+	gomock.InOrder(
+		mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveConfigMap, "Disney"), nil),
+		mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveService, ""), nil),
+	)
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, "- core/Service dory (prune)", c.Diffs()[0].String())
+	assert.Equal(t, []manifest.Ref{renderedRef, staleRef}, inv["dory"].Objects)
+}
+
+func TestDryRunDelete(t *testing.T) {
+	c, mockKube, cleanup := newDryRunController(t, tmplctlr.Config{})
+	defer cleanup()
+
+	mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveConfigMap, "Disney"), nil)
+	ct := counterTest{events: 1}
+	assertMetrics(t, ct, func() { c.ResourceDeleted(testResource) }, timestampTestMap())
+
+	assert.Equal(t, "- core/ConfigMap dory-configmap", c.Diffs()[0].String())
+}
+
+func TestDiffHandler(t *testing.T) {
+	c, mockKube, cleanup := newDryRunController(t, tmplctlr.Config{})
+	defer cleanup()
+
+	mockKube.EXPECT().Get(gomock.Any()).Return("", nil)
+	c.ResourceAdded(testResource)
+
+	w := httptest.NewRecorder()
+	c.DiffHandler(w, httptest.NewRequest("GET", "/diff", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[{"resource": "/dory", "objects": [
+		{"object": {"apiVersion": "v1", "kind": "ConfigMap", "name": "dory-configmap"}, "action": "create"}
+	]}]`, w.Body.String())
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"time"
+
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/tmpl"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// addRevision will save the manifest that was just applied to the history of
+// the custom resource. The apply has already succeeded, so a failure is only
+// logged.
+func (c Controller) addRevision(r *unstructured.Unstructured, applied []byte, number int) {
+	rev := &inventory.Revision{
+		Number:     number,
+		Manifest:   string(applied),
+		Generation: r.GetGeneration(),
+		Checksum:   c.checksum(r),
+		Time:       time.Now().UTC(),
+	}
+	if err := c.Config.History.Add(r, rev); err != nil {
+		c.logger.Errorw("failed to save revision", "resource", r.GetName(), "revision", number, "error", err)
+		return
+	}
+	c.logger.Infow("saved revision", "resource", r.GetName(), "revision", number)
+}
+
+// checksum will return the checksum of the templates the custom resource is
+// rendered with, or an empty string when its template set doesn't exist.
+func (c Controller) checksum(r *unstructured.Unstructured) string {
+	set, err := c.templateSet(&tmpl.CustomResource{Resource: r})
+	if err != nil {
+		return ""
+	}
+	t, ok := c.templates.get(set)
+	if !ok {
+		return ""
+	}
+	return t.Checksum()
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/tmplctlr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeHistory is an inventory.History that keeps the revisions in memory
+type fakeHistory map[string][]inventory.Revision
+
+func (f fakeHistory) Add(cr *unstructured.Unstructured, rev *inventory.Revision) error {
+	f[cr.GetName()] = append(f[cr.GetName()], *rev)
+	return nil
+}
+
+func (f fakeHistory) List(cr *unstructured.Unstructured) ([]inventory.Revision, error) {
+	return f[cr.GetName()], nil
+}
+
+func (f fakeHistory) Get(cr *unstructured.Unstructured, number int) (*inventory.Revision, error) {
+	for _, rev := range f[cr.GetName()] {
+		if rev.Number == number {
+			return &rev, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f fakeHistory) Delete(cr *unstructured.Unstructured) error {
+	delete(f, cr.GetName())
+	return nil
+}
+
+func TestNewControllerHistoryRequiresInventory(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	_, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, History: fakeHistory{}}, nil)
+	assert.EqualError(t, err, "an inventory store is required to keep history")
+}
+
+func TestResourceUpdatedAddsRevisions(t *testing.T) {
+	inv := fakeInventory{}
+	history := fakeHistory{}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{History: history}, inv)
+	defer cleanup()
+
+	r := testResource.DeepCopy()
+	r.SetGeneration(4)
+	mockKube.EXPECT().Apply(gomock.Any()).Times(2)
+	c.ResourceAdded(r)
+	r.SetGeneration(5)
+	c.ResourceUpdated(r, r)
+
+	revs := history["dory"]
+	assert.Len(t, revs, 2)
+	assert.Equal(t, []int{1, 2}, []int{revs[0].Number, revs[1].Number})
+	assert.Equal(t, []int64{4, 5}, []int64{revs[0].Generation, revs[1].Generation})
+	assert.Equal(t, inv["dory"].Manifest, revs[1].Manifest)
+	assert.Len(t, revs[1].Checksum, 64)
+	assert.Equal(t, revs[0].Checksum, revs[1].Checksum)
+	assert.False(t, revs[1].Time.IsZero())
+}
+
+func TestResourceUpdatedUnchangedKeepsRevision(t *testing.T) {
+	inv := fakeInventory{}
+	history := fakeHistory{}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{History: history}, inv)
+	defer cleanup()
+
+	r := testResource.DeepCopy()
+	r.SetGeneration(4)
+	mockKube.EXPECT().Apply(gomock.Any()).Times(3)
+	c.ResourceAdded(r)
+	c.ResourceUpdated(r, r)
+	c.ResourceUpdated(r, r)
+
+	assert.Len(t, history["dory"], 1)
+	assert.Equal(t, 1, inv["dory"].Revision)
+	assert.Equal(t, int64(4), inv["dory"].Generation)
+}
+
+func TestResourceDeletedRemovesHistory(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef}, Revision: 1}}
+	history := fakeHistory{"dory": {{Number: 1}}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{History: history}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Get(gomock.Any())
+	c.ResourceDeleted(testResource)
+
+	assert.Empty(t, history)
+	assert.Empty(t, inv)
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// client will return the KubeClient for the target cluster of the custom
+// resource, which acts as the impersonated user when impersonation is
+// configured. Nothing is sent as lostromos itself when the user can't be
+// impersonated.
+func (c Controller) client(r *unstructured.Unstructured) KubeClient {
+	client, err := c.clusterClient(r)
+	if err != nil {
+		return errClient{err: err}
+	}
+	user, err := c.impersonate(r)
+	if err != nil {
+		return errClient{err: err}
+	}
+	if user == "" {
+		return client
+	}
+	i, ok := client.(Impersonator)
+	if !ok {
+		return errClient{err: fmt.Errorf("the kube client can't impersonate %s", user)}
+	}
+	return i.Impersonate(user)
+}
+
+// impersonate will return the user to act as for the custom resource, or an
+// empty string when impersonation isn't configured.
+func (c Controller) impersonate(r *unstructured.Unstructured) (string, error) {
+	if c.Config.ImpersonateSA == "" {
+		return c.Config.ImpersonateUser, nil
+	}
+	if r.GetNamespace() == "" {
+		return "", fmt.Errorf("can't impersonate service account %s for cluster scoped resource %s", c.Config.ImpersonateSA, r.GetName())
+	}
+	return fmt.Sprintf("system:serviceaccount:%s:%s", r.GetNamespace(), c.Config.ImpersonateSA), nil
+}
+
+// errClient is a KubeClient that fails without sending anything
+type errClient struct {
+	err error
+}
+
+func (e errClient) Apply(file string) (string, error)  { return "", e.err }
+func (e errClient) Delete(file string) (string, error) { return "", e.err }
+func (e errClient) Get(file string) (string, error)    { return "", e.err }
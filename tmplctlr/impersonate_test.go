@@ -0,0 +1,109 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/tmplctlr"
+)
+
+// impersonatingClient is a KubeClient that records the users it impersonates
+type impersonatingClient struct {
+	tmplctlr.KubeClient
+	users []string
+}
+
+func (c *impersonatingClient) Impersonate(user string) tmplctlr.KubeClient {
+	c.users = append(c.users, user)
+	return c.KubeClient
+}
+
+func newImpersonatingController(t *testing.T, cfg tmplctlr.Config) (*tmplctlr.Controller, *MockKubeClient, *impersonatingClient, func()) {
+	dir := createTestDir(testTemplates)
+	cfg.TemplateDir = dir
+	c, err := tmplctlr.NewController(&cfg, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	mockKube := NewMockKubeClient(mockCtrl)
+	client := &impersonatingClient{KubeClient: mockKube}
+	c.Client = client
+	return c, mockKube, client, func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewControllerFailsImpersonatingUserAndServiceAccount(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	_, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, ImpersonateUser: "marlin", ImpersonateSA: "deployer"}, nil)
+	assert.EqualError(t, err, "only one of a user or a service account can be impersonated")
+}
+
+func TestResourceAddedImpersonatesUser(t *testing.T) {
+	c, mockKube, client, cleanup := newImpersonatingController(t, tmplctlr.Config{ImpersonateUser: "marlin"})
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	c.ResourceAdded(testResource)
+
+	assert.Equal(t, []string{"marlin"}, client.users)
+}
+
+func TestResourceAddedImpersonatesServiceAccountInNamespace(t *testing.T) {
+	c, mockKube, client, cleanup := newImpersonatingController(t, tmplctlr.Config{ImpersonateSA: "deployer"})
+	defer cleanup()
+	r := testResource.DeepCopy()
+	r.SetNamespace("ocean")
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	c.ResourceAdded(r)
+
+	assert.Equal(t, []string{"system:serviceaccount:ocean:deployer"}, client.users)
+}
+
+func TestResourceAddedImpersonatingServiceAccountFailsForClusterScoped(t *testing.T) {
+	c, _, client, cleanup := newImpersonatingController(t, tmplctlr.Config{ImpersonateSA: "deployer"})
+	defer cleanup()
+
+	ct := counterTest{
+		events:    1,
+		createErr: 1,
+	}
+	// nothing is applied as lostromos itself
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, timestampTestMap())
+	assert.Empty(t, client.users)
+}
+
+func TestResourceAddedImpersonatingFailsWhenClientCantImpersonate(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, ImpersonateUser: "marlin"}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	// the mock has no Impersonate so any call to it fails the test
+	c.Client = NewMockKubeClient(mockCtrl)
+
+	ct := counterTest{
+		events:    1,
+		createErr: 1,
+	}
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, timestampTestMap())
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// updateInventory will save the manifest that was just applied in the
+// inventory of the custom resource. It is the next revision when the manifest
+// or the generation of the custom resource changed, otherwise a resync that
+// changed nothing would add a revision. Objects from the previous inventory
+// that weren't applied this time are pruned when it is enabled, and the ones
+// that aren't pruned stay in the inventory so that they are deleted with the
+// custom resource. A new revision is also added to the history when it is
+// kept.
+func (c Controller) updateInventory(r *unstructured.Unstructured, applied []byte, refs []manifest.Ref) error {
+	inv, err := c.Config.Inventory.Get(r)
+	if err != nil {
+		return err
+	}
+	var (
+		kept     []manifest.Ref
+		pruneErr error
+		revision = 1
+		changed  = true
+	)
+	if inv != nil {
+		revision = inv.Revision
+		changed = inv.Manifest != string(applied) || inv.Generation != r.GetGeneration()
+		if changed {
+			revision++
+		}
+		kept = manifest.Missing(inv.Objects, refs)
+		if c.Config.Prune && len(kept) > 0 {
+			kept, pruneErr = c.prune(r, kept)
+		}
+	}
+	if err := c.Config.Inventory.Save(r, &inventory.Inventory{
+		Objects:    append(refs, kept...),
+		Manifest:   string(applied),
+		Revision:   revision,
+		Generation: r.GetGeneration(),
+	}); err != nil {
+		return err
+	}
+	if changed && c.Config.History != nil {
+		c.addRevision(r, applied, revision)
+	}
+	return pruneErr
+}
+
+// trackFailedApply will add the objects to the inventory of the custom
+// resource after a failed apply, since some of them may have been applied.
+// The manifest is left as the last one that was applied successfully.
+func (c Controller) trackFailedApply(r *unstructured.Unstructured, refs []manifest.Ref) {
+	inv, err := c.Config.Inventory.Get(r)
+	if err == nil {
+		if inv == nil {
+			inv = &inventory.Inventory{}
+		}
+		inv.Objects = append(inv.Objects, manifest.Missing(refs, inv.Objects)...)
+		err = c.Config.Inventory.Save(r, inv)
+	}
+	if err != nil {
+		c.logger.Errorw("failed to update inventory", "resource", r.GetName(), "error", err)
+	}
+}
+
+// deleteObjects will delete the objects that still exist
+func (c Controller) deleteObjects(r *unstructured.Unstructured, refs []manifest.Ref) (string, error) {
+	live, err := c.getLive(r, refs)
+	if err != nil || len(live) == 0 {
+		return "", err
+	}
+	out, err := c.deleteInOrder(r, stubs(refs, live))
+	if err != nil {
+		return out, wrapf(err, "failed to delete %d objects", len(live))
+	}
+	return out, nil
+}
+
+// stubs will return an object with only the ref and wave of each live
+// object, which is enough to delete them in order
+func stubs(refs []manifest.Ref, live []*unstructured.Unstructured) []manifest.Object {
+	objs := make([]manifest.Object, len(live))
+	for i, obj := range live {
+		objs[i] = match(refs, obj).Object()
+		if wave, ok := obj.GetAnnotations()[manifest.WaveAnnotation]; ok {
+			objs[i].SetAnnotations(map[string]string{manifest.WaveAnnotation: wave})
+		}
+	}
+	return objs
+}
+
+// withManifest will encode the objects and call fn with a file containing them
+func (c Controller) withManifest(objs []manifest.Object, fn func(file string) (string, error)) (string, error) {
+	b, err := manifest.Encode(objs)
+	if err != nil {
+		return "", err
+	}
+	return c.withFile(b, fn)
+}
+
+// withFile will write data to a temporary file and call fn with it, the file
+// is removed afterwards. Sensitive values from data are masked in the output
+// and error of fn.
+func (c Controller) withFile(data []byte, fn func(file string) (string, error)) (string, error) {
+	out, err := c.writeFile(data, fn)
+	if out == "" && err == nil {
+		return out, err
+	}
+	return c.redact(data, out, err)
+}
+
+func (c Controller) writeFile(data []byte, fn func(file string) (string, error)) (string, error) {
+	f, err := ioutil.TempFile("", "lostromos")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return fn(f.Name())
+}
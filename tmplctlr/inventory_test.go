@@ -0,0 +1,172 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/tmplctlr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeInventory is an inventory.Store that keeps the inventories in memory
+type fakeInventory map[string]*inventory.Inventory
+
+func (f fakeInventory) Get(cr *unstructured.Unstructured) (*inventory.Inventory, error) {
+	return f[cr.GetName()], nil
+}
+
+func (f fakeInventory) Save(cr *unstructured.Unstructured, inv *inventory.Inventory) error {
+	f[cr.GetName()] = inv
+	return nil
+}
+
+func (f fakeInventory) Delete(cr *unstructured.Unstructured) error {
+	delete(f, cr.GetName())
+	return nil
+}
+
+var (
+	renderedRef = manifest.Ref{APIVersion: "v1", Kind: "ConfigMap", Name: "dory-configmap"}
+	staleRef    = manifest.Ref{APIVersion: "v1", Kind: "Service", Name: "dory"}
+)
+
+func newInventoryController(t *testing.T, cfg tmplctlr.Config, inv fakeInventory) (*tmplctlr.Controller, *MockKubeClient, func()) {
+	dir := createTestDir(testTemplates)
+	cfg.TemplateDir = dir
+	cfg.Inventory = inv
+	c, err := tmplctlr.NewController(&cfg, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+	return c, mockKube, func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestResourceAddedSavesInventory(t *testing.T) {
+	inv := fakeInventory{}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	c.ResourceAdded(testResource)
+
+	assert.Equal(t, []manifest.Ref{renderedRef}, inv["dory"].Objects)
+	assert.Equal(t, "--- "+configMapTemplate("dory-configmap"), inv["dory"].Manifest)
+}
+
+func TestResourceUpdatedKeepsObjectsWithoutPrune(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef, staleRef}, Manifest: "old"}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, []manifest.Ref{renderedRef, staleRef}, inv["dory"].Objects)
+	assert.NotEqual(t, "old", inv["dory"].Manifest)
+}
+
+func TestResourceUpdatedApplyFailsTracksObjects(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{staleRef}, Manifest: "old"}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any()).Return("", errors.New("apply failed"))
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, []manifest.Ref{staleRef, renderedRef}, inv["dory"].Objects)
+	assert.Equal(t, "old", inv["dory"].Manifest)
+}
+
+func TestResourceDeletedUsesInventory(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef, staleRef}}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{}, inv)
+	defer cleanup()
+
+	// the templates no longer render the Service, it is deleted anyway
+	var deleted string
+	mockKube.EXPECT().Get(gomock.Any()).Return(`{"apiVersion": "v1", "kind": "List", "items": [
+		{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "dory-configmap", "namespace": "default"}},
+		{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "dory", "namespace": "default"}}
+	]}`, nil)
+	mockKube.EXPECT().Delete(gomock.Any()).Do(func(file string) {
+		b, _ := ioutil.ReadFile(file)
+		deleted = string(b)
+	})
+	ct := counterTest{
+		events:   1,
+		delete:   1,
+		releases: -1,
+	}
+	tsExpected := timestampTestMap()
+	tsExpected["releases_last_delete_timestamp_utc_seconds"] = greaterThan
+	assertMetrics(t, ct, func() { c.ResourceDeleted(testResource) }, tsExpected)
+
+	// deleted in the reverse of install order
+	assert.Equal(t, "---\napiVersion: v1\nkind: Service\nmetadata:\n  name: dory\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: dory-configmap\n", deleted)
+	assert.Empty(t, inv)
+}
+
+func TestResourceDeletedUsesInventoryWhenTemplatesFail(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef}}}
+	dir := createTestDir(testBadTemplates)
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Inventory: inv}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	mockKube.EXPECT().Get(gomock.Any()).Return("", nil)
+	c.ResourceDeleted(testResource)
+
+	assert.Empty(t, inv)
+}
+
+func TestResourceDeletedWithoutInventory(t *testing.T) {
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{}, fakeInventory{})
+	defer cleanup()
+
+	mockKube.EXPECT().Delete(gomock.Any())
+	c.ResourceDeleted(testResource)
+}
+
+func TestResourceDeletedFailsKeepsInventory(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef}}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Get(gomock.Any()).Return(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "dory-configmap"}}`, nil)
+	mockKube.EXPECT().Delete(gomock.Any()).Return("forbidden", errors.New("exit status 1"))
+	ct := counterTest{
+		events:    1,
+		deleteErr: 1,
+	}
+	assertMetrics(t, ct, func() { c.ResourceDeleted(testResource) }, timestampTestMap())
+
+	assert.NotNil(t, inv["dory"])
+}
@@ -15,21 +15,77 @@
 package tmplctlr
 
 import (
-	"os"
+	"bytes"
+	"fmt"
 	"os/exec"
+	"time"
+
+	"github.com/wpengine/lostromos/cli"
+	"github.com/wpengine/lostromos/metrics"
+	"go.uber.org/zap"
 )
 
-// KubeClient is an interface that implements an Apply() and Delete() for our K8s templates
+// KubeClient is an interface that implements an Apply(), Delete() and Get() for our K8s templates
 type KubeClient interface {
 	Apply(file string) (string, error)
 	Delete(file string) (string, error)
+	Get(file string) (string, error) // json of the objects in file that exist
+}
+
+// Impersonator is a KubeClient that can act as another user
+type Impersonator interface {
+	KubeClient
+	// Impersonate returns a KubeClient that sends everything as user
+	Impersonate(user string) KubeClient
 }
 
 // Kubectl provides a simple wrapper around calling the needed kubectl commands
 // TODO: This should be revisited when https://github.com/kubernetes/kubernetes/issues/15894 is completed.
 // #15894 will move the apply logic from kubectl into the API
 type Kubectl struct {
-	ConfigFile string //config file for kubectl
+	ConfigFile string             //optional, config file for kubectl instead of the default
+	Context    string             //optional, context in the config file instead of the current context
+	Namespace  string             //optional, namespace for objects that don't set one instead of the one in the context
+	As         string             //optional, user to impersonate
+	Timeout    time.Duration      //optional, how long a command can run before it is killed
+	SlowAfter  time.Duration      //optional, how long a command can run before it is logged as slow
+	Logger     *zap.SugaredLogger //optional, where timeouts and slow commands are logged
+}
+
+// TimeoutError is returned when a kubectl command is killed for running longer
+// than the timeout
+type TimeoutError = cli.TimeoutError
+
+// IsTimeout will return true if err is a TimeoutError, or wraps one
+func IsTimeout(err error) bool {
+	for err != nil {
+		if _, ok := err.(TimeoutError); ok {
+			return true
+		}
+		w, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			return false
+		}
+		err = w.Cause()
+	}
+	return false
+}
+
+// wrappedError adds context to the message of an error, keeping the error as
+// its Cause
+type wrappedError struct {
+	msg string
+	err error
+}
+
+func (e wrappedError) Error() string { return e.msg }
+func (e wrappedError) Cause() error  { return e.err }
+
+// wrapf will return err with the formatted context before its message
+func wrapf(err error, format string, args ...interface{}) error {
+	return wrappedError{msg: fmt.Sprintf(format, args...) + ": " + err.Error(), err: err}
 }
 
 var execCommand = exec.Command
@@ -44,13 +100,76 @@ func (k Kubectl) Delete(file string) (string, error) {
 	return k.kubectlExec(file, "delete")
 }
 
-// Delete will execute kubectl delete -f file with the correct config
+// Get will execute kubectl get -f file -o json with the correct config. Objects
+// that don't exist are left out rather than being an error.
+func (k Kubectl) Get(file string) (string, error) {
+	// only stdout, so that warnings don't end up in the json
+	var stdout, stderr bytes.Buffer
+	cmd := execCommand("kubectl", k.args("get", "-f", file, "-o", "json", "--ignore-not-found")...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := k.run("get", file, cmd); err != nil {
+		return stderr.String(), err
+	}
+	return stdout.String(), nil
+}
+
+// Impersonate will return a Kubectl that runs every command with --as user
+func (k Kubectl) Impersonate(user string) KubeClient {
+	k.As = user
+	return k
+}
+
+// kubectlExec will execute kubectl cmd -f file with the correct config
 func (k Kubectl) kubectlExec(file, cmd string) (string, error) {
+	var out bytes.Buffer
+	c := execCommand("kubectl", k.args(cmd, "-f", file)...)
+	c.Stdout = &out
+	c.Stderr = &out
+	err := k.run(cmd, file, c)
+	return out.String(), err
+}
+
+// run will run the kubectl command, killing it and everything it started when
+// it runs longer than the timeout so that a hung connection to the API server
+// can't stall event processing
+func (k Kubectl) run(command, file string, cmd *exec.Cmd) error {
+	start := time.Now()
+	err := cli.Run(cmd, "kubectl", command, k.Timeout)
+	if _, ok := err.(TimeoutError); ok {
+		metrics.KubectlTimeouts.WithLabelValues(command).Inc()
+		k.logger().Errorw("kubectl timed out", "command", command, "file", file, "timeout", k.Timeout)
+		return err
+	}
+	if took := time.Since(start); k.SlowAfter > 0 && took > k.SlowAfter {
+		metrics.KubectlSlow.WithLabelValues(command).Inc()
+		k.logger().Warnw("kubectl was slow", "command", command, "file", file, "took", took, "threshold", k.SlowAfter)
+	}
+	return err
+}
+
+func (k Kubectl) logger() *zap.SugaredLogger {
+	if k.Logger == nil {
+		return zap.NewNop().Sugar()
+	}
+	return k.Logger
+}
+
+// args will add the flags for the config to the kubectl arguments. They are
+// flags rather than environment variables so that concurrent commands can use
+// different configs.
+func (k Kubectl) args(args ...string) []string {
 	if k.ConfigFile != "" {
-		if err := os.Setenv("KUBECONFIG", k.ConfigFile); err != nil {
-			return "", err
-		}
+		args = append(args, "--kubeconfig", k.ConfigFile)
+	}
+	if k.Context != "" {
+		args = append(args, "--context", k.Context)
+	}
+	if k.Namespace != "" {
+		args = append(args, "--namespace", k.Namespace)
+	}
+	if k.As != "" {
+		args = append(args, "--as", k.As)
 	}
-	out, err := execCommand("kubectl", cmd, "-f", file).CombinedOutput()
-	return string(out[:]), err
+	return args
 }
@@ -15,12 +15,17 @@
 package tmplctlr
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/metrics"
 )
 
 func fakeExecCommand(command string, args ...string) *exec.Cmd {
@@ -36,8 +41,16 @@ func TestHelperProcess(t *testing.T) {
 		return
 	}
 	fmt.Print(os.Args[3:])
-	if os.Args[len(os.Args)-1] == "ERROR" {
+	for _, arg := range os.Args[3:] {
+		if arg == "HANG" {
+			time.Sleep(time.Minute)
+		}
+	}
+	switch os.Args[len(os.Args)-1] {
+	case "ERROR":
 		os.Exit(1)
+	case "SLOW":
+		time.Sleep(100 * time.Millisecond)
 	}
 	os.Exit(0)
 }
@@ -69,8 +82,29 @@ func TestKubectlApplyConfigFile(t *testing.T) {
 	k := &Kubectl{ConfigFile: "some_file"}
 	out, err := k.Apply("path")
 	assert.Nil(t, err)
-	assert.Equal(t, "some_file", os.Getenv("KUBECONFIG"))
-	assert.Equal(t, "[kubectl apply -f path]", out)
+	assert.Empty(t, os.Getenv("KUBECONFIG"))
+	assert.Equal(t, "[kubectl apply -f path --kubeconfig some_file]", out)
+}
+
+func TestKubectlApplyContextAndNamespace(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	k := &Kubectl{ConfigFile: "some_file", Context: "staging", Namespace: "ocean"}
+	out, err := k.Apply("path")
+	assert.Nil(t, err)
+	assert.Equal(t, "[kubectl apply -f path --kubeconfig some_file --context staging --namespace ocean]", out)
+}
+
+func TestKubectlImpersonate(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	k := &Kubectl{Namespace: "ocean"}
+	out, err := k.Impersonate("system:serviceaccount:ocean:deployer").Get("path")
+	assert.Nil(t, err)
+	assert.Equal(t, "[kubectl get -f path -o json --ignore-not-found --namespace ocean --as system:serviceaccount:ocean:deployer]", out)
+	assert.Empty(t, k.As)
 }
 
 func TestKubectlDelete(t *testing.T) {
@@ -90,8 +124,8 @@ func TestKubectlDeleteConfigFile(t *testing.T) {
 	k := &Kubectl{ConfigFile: "some_file"}
 	out, err := k.Delete("path")
 	assert.Nil(t, err)
-	assert.Equal(t, "some_file", os.Getenv("KUBECONFIG"))
-	assert.Equal(t, "[kubectl delete -f path]", out)
+	assert.Empty(t, os.Getenv("KUBECONFIG"))
+	assert.Equal(t, "[kubectl delete -f path --kubeconfig some_file]", out)
 }
 
 func TestKubectlDeleteCmdError(t *testing.T) {
@@ -103,3 +137,82 @@ func TestKubectlDeleteCmdError(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Equal(t, "[kubectl delete -f ERROR]", out)
 }
+
+func TestKubectlGet(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	k := &Kubectl{}
+	out, err := k.Get("path")
+	assert.Nil(t, err)
+	assert.Equal(t, "[kubectl get -f path -o json --ignore-not-found]", out)
+}
+
+func counterValue(c *prometheus.CounterVec, command string) float64 {
+	m := &dto.Metric{}
+	c.WithLabelValues(command).Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func TestKubectlTimeout(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	before := counterValue(metrics.KubectlTimeouts, "apply")
+	k := &Kubectl{Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err := k.Apply("HANG")
+	assert.True(t, time.Since(start) < 30*time.Second, "kubectl wasn't killed")
+	assert.Equal(t, TimeoutError{Program: "kubectl", Command: "apply", Timeout: 50 * time.Millisecond}, err)
+	assert.True(t, IsTimeout(err))
+	assert.EqualError(t, err, "kubectl apply timed out after 50ms")
+	assert.Equal(t, float64(1), counterValue(metrics.KubectlTimeouts, "apply")-before)
+}
+
+func TestIsTimeoutWrapped(t *testing.T) {
+	timeout := TimeoutError{Program: "kubectl", Command: "apply", Timeout: time.Minute}
+	err := wrapf(wrapf(timeout, "wave %d", 2), "failed")
+	assert.EqualError(t, err, "failed: wave 2: kubectl apply timed out after 1m0s")
+	assert.True(t, IsTimeout(err))
+	assert.False(t, IsTimeout(wrapf(errors.New("forbidden"), "wave %d", 2)))
+	assert.False(t, IsTimeout(nil))
+}
+
+func TestKubectlGetTimeout(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	k := &Kubectl{Timeout: 50 * time.Millisecond}
+	_, err := k.Get("HANG")
+	assert.True(t, IsTimeout(err))
+}
+
+func TestKubectlNoTimeout(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	before := counterValue(metrics.KubectlTimeouts, "delete")
+	k := &Kubectl{Timeout: time.Minute}
+	out, err := k.Delete("ERROR")
+	assert.NotNil(t, err)
+	assert.False(t, IsTimeout(err))
+	assert.Equal(t, "[kubectl delete -f ERROR]", out)
+	assert.Equal(t, float64(0), counterValue(metrics.KubectlTimeouts, "delete")-before)
+}
+
+func TestKubectlSlow(t *testing.T) {
+	execCommand = fakeExecCommand
+	defer func() { execCommand = exec.Command }()
+
+	before := counterValue(metrics.KubectlSlow, "apply")
+	k := &Kubectl{SlowAfter: time.Millisecond}
+	out, err := k.Apply("SLOW")
+	assert.Nil(t, err)
+	assert.Equal(t, "[kubectl apply -f SLOW]", out)
+	assert.Equal(t, float64(1), counterValue(metrics.KubectlSlow, "apply")-before)
+
+	k = &Kubectl{SlowAfter: time.Minute}
+	_, err = k.Apply("SLOW")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), counterValue(metrics.KubectlSlow, "apply")-before)
+}
@@ -57,3 +57,16 @@ func (_m *MockKubeClient) Delete(file string) (string, error) {
 func (_mr *MockKubeClientMockRecorder) Delete(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Delete", reflect.TypeOf((*MockKubeClient)(nil).Delete), arg0)
 }
+
+// Get mocks base method
+func (_m *MockKubeClient) Get(file string) (string, error) {
+	ret := _m.ctrl.Call(_m, "Get", file)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get
+func (_mr *MockKubeClientMockRecorder) Get(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Get", reflect.TypeOf((*MockKubeClient)(nil).Get), arg0)
+}
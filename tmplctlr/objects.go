@@ -0,0 +1,60 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/metrics"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// sendApply will apply the objects with the client for the custom
+// resource. An ObjectClient is sent the objects themselves and what happened
+// to each one is reported, other clients are given data in a file, or the
+// encoded objects when data is nil.
+func (c Controller) sendApply(r *unstructured.Unstructured, data []byte, objs []manifest.Object) (string, error) {
+	client := c.client(r)
+	if oc, ok := client.(ObjectClient); ok {
+		return c.report(r, objs, oc.ApplyObjects(objs))
+	}
+	if data == nil {
+		return c.withManifest(objs, client.Apply)
+	}
+	return c.withFile(data, client.Apply)
+}
+
+// sendDelete will delete the objects with the client for the custom
+// resource, like sendApply
+func (c Controller) sendDelete(r *unstructured.Unstructured, objs []manifest.Object) (string, error) {
+	client := c.client(r)
+	if oc, ok := client.(ObjectClient); ok {
+		return c.report(r, objs, oc.DeleteObjects(manifest.Refs(objs)))
+	}
+	return c.withManifest(objs, client.Delete)
+}
+
+// report will count the result for each object and log the ones that failed,
+// then return them as output and an error with the sensitive values of the
+// objects masked
+func (c Controller) report(r *unstructured.Unstructured, objs []manifest.Object, results Results) (string, error) {
+	values := c.Config.Redactor.Values(objs)
+	for _, res := range results {
+		metrics.ObjectActions.WithLabelValues(res.Action).Inc()
+		if res.Err != nil {
+			c.logger.Errorw("failed to send object", "resource", r.GetName(), "object", res.Object.Key(), "action", res.Action, "error", redactErr(res.Err, values))
+		}
+	}
+	return manifest.Mask(results.String(), values), redactErr(results.Err(), values)
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/metrics"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeObjectClient is an ObjectClient that fails the objects named in failed
+// and can't be sent files
+type fakeObjectClient struct {
+	errClient
+	failed  map[string]bool
+	applied []manifest.Ref
+	deleted []manifest.Ref
+}
+
+func (f *fakeObjectClient) ApplyObjects(objs []manifest.Object) Results {
+	var results Results
+	for _, ref := range manifest.Refs(objs) {
+		f.applied = append(f.applied, ref)
+		if f.failed[ref.Name] {
+			results = append(results, Result{Object: ref, Action: ActionFailed, Err: errors.New("password hunter22 is invalid")})
+			continue
+		}
+		results = append(results, Result{Object: ref, Action: ActionApplied})
+	}
+	return results
+}
+
+func (f *fakeObjectClient) DeleteObjects(refs []manifest.Ref) Results {
+	var results Results
+	for _, ref := range refs {
+		f.deleted = append(f.deleted, ref)
+		results = append(results, Result{Object: ref, Action: ActionNotFound})
+	}
+	return results
+}
+
+func newObjectsController(client KubeClient) Controller {
+	return Controller{Config: &Config{}, Client: client, logger: zap.NewNop().Sugar()}
+}
+
+func TestSendApplySendsObjects(t *testing.T) {
+	fake := &fakeObjectClient{errClient: errClient{err: errors.New("no files")}, failed: map[string]bool{"nemo": true}}
+	c := newObjectsController(fake)
+	objs, err := manifest.Parse([]byte(secretManifest))
+	assert.Nil(t, err)
+	applied := counterValue(metrics.ObjectActions, ActionApplied)
+	failed := counterValue(metrics.ObjectActions, ActionFailed)
+
+	out, err := c.sendApply(&unstructured.Unstructured{}, []byte(secretManifest), objs)
+	assert.Equal(t, manifest.Refs(objs), fake.applied)
+	assert.Equal(t, "core/Secret nemo failed: password <redacted> is invalid\ncore/ConfigMap nemo failed: password <redacted> is invalid", out)
+	assert.EqualError(t, err, "core/Secret nemo: password <redacted> is invalid; core/ConfigMap nemo: password <redacted> is invalid")
+	assert.Equal(t, float64(2), counterValue(metrics.ObjectActions, ActionFailed)-failed)
+	assert.Equal(t, float64(0), counterValue(metrics.ObjectActions, ActionApplied)-applied)
+}
+
+func TestSendDeleteSendsRefs(t *testing.T) {
+	fake := &fakeObjectClient{errClient: errClient{err: errors.New("no files")}}
+	c := newObjectsController(fake)
+	objs, err := manifest.Parse([]byte(secretManifest))
+	assert.Nil(t, err)
+	notFound := counterValue(metrics.ObjectActions, ActionNotFound)
+
+	out, err := c.sendDelete(&unstructured.Unstructured{}, objs)
+	assert.Nil(t, err)
+	assert.Equal(t, manifest.Refs(objs), fake.deleted)
+	assert.Equal(t, "core/Secret nemo not found\ncore/ConfigMap nemo not found", out)
+	assert.Equal(t, float64(2), counterValue(metrics.ObjectActions, ActionNotFound)-notFound)
+}
+
+func TestSendApplyWritesFileForOtherClients(t *testing.T) {
+	c := newObjectsController(errClient{err: errors.New("kubectl failed")})
+	objs, err := manifest.Parse([]byte(secretManifest))
+	assert.Nil(t, err)
+
+	_, err = c.sendApply(&unstructured.Unstructured{}, []byte(secretManifest), objs)
+	assert.EqualError(t, err, "kubectl failed")
+}
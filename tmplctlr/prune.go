@@ -0,0 +1,118 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/metrics"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// PruneAnnotation on a live object set to PruneDisabled stops it from
+	// being pruned when it is no longer rendered
+	PruneAnnotation = "lostromos.wpengine.io/prune"
+	// PruneDisabled is the value of PruneAnnotation that protects an object
+	PruneDisabled = "disabled"
+)
+
+// prune will delete the stale objects that still exist and aren't protected
+// by PruneAnnotation, and return the ones that should still be tracked. In dry
+// run mode the objects are only logged.
+func (c Controller) prune(r *unstructured.Unstructured, stale []manifest.Ref) ([]manifest.Ref, error) {
+	live, err := c.getLive(r, stale)
+	if err != nil {
+		return stale, err
+	}
+	var (
+		prunable []manifest.Ref
+		objs     []*unstructured.Unstructured
+	)
+	for _, obj := range live {
+		ref := match(stale, obj)
+		if obj.GetAnnotations()[PruneAnnotation] == PruneDisabled {
+			c.logger.Infow("not pruning protected object", "resource", r.GetName(), "object", ref.Key())
+			continue
+		}
+		prunable = append(prunable, ref)
+		objs = append(objs, obj)
+	}
+	if len(prunable) == 0 {
+		return nil, nil
+	}
+	if c.Config.PruneDryRun {
+		for _, ref := range prunable {
+			c.logger.Infow("would prune object (dry run)", "resource", r.GetName(), "object", ref.Key())
+		}
+		return prunable, nil
+	}
+	out, err := c.deleteInOrder(r, stubs(stale, objs))
+	if err != nil {
+		metrics.PruneFailures.Inc()
+		return prunable, fmt.Errorf("failed to prune %d objects: %s: %s", len(prunable), err, strings.TrimSpace(out))
+	}
+	for _, ref := range prunable {
+		c.logger.Infow("pruned object", "resource", r.GetName(), "object", ref.Key())
+	}
+	metrics.PrunedObjects.Add(float64(len(prunable)))
+	return nil, nil
+}
+
+// getLive will return the objects that still exist in Kubernetes
+func (c Controller) getLive(r *unstructured.Unstructured, refs []manifest.Ref) ([]*unstructured.Unstructured, error) {
+	out, err := c.withManifest(manifest.Objects(refs), c.client(r).Get)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get objects: %s: %s", err, strings.TrimSpace(out))
+	}
+	if strings.TrimSpace(out) == "" {
+		return nil, nil
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &obj); err != nil {
+		return nil, fmt.Errorf("failed to get objects: %s", err)
+	}
+	items, ok := obj["items"].([]interface{})
+	if !ok {
+		// a single object is returned on its own rather than in a list
+		return []*unstructured.Unstructured{{Object: obj}}, nil
+	}
+	live := make([]*unstructured.Unstructured, 0, len(items))
+	for _, item := range items {
+		if o, ok := item.(map[string]interface{}); ok {
+			live = append(live, &unstructured.Unstructured{Object: o})
+		}
+	}
+	return live, nil
+}
+
+// match will return the ref a live object was found for. The live object has
+// a namespace even when the ref left it to the kubeconfig.
+func match(refs []manifest.Ref, obj *unstructured.Unstructured) manifest.Ref {
+	live := manifest.RefFor(obj)
+	for _, ref := range refs {
+		if ref.Namespace == "" {
+			live.Namespace = ""
+		}
+		if ref.Key() == live.Key() {
+			return ref
+		}
+		live.Namespace = obj.GetNamespace()
+	}
+	return live
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/tmplctlr"
+)
+
+const liveService = `{"apiVersion": "v1", "kind": "List", "items": [
+	{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "dory", "namespace": "default"%s}}
+]}`
+
+func TestNewControllerFailsToPruneWithoutInventory(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Prune: true}, nil)
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
+}
+
+func TestResourceUpdatedPrunesObjects(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef, staleRef}}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{Prune: true}, inv)
+	defer cleanup()
+
+	var deleted string
+	gomock.InOrder(
+		mockKube.EXPECT().Apply(gomock.Any()),
+		mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveService, ""), nil),
+		mockKube.EXPECT().Delete(gomock.Any()).Do(func(file string) {
+			b, _ := ioutil.ReadFile(file)
+			deleted = string(b)
+		}),
+	)
+	ct := counterTest{
+		events: 1,
+		update: 1,
+	}
+	tsExpected := timestampTestMap()
+	tsExpected["releases_last_update_timestamp_utc_seconds"] = greaterThan
+	before := getPromCounterValue("releases_pruned_objects_total")
+	assertMetrics(t, ct, func() { c.ResourceUpdated(testResource, testResource) }, tsExpected)
+
+	assert.Equal(t, "---\napiVersion: v1\nkind: Service\nmetadata:\n  name: dory\n", deleted)
+	assert.Equal(t, []manifest.Ref{renderedRef}, inv["dory"].Objects)
+	assert.Equal(t, before+1, getPromCounterValue("releases_pruned_objects_total"))
+}
+
+func TestResourceUpdatedSkipsProtectedObjects(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef, staleRef}}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{Prune: true}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveService, `, "annotations": {"lostromos.wpengine.io/prune": "disabled"}`), nil)
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, []manifest.Ref{renderedRef}, inv["dory"].Objects)
+}
+
+func TestResourceUpdatedPruneDryRun(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef, staleRef}}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{Prune: true, PruneDryRun: true}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveService, ""), nil)
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, []manifest.Ref{renderedRef, staleRef}, inv["dory"].Objects)
+}
+
+func TestResourceUpdatedForgetsMissingObjects(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef, staleRef}}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{Prune: true}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	mockKube.EXPECT().Get(gomock.Any()).Return("", nil)
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, []manifest.Ref{renderedRef}, inv["dory"].Objects)
+}
+
+func TestResourceUpdatedPruneFails(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef, staleRef}}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{Prune: true}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any())
+	mockKube.EXPECT().Get(gomock.Any()).Return(fmt.Sprintf(liveService, ""), nil)
+	mockKube.EXPECT().Delete(gomock.Any()).Return("forbidden", errors.New("exit status 1"))
+	ct := counterTest{
+		events:    1,
+		updateErr: 1,
+	}
+	before := getPromCounterValue("releases_prune_error_total")
+	assertMetrics(t, ct, func() { c.ResourceUpdated(testResource, testResource) }, timestampTestMap())
+
+	assert.Equal(t, []manifest.Ref{renderedRef, staleRef}, inv["dory"].Objects)
+	assert.Equal(t, before+1, getPromCounterValue("releases_prune_error_total"))
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"errors"
+
+	"github.com/wpengine/lostromos/manifest"
+)
+
+// redact will mask the sensitive values of the objects in data in the output
+// and error of a command that was sent them, since both are logged
+func (c Controller) redact(data []byte, out string, err error) (string, error) {
+	objs, parseErr := manifest.Parse(data)
+	if parseErr != nil {
+		// there are no objects to find the values in
+		return out, err
+	}
+	values := c.Config.Redactor.Values(objs)
+	return manifest.Mask(out, values), redactErr(err, values)
+}
+
+// redactErr will mask the values in the message of err, keeping err as it is
+// when there is nothing to mask
+func redactErr(err error, values []string) error {
+	if err == nil {
+		return nil
+	}
+	msg := manifest.Mask(err.Error(), values)
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+)
+
+const secretManifest = `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: nemo
+data:
+  password: aHVudGVyMjI=
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nemo
+data:
+  apiKey: fins-and-gills
+`
+
+func TestWithFileRedacts(t *testing.T) {
+	c := Controller{Config: &Config{Redactor: manifest.Redactor{Fields: []string{"data.apiKey"}}}}
+	out, err := c.withFile([]byte(secretManifest), func(string) (string, error) {
+		return "secret/nemo: password hunter22 is invalid\nconfigmap/nemo: fins-and-gills", errors.New("data.password: aHVudGVyMjI= rejected")
+	})
+	assert.Equal(t, "secret/nemo: password <redacted> is invalid\nconfigmap/nemo: <redacted>", out)
+	assert.EqualError(t, err, "data.password: <redacted> rejected")
+}
+
+func TestWithFileKeepsError(t *testing.T) {
+	c := Controller{Config: &Config{}}
+	timeout := TimeoutError{Program: "kubectl", Command: "apply"}
+	out, err := c.withFile([]byte(secretManifest), func(string) (string, error) {
+		return "secret/nemo configured", timeout
+	})
+	assert.Equal(t, "secret/nemo configured", out)
+	assert.Equal(t, timeout, err)
+}
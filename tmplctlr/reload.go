@@ -0,0 +1,97 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"time"
+
+	"github.com/wpengine/lostromos/metrics"
+)
+
+// reloadDelay is how long to wait for more changes before reloading, editors
+// and ConfigMap volume updates tend to generate several events at once.
+var reloadDelay = 500 * time.Millisecond
+
+// ReloadTemplates will parse the templates and default values again and
+// replace the ones in use. If either fail to load the current ones are kept
+// and an error is returned. When Resync is set it is called after a
+// successful reload.
+func (c Controller) ReloadTemplates() error {
+	t, err := c.loadTemplates()
+	var d *defaultValues
+	if err == nil {
+		d, err = c.loadValues()
+	}
+	if err != nil {
+		metrics.TemplateReloadFailures.Inc()
+		c.logger.Errorw("failed to reload templates, keeping the current templates", "error", err)
+		return err
+	}
+	c.templates.set(t, d)
+	metrics.TemplateReloads.Inc()
+	metrics.LastSuccessfulTemplateReload.Set(float64(time.Now().UTC().UnixNano()) / 1000000000)
+	c.logger.Info("templates reloaded")
+	if c.Resync != nil {
+		c.Resync()
+	}
+	return nil
+}
+
+// WatchTemplates will watch the template sources and reload the templates
+// when they change until stopCh is closed. When using template sets the sets
+// dir is also watched so that new sets are loaded, but changes to the files
+// of a new set are only seen after a restart.
+func (c Controller) WatchTemplates(stopCh <-chan struct{}) error {
+	changes := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+			// a reload is already pending
+		}
+	}
+	sources, err := c.sources()
+	if err != nil {
+		return err
+	}
+	watched := make([]TemplateSource, 0, len(sources)+1)
+	for _, src := range sources {
+		watched = append(watched, src)
+	}
+	if c.Config.SetsDir != "" {
+		watched = append(watched, DirSource{Dir: c.Config.SetsDir})
+	}
+	for _, src := range watched {
+		if err := src.Watch(stopCh, changed); err != nil {
+			return err
+		}
+	}
+	go func() {
+		var reload <-chan time.Time
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-changes:
+				c.logger.Debug("templates changed")
+				reload = time.After(reloadDelay)
+			case <-reload:
+				reload = nil
+				_ = c.ReloadTemplates()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/tmplctlr"
+)
+
+// renderedContent sets up the mock to record the contents of the file that
+// is applied
+func renderedContent(mockKube *MockKubeClient, content *string) {
+	mockKube.EXPECT().Apply(gomock.Any()).Do(func(file string) {
+		b, _ := ioutil.ReadFile(file)
+		*content = string(b)
+	})
+}
+
+func TestReloadTemplates(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
+	resynced := false
+	c.Resync = func() { resynced = true }
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	var content string
+	renderedContent(mockKube, &content)
+	c.ResourceAdded(testResource)
+	assert.Equal(t, "--- "+configMapTemplate("dory-configmap"), content)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "file1.tmpl"), []byte(configMapTemplate("{{ .Name }}-reloaded")), 0644)
+	assert.Nil(t, err)
+	before := getPromCounterValue("templates_reload_total")
+	assert.Nil(t, c.ReloadTemplates())
+	assert.Equal(t, float64(1), getPromCounterValue("templates_reload_total")-before)
+	assert.True(t, resynced)
+
+	renderedContent(mockKube, &content)
+	c.ResourceAdded(testResource)
+	assert.Equal(t, "--- "+configMapTemplate("dory-reloaded"), content)
+}
+
+func TestReloadTemplatesKeepsTemplatesOnFailure(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
+	resynced := false
+	c.Resync = func() { resynced = true }
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	err = ioutil.WriteFile(filepath.Join(dir, "file1.tmpl"), []byte(`name: {{ .Name `), 0644)
+	assert.Nil(t, err)
+	before := getPromCounterValue("templates_reload_error_total")
+	assert.NotNil(t, c.ReloadTemplates())
+	assert.Equal(t, float64(1), getPromCounterValue("templates_reload_error_total")-before)
+	assert.False(t, resynced)
+
+	var content string
+	renderedContent(mockKube, &content)
+	c.ResourceAdded(testResource)
+	assert.Equal(t, "--- "+configMapTemplate("dory-configmap"), content)
+}
+
+func TestWatchTemplatesReloadsOnChange(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
+	reloaded := make(chan struct{}, 1)
+	c.Resync = func() { reloaded <- struct{}{} }
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	assert.Nil(t, c.WatchTemplates(stopCh))
+
+	err = ioutil.WriteFile(filepath.Join(dir, "file1.tmpl"), []byte(`name: {{ .Name }}-watched`), 0644)
+	assert.Nil(t, err)
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("templates were not reloaded after a change")
+	}
+}
+
+func TestWatchTemplatesFailsForMissingDir(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
+	os.RemoveAll(dir)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	assert.NotNil(t, c.WatchTemplates(stopCh))
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/metrics"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// rollback will apply the manifest from the last successful apply of the
+// custom resource again, to undo a failed apply or objects that never became
+// ready.
+func (c Controller) rollback(r *unstructured.Unstructured) {
+	inv, err := c.Config.Inventory.Get(r)
+	if err != nil {
+		metrics.RollbackFailures.Inc()
+		c.logger.Errorw("failed to roll back", "resource", r.GetName(), "error", err)
+		return
+	}
+	if inv == nil || inv.Manifest == "" {
+		c.logger.Infow("nothing to roll back to, no revision was applied successfully", "resource", r.GetName())
+		return
+	}
+	c.logger.Infow("rolling back", "resource", r.GetName(), "revision", inv.Revision)
+	out := ""
+	objs, err := manifest.Parse([]byte(inv.Manifest))
+	if err == nil {
+		out, err = c.sendApply(r, []byte(inv.Manifest), objs)
+	}
+	if err != nil {
+		metrics.RollbackFailures.Inc()
+		c.logger.Errorw("failed to roll back", "resource", r.GetName(), "revision", inv.Revision, "error", err, "cmdOutput", out)
+		return
+	}
+	metrics.Rollbacks.Inc()
+	c.logger.Infow("rolled back to the last successful revision", "resource", r.GetName(), "revision", inv.Revision)
+}
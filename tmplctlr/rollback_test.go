@@ -0,0 +1,108 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/inventory"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/tmplctlr"
+)
+
+const goodManifest = "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: dory-configmap\n"
+
+func TestNewControllerRollbackRequiresInventory(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	_, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Rollback: true}, nil)
+	assert.EqualError(t, err, "an inventory store is required to roll back")
+}
+
+func TestResourceUpdatedApplyFailsRollsBack(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef}, Manifest: goodManifest, Revision: 2}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{Rollback: true}, inv)
+	defer cleanup()
+
+	var rolledBack string
+	gomock.InOrder(
+		mockKube.EXPECT().Apply(gomock.Any()).Return("", errors.New("apply failed")),
+		mockKube.EXPECT().Apply(gomock.Any()).Do(func(file string) {
+			b, _ := ioutil.ReadFile(file)
+			rolledBack = string(b)
+		}),
+	)
+	before := getPromCounterValue("releases_rollback_total")
+	ct := counterTest{
+		events:    1,
+		updateErr: 1,
+	}
+	assertMetrics(t, ct, func() { c.ResourceUpdated(testResource, testResource) }, timestampTestMap())
+
+	assert.Equal(t, goodManifest, rolledBack)
+	assert.Equal(t, float64(1), getPromCounterValue("releases_rollback_total")-before)
+	assert.Equal(t, 2, inv["dory"].Revision)
+	assert.Equal(t, goodManifest, inv["dory"].Manifest)
+}
+
+func TestResourceAddedWaitFailsRollsBack(t *testing.T) {
+	dir := createTestDir([]testFile{{"0_base.tmpl", "apiVersion: apps/v1beta1\nkind: Deployment\nmetadata:\n  name: dory"}})
+	defer os.RemoveAll(dir)
+	inv := fakeInventory{"dory": {Manifest: goodManifest, Revision: 1}}
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Wait: true, Rollback: true, Inventory: inv}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	mockKube.EXPECT().Apply(gomock.Any()).Times(2)
+	mockKube.EXPECT().Get(gomock.Any()).Return(`{"apiVersion": "apps/v1beta1", "kind": "Deployment", "metadata": {"name": "dory"}, "status": {}}`, nil)
+
+	ct := counterTest{
+		events:    1,
+		createErr: 1,
+	}
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, timestampTestMap())
+}
+
+func TestResourceAddedApplyFailsWithoutRevision(t *testing.T) {
+	inv := fakeInventory{}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{Rollback: true}, inv)
+	defer cleanup()
+
+	// the first apply failed so there is nothing to roll back to
+	mockKube.EXPECT().Apply(gomock.Any()).Return("", errors.New("apply failed"))
+	c.ResourceAdded(testResource)
+
+	assert.Equal(t, &inventory.Inventory{Objects: []manifest.Ref{renderedRef}}, inv["dory"])
+}
+
+func TestResourceUpdatedRollbackFails(t *testing.T) {
+	inv := fakeInventory{"dory": {Objects: []manifest.Ref{renderedRef}, Manifest: goodManifest, Revision: 2}}
+	c, mockKube, cleanup := newInventoryController(t, tmplctlr.Config{Rollback: true}, inv)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any()).Return("", errors.New("apply failed")).Times(2)
+	before := getPromCounterValue("releases_rollback_error_total")
+	c.ResourceUpdated(testResource, testResource)
+
+	assert.Equal(t, float64(1), getPromCounterValue("releases_rollback_error_total")-before)
+}
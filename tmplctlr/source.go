@@ -0,0 +1,68 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/wpengine/lostromos/tmpl"
+)
+
+// TemplateSource provides the template files used by the Controller
+type TemplateSource interface {
+	// Templates returns the contents of every template file keyed by file name
+	Templates() (map[string]string, error)
+	// Watch calls changed whenever the templates may have changed until
+	// stopCh is closed
+	Watch(stopCh <-chan struct{}, changed func()) error
+}
+
+// DirSource reads the *.tmpl files in a local directory
+type DirSource struct {
+	Dir string // path to dir where templates are located
+}
+
+// Templates returns the contents of the *.tmpl files in the directory
+func (d DirSource) Templates() (map[string]string, error) {
+	return tmpl.ReadFiles(filepath.Join(d.Dir, "*.tmpl"))
+}
+
+// Watch uses fsnotify to call changed for every change in the directory
+func (d DirSource) Watch(stopCh <-chan struct{}, changed func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err = w.Add(d.Dir); err != nil {
+		w.Close()
+		return err
+	}
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-w.Events:
+				changed()
+			case <-w.Errors:
+				// errors only mean events were missed, so treat them as a change
+				changed()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/wpengine/lostromos/metrics"
+	"github.com/wpengine/lostromos/target"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// clusterRetryAfter is how long a target cluster that can't be reached fails
+// for before it is tried again
+var clusterRetryAfter = 30 * time.Second
+
+// clusterClient will return the KubeClient for the target cluster of the
+// custom resource, which is Client for the cluster lostromos is configured for
+func (c Controller) clusterClient(r *unstructured.Unstructured) (KubeClient, error) {
+	if c.Config.Targets == nil {
+		return c.Client, nil
+	}
+	cl, err := c.Config.Targets.Cluster(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(cl.KubeConfig) == 0 {
+		return c.Client, nil
+	}
+	client, err := c.clusters.Get(cl)
+	if err != nil {
+		return nil, err
+	}
+	return client.(KubeClient), nil
+}
+
+// trackCluster will count the event for the target cluster of the custom
+// resource. When it failed the cluster is checked, so that if it can't be
+// reached the next custom resources for it fail straight away.
+func (c Controller) trackCluster(r *unstructured.Unstructured, err error) {
+	if c.Config.Targets == nil {
+		return
+	}
+	name := c.Config.Targets.Name(r)
+	metrics.ClusterEvents.WithLabelValues(name).Inc()
+	if err != nil {
+		metrics.ClusterFailures.WithLabelValues(name).Inc()
+		c.clusters.Check(name)
+	}
+}
+
+// kubectlFor will return a Kubectl for the target cluster, with its
+// kubeconfig written to a file that is removed when the client is replaced
+func (c Controller) kubectlFor(cl target.Cluster) (KubeClient, error) {
+	f, err := ioutil.TempFile("", "lostromos-kubeconfig")
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(cl.KubeConfig)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return clusterKubectl{c.kubectl(&Kubectl{ConfigFile: f.Name(), Namespace: c.Config.Namespace})}, nil
+}
+
+// clusterKubectl is a Kubectl that owns its kubeconfig file
+type clusterKubectl struct {
+	*Kubectl
+}
+
+// Close will remove the kubeconfig file
+func (k clusterKubectl) Close() error {
+	return os.Remove(k.ConfigFile)
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/target"
+)
+
+func TestKubectlForTargetCluster(t *testing.T) {
+	c := Controller{Config: &Config{Namespace: "ocean"}}
+	client, err := c.kubectlFor(target.Cluster{Name: "ocean/reef", KubeConfig: []byte("kind: Config")})
+	assert.Nil(t, err)
+
+	k := client.(clusterKubectl)
+	assert.Equal(t, "ocean", k.Namespace)
+	b, err := ioutil.ReadFile(k.ConfigFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "kind: Config", string(b))
+
+	assert.Nil(t, k.Close())
+	_, err = os.Stat(k.ConfigFile)
+	assert.True(t, os.IsNotExist(err))
+}
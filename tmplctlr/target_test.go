@@ -0,0 +1,150 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/target"
+	"github.com/wpengine/lostromos/tmplctlr"
+)
+
+// clusterKubeConfig returns a kubeconfig for the API server at server
+func clusterKubeConfig(server string) []byte {
+	return []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: reef
+  cluster:
+    server: ` + server + `
+contexts:
+- name: reef
+  context:
+    cluster: reef
+current-context: reef
+`)
+}
+
+// newTargetController returns a Controller that deploys into the cluster at
+// server, with a mock client for it
+func newTargetController(t *testing.T, server string) (*tmplctlr.Controller, *MockKubeClient, *MockKubeClient, func()) {
+	dir := createTestDir(testTemplates)
+	targets := &target.Resolver{Default: target.Cluster{Name: target.DefaultName, KubeConfig: clusterKubeConfig(server)}}
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir, Targets: targets}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	local := NewMockKubeClient(mockCtrl)
+	remote := NewMockKubeClient(mockCtrl)
+	c.Client = local
+	c.ClusterClient = func(cl target.Cluster) (tmplctlr.KubeClient, error) {
+		return remote, nil
+	}
+	return c, local, remote, func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewControllerFailsWithDriftAndTargetField(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+	cfg := &tmplctlr.Config{
+		TemplateDir: dir,
+		Drift:       true,
+		Targets:     &target.Resolver{Field: "spec.cluster"},
+	}
+	cfg.Ownership.Labels = true
+	_, err := tmplctlr.NewController(cfg, nil)
+	assert.EqualError(t, err, "drift can't be watched with a target cluster for each custom resource")
+}
+
+func TestResourceAddedDeploysToTargetCluster(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"major": "1", "minor": "9"}`))
+	}))
+	defer server.Close()
+	c, _, remote, cleanup := newTargetController(t, server.URL)
+	defer cleanup()
+
+	remote.EXPECT().Apply(gomock.Any())
+	before := getPromLabeledCounterValue("releases_cluster_events_total", "cluster", target.DefaultName)
+	ct := counterTest{
+		events:   1,
+		create:   1,
+		releases: 1,
+	}
+	tsExpected := timestampTestMap()
+	tsExpected["releases_last_create_timestamp_utc_seconds"] = greaterThan
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, tsExpected)
+
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("releases_cluster_events_total", "cluster", target.DefaultName)-before)
+}
+
+func TestResourceAddedFailsForUnreachableCluster(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	c, _, _, cleanup := newTargetController(t, server.URL)
+	defer cleanup()
+
+	before := getPromLabeledCounterValue("releases_cluster_error_total", "cluster", target.DefaultName)
+	ct := counterTest{
+		events:    1,
+		createErr: 1,
+	}
+	// nothing is sent to either client
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, timestampTestMap())
+
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("releases_cluster_error_total", "cluster", target.DefaultName)-before)
+}
+
+func TestResourceAddedWithoutOwnerReferencesInTargetCluster(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"major": "1", "minor": "9"}`))
+	}))
+	defer server.Close()
+	dir := createTestDir([]testFile{{"0_base.tmpl", configMapTemplate("{{ .Name }}\n  namespace: {{ .Namespace }}")}})
+	defer os.RemoveAll(dir)
+	c, err := tmplctlr.NewController(&tmplctlr.Config{
+		TemplateDir: dir,
+		Ownership:   manifest.Ownership{Labels: true, OwnerReferences: true},
+		Targets:     &target.Resolver{Default: target.Cluster{Name: target.DefaultName, KubeConfig: clusterKubeConfig(server.URL)}},
+	}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	remote := NewMockKubeClient(mockCtrl)
+	c.Client = NewMockKubeClient(mockCtrl)
+	c.ClusterClient = func(cl target.Cluster) (tmplctlr.KubeClient, error) {
+		return remote, nil
+	}
+
+	r := testResource.DeepCopy()
+	r.SetNamespace("ocean")
+	r.SetUID("1234")
+	var content string
+	renderedContent(remote, &content)
+	c.ResourceAdded(r)
+
+	// the custom resource isn't in the target cluster for the garbage
+	// collector to find, so it would delete the object
+	assert.Contains(t, content, "lostromos.wpengine.io/cr-uid")
+	assert.NotContains(t, content, "ownerReferences")
+}
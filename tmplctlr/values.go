@@ -0,0 +1,74 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/wpengine/lostromos/tmpl"
+)
+
+// defaultValues are merged beneath the spec of the custom resources before
+// rendering.
+type defaultValues struct {
+	global     map[string]interface{}            // defaults for every namespace
+	namespaces map[string]map[string]interface{} // defaults for each namespace, already merged over global
+}
+
+// forNamespace will return the default values for custom resources in the
+// namespace.
+func (d *defaultValues) forNamespace(namespace string) map[string]interface{} {
+	if d == nil {
+		return nil
+	}
+	if v, ok := d.namespaces[namespace]; ok {
+		return v
+	}
+	return d.global
+}
+
+// loadValues will read the ValuesFile and the files in NamespaceValuesDir.
+// The namespace of each file is its name without the .yaml or .yml extension.
+func (c Controller) loadValues() (*defaultValues, error) {
+	d := &defaultValues{namespaces: map[string]map[string]interface{}{}}
+	if c.Config.ValuesFile != "" {
+		v, err := tmpl.ReadValues(c.Config.ValuesFile)
+		if err != nil {
+			return nil, err
+		}
+		d.global = v
+	}
+	if c.Config.NamespaceValuesDir == "" {
+		return d, nil
+	}
+	entries, err := ioutil.ReadDir(c.Config.NamespaceValuesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		v, err := tmpl.ReadValues(filepath.Join(c.Config.NamespaceValuesDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		d.namespaces[strings.TrimSuffix(e.Name(), ext)] = tmpl.MergeValues(d.global, v)
+	}
+	return d, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/tmplctlr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDefaultValues(t *testing.T) {
+	dir := createTestDir([]testFile{
+		{"0_base.tmpl", configMapTemplate(`{{ .Values.Name | lower }}-by-{{ .Values.By | lower }}-{{ .Values.replicas }}`)},
+	})
+	defer os.RemoveAll(dir)
+	valuesDir := createTestDir([]testFile{
+		{"values.yaml", "replicas: 1\nBy: Pixar\n"},
+		{"ocean.yaml", "replicas: 3\n"},
+		{"README.md", "not values"},
+	})
+	defer os.RemoveAll(valuesDir)
+	nsDir := filepath.Join(valuesDir, "namespaces")
+	assert.Nil(t, os.Mkdir(nsDir, 0755))
+	assert.Nil(t, os.Rename(filepath.Join(valuesDir, "ocean.yaml"), filepath.Join(nsDir, "ocean.yaml")))
+
+	c, err := tmplctlr.NewController(&tmplctlr.Config{
+		TemplateDir:        dir,
+		ValuesFile:         filepath.Join(valuesDir, "values.yaml"),
+		NamespaceValuesDir: nsDir,
+	}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+
+	var content string
+	renderedContent(mockKube, &content)
+	c.ResourceAdded(testResource)
+	assert.Equal(t, configMapTemplate("dory-by-disney-1"), content)
+
+	inOcean := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "nemo", "namespace": "ocean"},
+		"spec":     map[string]interface{}{"Name": "Nemo"},
+	}}
+	renderedContent(mockKube, &content)
+	c.ResourceAdded(inOcean)
+	assert.Equal(t, configMapTemplate("nemo-by-pixar-3"), content)
+}
+
+func TestNewControllerFailsWithInvalidValues(t *testing.T) {
+	dir := createTestDir(testTemplates)
+	defer os.RemoveAll(dir)
+
+	c, err := tmplctlr.NewController(&tmplctlr.Config{
+		TemplateDir: dir,
+		ValuesFile:  filepath.Join(dir, "missing.yaml"),
+	}, nil)
+	assert.Nil(t, c)
+	assert.NotNil(t, err)
+}
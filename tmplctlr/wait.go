@@ -0,0 +1,229 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wpengine/lostromos/manifest"
+	"github.com/wpengine/lostromos/tmpl"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// waitInterval is how long to wait between readiness checks
+var waitInterval = 2 * time.Second
+
+// readinessChecks will say whether a live object of a kind is ready, with the
+// reason when it isn't. An error means the object will never be ready. Kinds
+// that aren't here are ready as soon as they are applied.
+var readinessChecks = map[string]func(obj map[string]interface{}) (bool, string, error){
+	"Deployment":            deploymentReady,
+	"StatefulSet":           statefulSetReady,
+	"DaemonSet":             daemonSetReady,
+	"Job":                   jobReady,
+	"PersistentVolumeClaim": pvcReady,
+	"Service":               serviceReady,
+	// so the custom resources in the next wave can be applied
+	"CustomResourceDefinition": crdReady,
+}
+
+// waitReady will check the applied objects until they are all ready, or
+// return an error listing the ones that aren't when WaitTimeout runs out.
+func (c Controller) waitReady(r *unstructured.Unstructured, refs []manifest.Ref) error {
+	var pending []manifest.Ref
+	for _, ref := range refs {
+		if _, ok := readinessChecks[ref.Kind]; ok {
+			pending = append(pending, ref)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	timeout := time.Duration(c.Config.WaitTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	c.logger.Infow("waiting for resources to be ready", "resource", r.GetName(), "objects", len(pending), "timeout", timeout)
+	for {
+		live, err := c.liveByKey(r, pending)
+		if err != nil {
+			return err
+		}
+		var (
+			notReady []manifest.Ref
+			reasons  []string
+		)
+		for _, ref := range pending {
+			obj, ok := live[ref.Key()]
+			if !ok {
+				notReady = append(notReady, ref)
+				reasons = append(reasons, ref.Key()+" (not found)")
+				continue
+			}
+			ready, reason, err := readinessChecks[ref.Kind](obj.Object)
+			if err != nil {
+				return fmt.Errorf("%s will not be ready: %s", ref.Key(), err)
+			}
+			if !ready {
+				notReady = append(notReady, ref)
+				reasons = append(reasons, ref.Key()+" ("+reason+")")
+			}
+		}
+		if len(notReady) == 0 {
+			c.logger.Infow("resources are ready", "resource", r.GetName())
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s", timeout, strings.Join(reasons, ", "))
+		}
+		pending = notReady
+		time.Sleep(waitInterval)
+	}
+}
+
+func deploymentReady(obj map[string]interface{}) (bool, string, error) {
+	if !observed(obj) {
+		return false, "update not observed", nil
+	}
+	replicas := intField(obj, 1, "spec", "replicas")
+	updated := intField(obj, 0, "status", "updatedReplicas")
+	available := intField(obj, 0, "status", "availableReplicas")
+	total := intField(obj, 0, "status", "replicas")
+	switch {
+	case updated < replicas:
+		return false, fmt.Sprintf("%d of %d replicas updated", updated, replicas), nil
+	case total > updated:
+		return false, fmt.Sprintf("%d old replicas pending termination", total-updated), nil
+	case available < replicas:
+		return false, fmt.Sprintf("%d of %d replicas available", available, replicas), nil
+	}
+	return true, "", nil
+}
+
+func statefulSetReady(obj map[string]interface{}) (bool, string, error) {
+	if !observed(obj) {
+		return false, "update not observed", nil
+	}
+	replicas := intField(obj, 1, "spec", "replicas")
+	ready := intField(obj, 0, "status", "readyReplicas")
+	if ready < replicas {
+		return false, fmt.Sprintf("%d of %d replicas ready", ready, replicas), nil
+	}
+	current, _ := stringField(obj, "status", "currentRevision")
+	update, _ := stringField(obj, "status", "updateRevision")
+	if update != "" && current != update {
+		return false, "rolling update in progress", nil
+	}
+	return true, "", nil
+}
+
+func daemonSetReady(obj map[string]interface{}) (bool, string, error) {
+	if !observed(obj) {
+		return false, "update not observed", nil
+	}
+	desired := intField(obj, 0, "status", "desiredNumberScheduled")
+	updated := intField(obj, 0, "status", "updatedNumberScheduled")
+	available := intField(obj, 0, "status", "numberAvailable")
+	switch {
+	case updated < desired:
+		return false, fmt.Sprintf("%d of %d pods updated", updated, desired), nil
+	case available < desired:
+		return false, fmt.Sprintf("%d of %d pods available", available, desired), nil
+	}
+	return true, "", nil
+}
+
+func jobReady(obj map[string]interface{}) (bool, string, error) {
+	status, _ := obj["status"].(map[string]interface{})
+	conditions, _ := status["conditions"].([]interface{})
+	for _, c := range conditions {
+		cond, _ := c.(map[string]interface{})
+		if cond["type"] == "Failed" && cond["status"] == "True" {
+			return false, "", fmt.Errorf("job failed: %v", cond["message"])
+		}
+	}
+	completions := intField(obj, 1, "spec", "completions")
+	succeeded := intField(obj, 0, "status", "succeeded")
+	if succeeded < completions {
+		return false, fmt.Sprintf("%d of %d completions", succeeded, completions), nil
+	}
+	return true, "", nil
+}
+
+func pvcReady(obj map[string]interface{}) (bool, string, error) {
+	phase, _ := stringField(obj, "status", "phase")
+	if phase == "Lost" {
+		return false, "", fmt.Errorf("the volume was lost")
+	}
+	if phase != "Bound" {
+		return false, "not bound", nil
+	}
+	return true, "", nil
+}
+
+func serviceReady(obj map[string]interface{}) (bool, string, error) {
+	if t, _ := stringField(obj, "spec", "type"); t != "LoadBalancer" {
+		return true, "", nil
+	}
+	status, _ := obj["status"].(map[string]interface{})
+	lb, _ := status["loadBalancer"].(map[string]interface{})
+	if ingress, _ := lb["ingress"].([]interface{}); len(ingress) == 0 {
+		return false, "no load balancer ingress", nil
+	}
+	return true, "", nil
+}
+
+func crdReady(obj map[string]interface{}) (bool, string, error) {
+	status, _ := obj["status"].(map[string]interface{})
+	conditions, _ := status["conditions"].([]interface{})
+	for _, c := range conditions {
+		cond, _ := c.(map[string]interface{})
+		if cond["type"] == "NamesAccepted" && cond["status"] == "False" {
+			return false, "", fmt.Errorf("names not accepted: %v", cond["message"])
+		}
+		if cond["type"] == "Established" && cond["status"] == "True" {
+			return true, "", nil
+		}
+	}
+	return false, "not established", nil
+}
+
+// observed will return true when the controller has seen the latest spec
+func observed(obj map[string]interface{}) bool {
+	return intField(obj, 0, "status", "observedGeneration") >= intField(obj, 0, "metadata", "generation")
+}
+
+// intField will return the number at the path, or def if it isn't set
+func intField(obj map[string]interface{}, def int64, fields ...string) int64 {
+	v, ok := tmpl.NestedField(obj, fields...)
+	if !ok {
+		return def
+	}
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	}
+	return def
+}
+
+func stringField(obj map[string]interface{}, fields ...string) (string, bool) {
+	v, ok := tmpl.NestedField(obj, fields...)
+	s, isString := v.(string)
+	return s, ok && isString
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/manifest"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func decodeObject(t *testing.T, s string) map[string]interface{} {
+	var obj map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(s), &obj))
+	return obj
+}
+
+func TestReadinessChecks(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		kind   string
+		obj    string
+		ready  bool
+		reason string
+		errors bool
+	}{
+		{"deployment ready", "Deployment", `{"metadata": {"generation": 2}, "spec": {"replicas": 2}, "status": {"observedGeneration": 2, "replicas": 2, "updatedReplicas": 2, "availableReplicas": 2}}`, true, "", false},
+		{"deployment not observed", "Deployment", `{"metadata": {"generation": 3}, "spec": {"replicas": 2}, "status": {"observedGeneration": 2, "replicas": 2, "updatedReplicas": 2, "availableReplicas": 2}}`, false, "update not observed", false},
+		{"deployment rolling", "Deployment", `{"spec": {"replicas": 3}, "status": {"replicas": 3, "updatedReplicas": 1, "availableReplicas": 3}}`, false, "1 of 3 replicas updated", false},
+		{"deployment old replicas", "Deployment", `{"spec": {"replicas": 2}, "status": {"replicas": 3, "updatedReplicas": 2, "availableReplicas": 2}}`, false, "1 old replicas pending termination", false},
+		{"deployment default replicas", "Deployment", `{"status": {"replicas": 1, "updatedReplicas": 1}}`, false, "0 of 1 replicas available", false},
+		{"statefulset ready", "StatefulSet", `{"spec": {"replicas": 2}, "status": {"readyReplicas": 2, "currentRevision": "a", "updateRevision": "a"}}`, true, "", false},
+		{"statefulset updating", "StatefulSet", `{"spec": {"replicas": 2}, "status": {"readyReplicas": 2, "currentRevision": "a", "updateRevision": "b"}}`, false, "rolling update in progress", false},
+		{"statefulset not ready", "StatefulSet", `{"spec": {"replicas": 2}, "status": {"readyReplicas": 1}}`, false, "1 of 2 replicas ready", false},
+		{"daemonset ready", "DaemonSet", `{"status": {"desiredNumberScheduled": 3, "updatedNumberScheduled": 3, "numberAvailable": 3}}`, true, "", false},
+		{"daemonset not available", "DaemonSet", `{"status": {"desiredNumberScheduled": 3, "updatedNumberScheduled": 3, "numberAvailable": 2}}`, false, "2 of 3 pods available", false},
+		{"job complete", "Job", `{"spec": {"completions": 2}, "status": {"succeeded": 2}}`, true, "", false},
+		{"job running", "Job", `{"status": {"active": 1}}`, false, "0 of 1 completions", false},
+		{"job failed", "Job", `{"status": {"conditions": [{"type": "Failed", "status": "True", "message": "BackoffLimitExceeded"}]}}`, false, "", true},
+		{"pvc bound", "PersistentVolumeClaim", `{"status": {"phase": "Bound"}}`, true, "", false},
+		{"pvc pending", "PersistentVolumeClaim", `{"status": {"phase": "Pending"}}`, false, "not bound", false},
+		{"pvc lost", "PersistentVolumeClaim", `{"status": {"phase": "Lost"}}`, false, "", true},
+		{"cluster ip service", "Service", `{"spec": {"type": "ClusterIP"}}`, true, "", false},
+		{"load balancer pending", "Service", `{"spec": {"type": "LoadBalancer"}, "status": {"loadBalancer": {}}}`, false, "no load balancer ingress", false},
+		{"load balancer ready", "Service", `{"spec": {"type": "LoadBalancer"}, "status": {"loadBalancer": {"ingress": [{"ip": "10.0.0.1"}]}}}`, true, "", false},
+		{"crd established", "CustomResourceDefinition", `{"status": {"conditions": [{"type": "NamesAccepted", "status": "True"}, {"type": "Established", "status": "True"}]}}`, true, "", false},
+		{"crd not established", "CustomResourceDefinition", `{"status": {}}`, false, "not established", false},
+		{"crd names not accepted", "CustomResourceDefinition", `{"status": {"conditions": [{"type": "NamesAccepted", "status": "False", "message": "conflict"}]}}`, false, "", true},
+	}
+
+	for _, tt := range testCases {
+		ready, reason, err := readinessChecks[tt.kind](decodeObject(t, tt.obj))
+		assert.Equal(t, tt.ready, ready, tt.name)
+		assert.Equal(t, tt.reason, reason, tt.name)
+		assert.Equal(t, tt.errors, err != nil, tt.name)
+	}
+}
+
+// getClient is a KubeClient that returns the next output for each Get
+type getClient struct {
+	KubeClient
+	outputs []string
+	gets    int
+}
+
+func (g *getClient) Get(file string) (string, error) {
+	out := g.outputs[g.gets]
+	if g.gets < len(g.outputs)-1 {
+		g.gets++
+	}
+	return out, nil
+}
+
+const (
+	pendingDeployment = `{"apiVersion": "apps/v1beta1", "kind": "Deployment", "metadata": {"name": "dory"}, "spec": {"replicas": 1}, "status": {"replicas": 1, "updatedReplicas": 1}}`
+	readyDeployment   = `{"apiVersion": "apps/v1beta1", "kind": "Deployment", "metadata": {"name": "dory"}, "spec": {"replicas": 1}, "status": {"replicas": 1, "updatedReplicas": 1, "availableReplicas": 1}}`
+)
+
+var waitRefs = []manifest.Ref{
+	{APIVersion: "v1", Kind: "ConfigMap", Name: "dory"},
+	{APIVersion: "apps/v1beta1", Kind: "Deployment", Name: "dory"},
+}
+
+func waitController(timeout int64, outputs ...string) (Controller, *getClient) {
+	client := &getClient{outputs: outputs}
+	return Controller{
+		Config: &Config{Wait: true, WaitTimeout: timeout},
+		Client: client,
+		logger: zap.NewNop().Sugar(),
+	}, client
+}
+
+func TestWaitReady(t *testing.T) {
+	defer func(i time.Duration) { waitInterval = i }(waitInterval)
+	waitInterval = time.Millisecond
+	c, client := waitController(10, pendingDeployment, readyDeployment)
+
+	err := c.waitReady(&unstructured.Unstructured{}, waitRefs)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, client.gets)
+}
+
+func TestWaitReadyTimesOut(t *testing.T) {
+	defer func(i time.Duration) { waitInterval = i }(waitInterval)
+	waitInterval = time.Millisecond
+	c, _ := waitController(0, pendingDeployment)
+
+	err := c.waitReady(&unstructured.Unstructured{}, waitRefs)
+	assert.EqualError(t, err, "timed out after 0s waiting for apps/Deployment dory (0 of 1 replicas available)")
+}
+
+func TestWaitReadyNotFound(t *testing.T) {
+	c, _ := waitController(0, "")
+
+	err := c.waitReady(&unstructured.Unstructured{}, waitRefs)
+	assert.EqualError(t, err, "timed out after 0s waiting for apps/Deployment dory (not found)")
+}
+
+func TestWaitReadySkipsKindsWithoutChecks(t *testing.T) {
+	c, client := waitController(0)
+
+	err := c.waitReady(&unstructured.Unstructured{}, waitRefs[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, 0, client.gets)
+}
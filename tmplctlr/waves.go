@@ -0,0 +1,65 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr
+
+import (
+	"strings"
+
+	"github.com/wpengine/lostromos/manifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// applyWaves will apply the sorted objects a wave at a time, waiting for each
+// wave to be ready before the next when waiting is enabled. When there is
+// only one wave the rendered manifest is applied as it is.
+func (c Controller) applyWaves(r *unstructured.Unstructured, rendered []byte, objs []manifest.Object) (string, error) {
+	waves := manifest.Waves(objs)
+	if len(waves) <= 1 {
+		out, err := c.sendApply(r, rendered, objs)
+		if err == nil && c.Config.Wait {
+			err = c.waitReady(r, manifest.Refs(objs))
+		}
+		return out, err
+	}
+	var outputs []string
+	for _, wave := range waves {
+		n := manifest.Wave(wave[0].Unstructured)
+		c.logger.Infow("applying wave", "resource", r.GetName(), "wave", n, "objects", len(wave))
+		out, err := c.sendApply(r, nil, wave)
+		outputs = append(outputs, strings.TrimSpace(out))
+		if err == nil && c.Config.Wait {
+			err = c.waitReady(r, manifest.Refs(wave))
+		}
+		if err != nil {
+			return strings.Join(outputs, "\n"), wrapf(err, "wave %d", n)
+		}
+	}
+	return strings.Join(outputs, "\n"), nil
+}
+
+// deleteInOrder will delete the objects in the reverse of the order they are
+// applied in, a wave at a time starting with the last one
+func (c Controller) deleteInOrder(r *unstructured.Unstructured, objs []manifest.Object) (string, error) {
+	manifest.SortForDelete(objs)
+	var outputs []string
+	for _, wave := range manifest.Waves(objs) {
+		out, err := c.sendDelete(r, wave)
+		outputs = append(outputs, strings.TrimSpace(out))
+		if err != nil {
+			return strings.Join(outputs, "\n"), err
+		}
+	}
+	return strings.Join(outputs, "\n"), nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmplctlr_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/tmplctlr"
+)
+
+const (
+	namespaceDoc = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: ocean\n"
+	waveOneDoc   = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  annotations:\n    lostromos.wpengine.io/wave: \"1\"\n  name: dory-configmap\n"
+)
+
+func newWavesController(t *testing.T, rendered string) (*tmplctlr.Controller, *MockKubeClient, func()) {
+	dir := createTestDir([]testFile{{"0_base.tmpl", rendered}})
+	c, err := tmplctlr.NewController(&tmplctlr.Config{TemplateDir: dir}, nil)
+	assert.Nil(t, err)
+	mockCtrl := gomock.NewController(t)
+	mockKube := NewMockKubeClient(mockCtrl)
+	c.Client = mockKube
+	return c, mockKube, func() {
+		mockCtrl.Finish()
+		os.RemoveAll(dir)
+	}
+}
+
+// record returns a func for Do that appends the contents of the file to files
+func record(files *[]string) func(string) {
+	return func(file string) {
+		b, _ := ioutil.ReadFile(file)
+		*files = append(*files, string(b))
+	}
+}
+
+func TestResourceAddedSortsObjects(t *testing.T) {
+	c, mockKube, cleanup := newWavesController(t, "---\n"+configMapTemplate("dory-configmap")+"\n---\n"+namespaceDoc)
+	defer cleanup()
+
+	var applied []string
+	mockKube.EXPECT().Apply(gomock.Any()).Do(record(&applied))
+	c.ResourceAdded(testResource)
+
+	assert.Equal(t, []string{"---\n" + namespaceDoc + "---\n" + configMapTemplate("dory-configmap") + "\n"}, applied)
+}
+
+func TestResourceAddedAppliesWaves(t *testing.T) {
+	c, mockKube, cleanup := newWavesController(t, "---\n"+waveOneDoc+"---\n"+namespaceDoc)
+	defer cleanup()
+
+	var applied []string
+	mockKube.EXPECT().Apply(gomock.Any()).Do(record(&applied)).Times(2)
+	c.ResourceAdded(testResource)
+
+	assert.Equal(t, []string{"---\n" + namespaceDoc, "---\n" + waveOneDoc}, applied)
+}
+
+func TestResourceAddedStopsAtFailedWave(t *testing.T) {
+	c, mockKube, cleanup := newWavesController(t, "---\n"+waveOneDoc+"---\n"+namespaceDoc)
+	defer cleanup()
+
+	mockKube.EXPECT().Apply(gomock.Any()).Return("", errors.New("forbidden"))
+	ct := counterTest{
+		events:    1,
+		createErr: 1,
+	}
+	assertMetrics(t, ct, func() { c.ResourceAdded(testResource) }, timestampTestMap())
+}
+
+func TestResourceDeletedDeletesWavesInReverse(t *testing.T) {
+	c, mockKube, cleanup := newWavesController(t, "---\n"+namespaceDoc+"---\n"+waveOneDoc)
+	defer cleanup()
+
+	var deleted []string
+	mockKube.EXPECT().Delete(gomock.Any()).Do(record(&deleted)).Times(2)
+	c.ResourceDeleted(testResource)
+
+	assert.Equal(t, []string{"---\n" + waveOneDoc, "---\n" + namespaceDoc}, deleted)
+}
+
+func TestResourceAddedCountsTimedOutWave(t *testing.T) {
+	c, mockKube, cleanup := newWavesController(t, "---\n"+waveOneDoc+"---\n"+namespaceDoc)
+	defer cleanup()
+
+	before := getPromLabeledCounterValue("releases_timeout_total", "event", "create")
+	mockKube.EXPECT().Apply(gomock.Any()).Return("", tmplctlr.TimeoutError{Program: "kubectl", Command: "apply", Timeout: time.Minute})
+	c.ResourceAdded(testResource)
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("releases_timeout_total", "event", "create")-before)
+
+	before = getPromLabeledCounterValue("releases_timeout_total", "event", "update")
+	mockKube.EXPECT().Apply(gomock.Any()).Return("", errors.New("forbidden"))
+	c.ResourceUpdated(testResource, testResource)
+	assert.Equal(t, float64(0), getPromLabeledCounterValue("releases_timeout_total", "event", "update")-before)
+}
+
+func TestResourceDeletedCountsTimeout(t *testing.T) {
+	c, mockKube, cleanup := newWavesController(t, "---\n"+namespaceDoc)
+	defer cleanup()
+
+	before := getPromLabeledCounterValue("releases_timeout_total", "event", "delete")
+	mockKube.EXPECT().Delete(gomock.Any()).Return("", tmplctlr.TimeoutError{Program: "kubectl", Command: "delete", Timeout: time.Minute})
+	c.ResourceDeleted(testResource)
+	assert.Equal(t, float64(1), getPromLabeledCounterValue("releases_timeout_total", "event", "delete")-before)
+}
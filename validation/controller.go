@@ -0,0 +1,76 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"github.com/wpengine/lostromos/crwatcher"
+	"github.com/wpengine/lostromos/metrics"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Controller implements crwatcher.ResourceController and only passes custom
+// resources that are valid against the Schema on to the next controller.
+// Deletes are always passed on so that invalid resources can be cleaned up.
+type Controller struct {
+	Schema *Schema
+	Next   crwatcher.ResourceController
+	logger *zap.SugaredLogger
+}
+
+// NewController will return a Controller that validates custom resources
+// before calling next.
+func NewController(s *Schema, next crwatcher.ResourceController, logger *zap.SugaredLogger) *Controller {
+	if logger == nil {
+		// If you don't give us a logger, set logger to a nop logger
+		logger = zap.NewNop().Sugar()
+	}
+	return &Controller{Schema: s, Next: next, logger: logger}
+}
+
+// ResourceAdded will call the next controller if the resource is valid
+func (c Controller) ResourceAdded(r *unstructured.Unstructured) {
+	if c.valid(r) {
+		c.Next.ResourceAdded(r)
+	}
+}
+
+// ResourceUpdated will call the next controller if the new resource is valid
+func (c Controller) ResourceUpdated(oldR, newR *unstructured.Unstructured) {
+	if c.valid(newR) {
+		c.Next.ResourceUpdated(oldR, newR)
+	}
+}
+
+// ResourceDeleted will always call the next controller
+func (c Controller) ResourceDeleted(r *unstructured.Unstructured) {
+	c.Next.ResourceDeleted(r)
+}
+
+func (c Controller) valid(r *unstructured.Unstructured) bool {
+	err := c.Schema.Validate(r.Object)
+	if err == nil {
+		return true
+	}
+	metrics.ValidationFailures.Inc()
+	fields := []string{}
+	if errs, ok := err.(Errors); ok {
+		for _, fe := range errs {
+			fields = append(fields, fe.Error())
+		}
+	}
+	c.logger.Errorw("invalid resource, skipping", "resource", r.GetName(), "error", err, "fields", fields)
+	return false
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation_test
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/validation"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeController records the names of the resources it is called with
+type fakeController struct {
+	added, updated, deleted []string
+}
+
+func (f *fakeController) ResourceAdded(r *unstructured.Unstructured) {
+	f.added = append(f.added, r.GetName())
+}
+
+func (f *fakeController) ResourceUpdated(oldR, newR *unstructured.Unstructured) {
+	f.updated = append(f.updated, newR.GetName())
+}
+
+func (f *fakeController) ResourceDeleted(r *unstructured.Unstructured) {
+	f.deleted = append(f.deleted, r.GetName())
+}
+
+func getValidationFailures() float64 {
+	mf, _ := prometheus.DefaultGatherer.Gather()
+	for _, s := range mf {
+		if s.GetName() == "releases_validation_error_total" {
+			return s.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func resource(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name},
+		"spec":     spec,
+	}}
+}
+
+func TestController(t *testing.T) {
+	next := &fakeController{}
+	c := validation.NewController(loadTestSchema(t), next, nil)
+	valid := resource("dory", map[string]interface{}{"name": "dory"})
+	invalid := resource("nemo", map[string]interface{}{"name": int64(1)})
+
+	before := getValidationFailures()
+	c.ResourceAdded(valid)
+	c.ResourceAdded(invalid)
+	c.ResourceUpdated(invalid, valid)
+	c.ResourceUpdated(valid, invalid)
+	c.ResourceDeleted(invalid)
+
+	assert.Equal(t, []string{"dory"}, next.added)
+	assert.Equal(t, []string{"dory"}, next.updated)
+	assert.Equal(t, []string{"nemo"}, next.deleted)
+	assert.Equal(t, float64(2), getValidationFailures()-before)
+}
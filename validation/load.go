@@ -0,0 +1,93 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+)
+
+// LoadFile will read a schema from a yaml or json file. The file can be a
+// CustomResourceDefinition, an object with an openAPIV3Schema key, or the
+// schema itself.
+func LoadFile(file string) (*Schema, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err = yaml.Unmarshal(b, &obj); err != nil {
+		return nil, fmt.Errorf("invalid schema file %s: %s", file, err)
+	}
+	if obj["kind"] == "CustomResourceDefinition" {
+		return FromCRD(obj)
+	}
+	if s, ok := obj["openAPIV3Schema"].(map[string]interface{}); ok {
+		return decode(s)
+	}
+	return decode(obj)
+}
+
+// FromCRD will return the openAPIV3Schema of a CustomResourceDefinition
+func FromCRD(crd map[string]interface{}) (*Schema, error) {
+	spec, _ := crd["spec"].(map[string]interface{})
+	validation, _ := spec["validation"].(map[string]interface{})
+	s, ok := validation["openAPIV3Schema"].(map[string]interface{})
+	if !ok {
+		name := ""
+		if metadata, ok := crd["metadata"].(map[string]interface{}); ok {
+			name, _ = metadata["name"].(string)
+		}
+		return nil, fmt.Errorf("CustomResourceDefinition %s has no openAPIV3Schema", name)
+	}
+	return decode(s)
+}
+
+// Fetch will get the openAPIV3Schema of the CustomResourceDefinition named
+// <plural>.<group> from the cluster.
+func Fetch(kubeCfg *restclient.Config, plural, group string) (*Schema, error) {
+	cfg := *kubeCfg
+	cfg.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "apiextensions.k8s.io", Version: "v1beta1"}
+	cfg.APIPath = "/apis"
+	dc, err := dynamic.NewClient(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	resource := &metav1.APIResource{Name: "customresourcedefinitions", Namespaced: false}
+	crd, err := dc.Resource(resource, "").Get(plural+"."+group, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return FromCRD(crd.Object)
+}
+
+func decode(obj map[string]interface{}) (*Schema, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %s", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/validation"
+)
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"schema.yaml":     "type: object\nrequired: [spec]\n",
+		"validation.yaml": "openAPIV3Schema:\n  type: object\n  required: [spec]\n",
+		"invalid.yaml":    "type: [object",
+		"bad-type.yaml":   "required: spec\n",
+	}
+	for name, content := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	for _, name := range []string{"schema.yaml", "validation.yaml"} {
+		s, err := validation.LoadFile(filepath.Join(dir, name))
+		assert.Nil(t, err, name)
+		if assert.NotNil(t, s, name) {
+			assert.Equal(t, "object", s.Type, name)
+			assert.Equal(t, []string{"spec"}, s.Required, name)
+		}
+	}
+
+	s, err := validation.LoadFile("../test/data/crd_schema.yml")
+	assert.Nil(t, err)
+	if assert.NotNil(t, s) {
+		assert.Equal(t, "integer", s.Properties["spec"].Properties["replicas"].Type)
+	}
+
+	for _, name := range []string{"invalid.yaml", "bad-type.yaml", "missing.yaml"} {
+		_, err := validation.LoadFile(filepath.Join(dir, name))
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestFromCRD(t *testing.T) {
+	_, err := validation.FromCRD(map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "characters.stable.nicolerenee.io"},
+		"spec":     map[string]interface{}{"group": "stable.nicolerenee.io"},
+	})
+	assert.EqualError(t, err, "CustomResourceDefinition characters.stable.nicolerenee.io has no openAPIV3Schema")
+}
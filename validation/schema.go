@@ -0,0 +1,375 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validation checks custom resources against the openAPIV3Schema of
+// their CustomResourceDefinition.
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is the subset of an OpenAPI v3 schema supported by CRD validation.
+type Schema struct {
+	Type                 string                `json:"type,omitempty"`
+	Nullable             bool                  `json:"nullable,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	Properties           map[string]*Schema    `json:"properties,omitempty"`
+	AdditionalProperties *AdditionalProperties `json:"additionalProperties,omitempty"`
+	Items                *Schema               `json:"items,omitempty"`
+	Enum                 []interface{}         `json:"enum,omitempty"`
+	Minimum              *float64              `json:"minimum,omitempty"`
+	Maximum              *float64              `json:"maximum,omitempty"`
+	ExclusiveMinimum     bool                  `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     bool                  `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int64                `json:"minLength,omitempty"`
+	MaxLength            *int64                `json:"maxLength,omitempty"`
+	Pattern              string                `json:"pattern,omitempty"`
+	MinItems             *int64                `json:"minItems,omitempty"`
+	MaxItems             *int64                `json:"maxItems,omitempty"`
+	AllOf                []*Schema             `json:"allOf,omitempty"`
+	AnyOf                []*Schema             `json:"anyOf,omitempty"`
+	OneOf                []*Schema             `json:"oneOf,omitempty"`
+	Not                  *Schema               `json:"not,omitempty"`
+}
+
+// UnmarshalJSON will also accept a list of types, as used by the json schemas
+// generated from the Kubernetes OpenAPI spec. A "null" type is the same as
+// nullable, and the type isn't checked when more than one other type is given.
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	type plain Schema
+	var raw struct {
+		plain
+		Type interface{} `json:"type,omitempty"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*s = Schema(raw.plain)
+	switch t := raw.Type.(type) {
+	case nil:
+	case string:
+		s.Type = t
+	case []interface{}:
+		var types []string
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("invalid type %v", t)
+			}
+			if name == "null" {
+				s.Nullable = true
+			} else {
+				types = append(types, name)
+			}
+		}
+		if len(types) == 1 {
+			s.Type = types[0]
+		}
+	default:
+		return fmt.Errorf("invalid type %v", t)
+	}
+	return nil
+}
+
+// AdditionalProperties is either a boolean or a schema for the properties of
+// an object that aren't listed in Properties.
+type AdditionalProperties struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// UnmarshalJSON will accept either a boolean or a schema
+func (a *AdditionalProperties) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	a.Schema = &Schema{}
+	return json.Unmarshal(b, a.Schema)
+}
+
+// FieldError is a single problem with the value at Path
+type FieldError struct {
+	Path    string // dotted path to the field, ex: spec.ports[0].port
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Errors are all of the problems found while validating a value
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate will check the value against the schema. The error is Errors with
+// one FieldError for every problem found, or nil when the value is valid.
+func (s *Schema) Validate(value interface{}) error {
+	errs := s.validate("", value)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (s *Schema) validate(path string, value interface{}) Errors {
+	var errs Errors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil && s.Nullable {
+		return errs
+	}
+	if s.Type != "" && !hasType(s.Type, value) {
+		add("must be of type %s, not %s", s.Type, typeName(value))
+		return errs
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		add("must be one of %s", enumString(s.Enum))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		errs = append(errs, s.validateObject(path, v)...)
+	case []interface{}:
+		if s.MinItems != nil && int64(len(v)) < *s.MinItems {
+			add("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && int64(len(v)) > *s.MaxItems {
+			add("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case string:
+		length := int64(utf8.RuneCountInString(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			add("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			add("must be at most %d characters", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				add("can't be checked, the schema pattern %s is invalid: %s", s.Pattern, err)
+			} else if !re.MatchString(v) {
+				add("must match %s", s.Pattern)
+			}
+		}
+	default:
+		if n, ok := toFloat(value); ok {
+			errs = append(errs, s.validateNumber(path, n)...)
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		errs = append(errs, sub.validate(path, value)...)
+	}
+	if len(s.AnyOf) > 0 && matching(s.AnyOf, path, value) == 0 {
+		add("must match at least one of the anyOf schemas")
+	}
+	if len(s.OneOf) > 0 && matching(s.OneOf, path, value) != 1 {
+		add("must match exactly one of the oneOf schemas")
+	}
+	if s.Not != nil && len(s.Not.validate(path, value)) == 0 {
+		add("must not match the not schema")
+	}
+	return errs
+}
+
+func (s *Schema) validateObject(path string, obj map[string]interface{}) Errors {
+	var errs Errors
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			errs = append(errs, FieldError{Path: join(path, name), Message: "is required"})
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	// sort so that errors are reported in the same order every time
+	sort.Strings(keys)
+	for _, key := range keys {
+		if prop, ok := s.Properties[key]; ok {
+			errs = append(errs, prop.validate(join(path, key), obj[key])...)
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if !s.AdditionalProperties.Allowed {
+			errs = append(errs, FieldError{Path: join(path, key), Message: "is not a known field"})
+		} else if s.AdditionalProperties.Schema != nil {
+			errs = append(errs, s.AdditionalProperties.Schema.validate(join(path, key), obj[key])...)
+		}
+	}
+	return errs
+}
+
+func (s *Schema) validateNumber(path string, n float64) Errors {
+	var errs Errors
+	if s.Minimum != nil {
+		if s.ExclusiveMinimum && n <= *s.Minimum {
+			errs = append(errs, FieldError{Path: path, Message: "must be greater than " + formatFloat(*s.Minimum)})
+		} else if n < *s.Minimum {
+			errs = append(errs, FieldError{Path: path, Message: "must be at least " + formatFloat(*s.Minimum)})
+		}
+	}
+	if s.Maximum != nil {
+		if s.ExclusiveMaximum && n >= *s.Maximum {
+			errs = append(errs, FieldError{Path: path, Message: "must be less than " + formatFloat(*s.Maximum)})
+		} else if n > *s.Maximum {
+			errs = append(errs, FieldError{Path: path, Message: "must be at most " + formatFloat(*s.Maximum)})
+		}
+	}
+	return errs
+}
+
+func matching(schemas []*Schema, path string, value interface{}) int {
+	n := 0
+	for _, s := range schemas {
+		if len(s.validate(path, value)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func hasType(t string, value interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := toFloat(value)
+		return ok
+	case "integer":
+		n, ok := toFloat(value)
+		return ok && n == math.Trunc(n)
+	case "null":
+		return value == nil
+	}
+	// unknown types aren't checked
+	return true
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	if n, ok := toFloat(value); ok {
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// toFloat will convert any of the number types found in unstructured objects
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(e, value) {
+			return true
+		}
+		// numbers from yaml and json may not have the same go type
+		a, aOK := toFloat(e)
+		b, bOK := toFloat(value)
+		if aOK && bOK && a == b {
+			return true
+		}
+	}
+	return false
+}
+
+func enumString(enum []interface{}) string {
+	vals := make([]string, len(enum))
+	for i, e := range enum {
+		b, _ := json.Marshal(e)
+		vals[i] = string(b)
+	}
+	return "[" + strings.Join(vals, ", ") + "]"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
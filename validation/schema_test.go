@@ -0,0 +1,147 @@
+// Copyright 2017 the lostromos Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wpengine/lostromos/validation"
+)
+
+var testSchema = `{
+	"type": "object",
+	"required": ["spec"],
+	"properties": {
+		"spec": {
+			"type": "object",
+			"required": ["name"],
+			"additionalProperties": false,
+			"properties": {
+				"name": {"type": "string", "minLength": 1, "maxLength": 5, "pattern": "^[a-z]+$"},
+				"size": {"type": "string", "enum": ["small", "large"]},
+				"replicas": {"type": "integer", "minimum": 1, "maximum": 3},
+				"ratio": {"type": "number", "minimum": 0, "exclusiveMinimum": true},
+				"debug": {"type": "boolean"},
+				"ports": {
+					"type": "array",
+					"minItems": 1,
+					"maxItems": 2,
+					"items": {"type": "object", "required": ["port"], "properties": {"port": {"type": "integer"}}}
+				},
+				"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+				"image": {"anyOf": [{"type": "string"}, {"type": "object"}]},
+				"tier": {"oneOf": [{"enum": ["web"]}, {"type": "string", "pattern": "^w"}]},
+				"id": {"not": {"type": "string"}}
+			}
+		}
+	}
+}`
+
+func loadTestSchema(t *testing.T) *validation.Schema {
+	s := &validation.Schema{}
+	assert.Nil(t, json.Unmarshal([]byte(testSchema), s))
+	return s
+}
+
+func obj(spec map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"spec": spec}
+}
+
+func TestValidate(t *testing.T) {
+	s := loadTestSchema(t)
+	var testCases = []struct {
+		name  string
+		value map[string]interface{}
+		errs  []string
+	}{
+		{"valid", obj(map[string]interface{}{
+			"name":     "dory",
+			"size":     "small",
+			"replicas": int64(2),
+			"ratio":    0.5,
+			"debug":    true,
+			"ports":    []interface{}{map[string]interface{}{"port": float64(80)}},
+			"labels":   map[string]interface{}{"app": "dory"},
+			"image":    map[string]interface{}{"name": "nginx"},
+			"tier":     "worker",
+			"id":       int64(7),
+		}), nil},
+		{"missing spec", map[string]interface{}{}, []string{"spec: is required"}},
+		{"missing required field", obj(map[string]interface{}{}), []string{"spec.name: is required"}},
+		{"wrong type", obj(map[string]interface{}{"name": int64(1)}), []string{"spec.name: must be of type string, not integer"}},
+		{"string limits", obj(map[string]interface{}{"name": "Dory-Fish"}), []string{"spec.name: must be at most 5 characters", "spec.name: must match ^[a-z]+$"}},
+		{"empty string", obj(map[string]interface{}{"name": ""}), []string{"spec.name: must be at least 1 characters", "spec.name: must match ^[a-z]+$"}},
+		{"enum", obj(map[string]interface{}{"name": "dory", "size": "huge"}), []string{`spec.size: must be one of ["small", "large"]`}},
+		{"integer", obj(map[string]interface{}{"name": "dory", "replicas": 1.5}), []string{"spec.replicas: must be of type integer, not number"}},
+		{"whole float is an integer", obj(map[string]interface{}{"name": "dory", "replicas": float64(2)}), nil},
+		{"range", obj(map[string]interface{}{"name": "dory", "replicas": int64(4), "ratio": float64(0)}), []string{"spec.ratio: must be greater than 0", "spec.replicas: must be at most 3"}},
+		{"array", obj(map[string]interface{}{"name": "dory", "ports": []interface{}{}}), []string{"spec.ports: must have at least 1 items"}},
+		{"array items", obj(map[string]interface{}{"name": "dory", "ports": []interface{}{
+			map[string]interface{}{"port": "http"},
+			map[string]interface{}{},
+			map[string]interface{}{"port": int64(80)},
+		}}), []string{"spec.ports: must have at most 2 items", "spec.ports[0].port: must be of type integer, not string", "spec.ports[1].port: is required"}},
+		{"unknown field", obj(map[string]interface{}{"name": "dory", "color": "blue"}), []string{"spec.color: is not a known field"}},
+		{"additional properties schema", obj(map[string]interface{}{"name": "dory", "labels": map[string]interface{}{"app": true}}), []string{"spec.labels.app: must be of type string, not boolean"}},
+		{"anyOf", obj(map[string]interface{}{"name": "dory", "image": int64(1)}), []string{"spec.image: must match at least one of the anyOf schemas"}},
+		{"oneOf", obj(map[string]interface{}{"name": "dory", "tier": "web"}), []string{"spec.tier: must match exactly one of the oneOf schemas"}},
+		{"not", obj(map[string]interface{}{"name": "dory", "id": "seven"}), []string{"spec.id: must not match the not schema"}},
+	}
+
+	for _, tt := range testCases {
+		err := s.Validate(tt.value)
+		if tt.errs == nil {
+			assert.Nil(t, err, tt.name)
+			continue
+		}
+		errs, ok := err.(validation.Errors)
+		assert.True(t, ok, tt.name)
+		msgs := []string{}
+		for _, fe := range errs {
+			msgs = append(msgs, fe.Error())
+		}
+		assert.Equal(t, tt.errs, msgs, tt.name)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	errs := validation.Errors{
+		{Path: "spec.name", Message: "is required"},
+		{Path: "", Message: "must be of type object, not string"},
+	}
+	assert.Equal(t, "spec.name: is required; must be of type object, not string", errs.Error())
+}
+
+func TestUnmarshalTypeList(t *testing.T) {
+	s := &validation.Schema{}
+	err := json.Unmarshal([]byte(`{"type": "object", "properties": {"name": {"type": ["string", "null"]}}}`), s)
+	assert.Nil(t, err)
+	assert.Equal(t, "object", s.Type)
+	assert.Equal(t, "string", s.Properties["name"].Type)
+	assert.True(t, s.Properties["name"].Nullable)
+	assert.Nil(t, s.Validate(map[string]interface{}{"name": nil}))
+	assert.NotNil(t, s.Validate(map[string]interface{}{"name": int64(1)}))
+
+	s = &validation.Schema{}
+	err = json.Unmarshal([]byte(`{"type": ["string", "integer"]}`), s)
+	assert.Nil(t, err)
+	assert.Equal(t, "", s.Type)
+	assert.Nil(t, s.Validate(true))
+
+	err = json.Unmarshal([]byte(`{"type": [1]}`), s)
+	assert.NotNil(t, err)
+}